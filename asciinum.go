@@ -0,0 +1,126 @@
+package restruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidASCII is returned when an ASCII number format is used on an
+// invalid type.
+var ErrInvalidASCII = errors.New("ascii specified on invalid type")
+
+// asciiFormat specifies the base of an integer stored as ASCII text.
+type asciiFormat int
+
+const (
+	asciiNone    asciiFormat = 0
+	asciiOctal   asciiFormat = 8
+	asciiDecimal asciiFormat = 10
+	asciiHex     asciiFormat = 16
+)
+
+var asciiFormats = map[string]asciiFormat{
+	"octal":   asciiOctal,
+	"decimal": asciiDecimal,
+	"hex":     asciiHex,
+}
+
+func (af asciiFormat) String() string {
+	for name, f := range asciiFormats {
+		if f == af {
+			return name
+		}
+	}
+	return "none"
+}
+
+// validASCIIType returns true if an ASCII number format can be used with the
+// given native and binary types.
+func validASCIIType(native, binary reflect.Type) bool {
+	if binary.Kind() != reflect.Array || binary.Elem().Kind() != reflect.Uint8 || binary.Len() == 0 {
+		return false
+	}
+	if _, _, ok := wideInt(binary); ok {
+		return false
+	}
+	switch native.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// readASCII reads an integer stored as ASCII text into v. Text after the
+// first terminator following the digits is ignored, as are surrounding spaces
+// and NULs.
+func (d *decoder) readASCII(f field, v reflect.Value) {
+	b := make([]byte, f.BinaryType.Len())
+	d.readBits(f, b)
+
+	text := string(b)
+	if f.isPadded() {
+		text = fixedString(f, b)
+	}
+	text = strings.TrimLeft(text, " \x00")
+	if f.Terminator != nil {
+		if i := strings.Index(text, string(f.Terminator)); i != -1 {
+			text = text[:i]
+		}
+	}
+	text = strings.TrimRight(text, " \x00")
+	if text == "" {
+		text = "0"
+	}
+
+	invalid := func() {
+		panic(fmt.Errorf("%s: invalid %s number %q", f.Name, f.ASCII, text))
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(text, int(f.ASCII), 64)
+		if err != nil || v.OverflowInt(x) {
+			invalid()
+		}
+		v.SetInt(x)
+	default:
+		x, err := strconv.ParseUint(text, int(f.ASCII), 64)
+		if err != nil || v.OverflowUint(x) {
+			invalid()
+		}
+		v.SetUint(x)
+	}
+}
+
+// writeASCII writes v as ASCII text. Digits are zero filled unless a pad is
+// given, and followed by the terminator, if any.
+func (e *encoder) writeASCII(f field, v reflect.Value) {
+	var text string
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		text = strconv.FormatInt(v.Int(), int(f.ASCII))
+	default:
+		text = strconv.FormatUint(v.Uint(), int(f.ASCII))
+	}
+
+	size := f.BinaryType.Len() - len(f.Terminator)
+	if len(text) > size {
+		panic(fmt.Errorf("%s: value %s does not fit in %d %s digits", f.Name, text, size, f.ASCII))
+	}
+
+	var b []byte
+	if f.isPadded() {
+		b = padFixed(f, []byte(text), size)
+	} else {
+		sign := ""
+		if strings.HasPrefix(text, "-") {
+			sign, text = "-", text[1:]
+		}
+		b = []byte(sign + strings.Repeat("0", size-len(sign)-len(text)) + text)
+	}
+	e.writeBits(f, append(b, f.Terminator...))
+}
@@ -0,0 +1,205 @@
+package restruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidDecimal is returned when a decimal encoding is used on an invalid
+// type.
+var ErrInvalidDecimal = errors.New("decimal encoding specified on invalid type")
+
+// decimalFormat specifies a binary-coded decimal encoding.
+type decimalFormat int
+
+const (
+	// decimalNone is the zero value, for fields that are not decimal.
+	decimalNone decimalFormat = iota
+
+	// decimalBCD stores two digits per byte, high nibble first.
+	decimalBCD
+
+	// decimalSwapped stores two digits per byte, low nibble first.
+	decimalSwapped
+
+	// decimalPacked is COBOL packed decimal (COMP-3): digits high nibble
+	// first, followed by a sign nibble.
+	decimalPacked
+)
+
+// validDecimalType returns true if a decimal encoding can be used with the
+// given native and binary types.
+func validDecimalType(native, binary reflect.Type) bool {
+	if binary.Kind() != reflect.Array || binary.Elem().Kind() != reflect.Uint8 || binary.Len() == 0 {
+		return false
+	}
+	if _, _, ok := wideInt(binary); ok {
+		return false
+	}
+	switch native.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.String:
+		return true
+	}
+	return false
+}
+
+// decode returns the decimal digits stored in b, and whether the value is
+// negative. Filler nibbles (0xF) at the end of BCD values are dropped.
+func (df decimalFormat) decode(f field, b []byte) (string, bool) {
+	nibbles := make([]byte, 0, len(b)*2)
+	for _, c := range b {
+		if df == decimalSwapped {
+			nibbles = append(nibbles, c&0xF, c>>4)
+		} else {
+			nibbles = append(nibbles, c>>4, c&0xF)
+		}
+	}
+
+	neg := false
+	if df == decimalPacked {
+		switch sign := nibbles[len(nibbles)-1]; sign {
+		case 0xA, 0xC, 0xE, 0xF:
+		case 0xB, 0xD:
+			neg = true
+		default:
+			panic(fmt.Errorf("%s: invalid packed decimal sign nibble %#x", f.Name, sign))
+		}
+		nibbles = nibbles[:len(nibbles)-1]
+	} else {
+		for len(nibbles) > 0 && nibbles[len(nibbles)-1] == 0xF {
+			nibbles = nibbles[:len(nibbles)-1]
+		}
+	}
+
+	digits := make([]byte, len(nibbles))
+	for i, n := range nibbles {
+		if n > 9 {
+			panic(fmt.Errorf("%s: invalid decimal digit nibble %#x", f.Name, n))
+		}
+		digits[i] = '0' + n
+	}
+	return string(digits), neg
+}
+
+// encode returns size bytes holding the given digits. Numbers are padded
+// with leading zeros, while digit strings are padded with trailing filler.
+func (df decimalFormat) encode(f field, size int, digits string, neg, filler, unsigned bool) []byte {
+	capacity := size * 2
+	if df == decimalPacked {
+		capacity--
+	}
+	if len(digits) > capacity {
+		panic(fmt.Errorf("%s: value has %d digits, but only %d fit", f.Name, len(digits), capacity))
+	}
+
+	nibbles := make([]byte, 0, size*2)
+	if !filler || df == decimalPacked {
+		for i := len(digits); i < capacity; i++ {
+			nibbles = append(nibbles, 0)
+		}
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			panic(fmt.Errorf("%s: invalid decimal digit %q", f.Name, digits[i]))
+		}
+		nibbles = append(nibbles, digits[i]-'0')
+	}
+	for len(nibbles) < capacity {
+		nibbles = append(nibbles, 0xF)
+	}
+
+	if df == decimalPacked {
+		switch {
+		case neg:
+			nibbles = append(nibbles, 0xD)
+		case unsigned:
+			nibbles = append(nibbles, 0xF)
+		default:
+			nibbles = append(nibbles, 0xC)
+		}
+	}
+
+	b := make([]byte, size)
+	for i := range b {
+		hi, lo := nibbles[i*2], nibbles[i*2+1]
+		if df == decimalSwapped {
+			hi, lo = lo, hi
+		}
+		b[i] = hi<<4 | lo
+	}
+	return b
+}
+
+// readDecimal reads a decimal encoded number into v.
+func (d *decoder) readDecimal(f field, v reflect.Value) {
+	b := make([]byte, f.BinaryType.Len())
+	d.readBits(f, b)
+	digits, neg := f.Decimal.decode(f, b)
+
+	if v.Kind() == reflect.String {
+		if neg {
+			digits = "-" + digits
+		}
+		v.SetString(digits)
+		return
+	}
+
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		digits = "0"
+	}
+	if neg {
+		digits = "-" + digits
+	}
+
+	overflow := func() {
+		panic(fmt.Errorf("%s: value %s overflows %s", f.Name, digits, v.Type()))
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil || v.OverflowInt(x) {
+			overflow()
+		}
+		v.SetInt(x)
+	default:
+		x, err := strconv.ParseUint(strings.TrimPrefix(digits, "-"), 10, 64)
+		if err != nil || neg && x != 0 || v.OverflowUint(x) {
+			overflow()
+		}
+		v.SetUint(x)
+	}
+}
+
+// writeDecimal writes v as a decimal encoded number.
+func (e *encoder) writeDecimal(f field, v reflect.Value) {
+	var digits string
+	neg, filler, unsigned := false, false, false
+
+	switch v.Kind() {
+	case reflect.String:
+		digits, filler = v.String(), true
+		if f.Decimal == decimalPacked && strings.HasPrefix(digits, "-") {
+			digits, neg = digits[1:], true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := v.Int()
+		if x < 0 && f.Decimal != decimalPacked {
+			panic(fmt.Errorf("%s: negative value %d cannot be stored as BCD", f.Name, x))
+		}
+		digits = strconv.FormatInt(x, 10)
+		if x < 0 {
+			digits, neg = digits[1:], true
+		}
+	default:
+		digits, unsigned = strconv.FormatUint(v.Uint(), 10), true
+	}
+
+	e.writeBits(f, f.Decimal.encode(f, f.BinaryType.Len(), digits, neg, filler, unsigned))
+}
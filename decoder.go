@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"math"
 	"reflect"
-	"strings"
 )
 
 // Unpacker is a type capable of unpacking a binary representation of itself
@@ -19,10 +18,9 @@ type Unpacker interface {
 
 type decoder struct {
 	structstack
-	order      binary.ByteOrder
-	sfields    []field
-	bitCounter uint8
-	bitSize    int
+	order   binary.ByteOrder
+	sfields []field
+	bitSize int
 }
 
 func putBit(buf []byte, bitSize int, bit int, val byte) {
@@ -150,8 +148,92 @@ func (d *decoder) readBytes(count int) []byte {
 	return x
 }
 
+// readTerminated reads a slice or string that ends with f.Terminator. The
+// terminator is only matched on element boundaries.
+func (d *decoder) readTerminated(f field, v reflect.Value) {
+	if d.bitCounter != 0 {
+		panic(fmt.Errorf("%s: terminated field is not byte-aligned", f.Name))
+	}
+
+	term := f.Terminator
+	ef := f.Elem()
+	unit := f.unitSize()
+	if f.NativeType.Kind() != reflect.String {
+		unit = d.fieldbytes(ef, reflect.New(ef.NativeType).Elem())
+	}
+
+	n := indexUnit(d.buf, term, unit)
+	if n == -1 {
+		panic(fmt.Errorf("%s: terminator not found", f.Name))
+	}
+
+	if f.Flags&IncludeFlag != 0 {
+		n += len(term)
+		if n%unit != 0 {
+			panic(fmt.Errorf("%s: terminator is not a whole number of elements", f.Name))
+		}
+	}
+
+	switch f.NativeType.Kind() {
+	case reflect.String:
+		v.SetString(decodeString(f, d.readBytes(n)))
+	case reflect.Slice:
+		alen := n / unit
+		if ef.NativeType.Kind() == reflect.Uint8 {
+			v.SetBytes(d.readBytes(alen))
+		} else {
+			v.Set(reflect.MakeSlice(f.NativeType, alen, alen))
+			for i := 0; i < alen; i++ {
+				d.readElem(ef, v.Index(i), i)
+			}
+		}
+	}
+
+	if f.Flags&(IncludeFlag|NoConsumeFlag) == 0 {
+		d.readBytes(len(term))
+	}
+}
+
+// readRest reads a slice or string from all of the remaining input.
+func (d *decoder) readRest(f field, v reflect.Value) {
+	if d.bitCounter != 0 {
+		panic(fmt.Errorf("%s: rest field is not byte-aligned", f.Name))
+	}
+
+	switch f.NativeType.Kind() {
+	case reflect.String:
+		if len(d.buf)%f.unitSize() != 0 {
+			panic(fmt.Errorf("%s: remaining input is not a whole number of code units", f.Name))
+		}
+		v.SetString(decodeString(f, d.readBytes(len(d.buf))))
+	case reflect.Slice:
+		ef := f.Elem()
+		switch {
+		case ef.NativeType.Kind() == reflect.Uint8:
+			v.SetBytes(d.readBytes(len(d.buf)))
+		case ef.Trivial:
+			unit := d.fieldbytes(ef, reflect.New(ef.NativeType).Elem())
+			if unit == 0 || len(d.buf)%unit != 0 {
+				panic(fmt.Errorf("%s: remaining input is not a whole number of elements", f.Name))
+			}
+			alen := len(d.buf) / unit
+			v.Set(reflect.MakeSlice(f.NativeType, alen, alen))
+			for i := 0; i < alen; i++ {
+				d.readElem(ef, v.Index(i), i)
+			}
+		default:
+			v.Set(reflect.MakeSlice(f.NativeType, 0, 0))
+			for len(d.buf) > 0 {
+				nv := reflect.New(ef.NativeType).Elem()
+				d.readElem(ef, nv, v.Len())
+				v.Set(reflect.Append(v, nv))
+			}
+		}
+	}
+}
+
 func (d *decoder) skipBits(count int) {
-	d.bitCounter += uint8(count % 8)
+	d.bitCounter += count % 8
 	if d.bitCounter > 8 {
 		d.bitCounter -= 8
 		count += 8
@@ -180,6 +262,19 @@ func (d *decoder) unpacker(v reflect.Value) (Unpacker, bool) {
 }
 
 func (d *decoder) setUint(f field, v reflect.Value, x uint64) {
+	if f.Time != timeNone {
+		setTime(f, v, x)
+		return
+	}
+
+	if f.Fixed != nil && v.Type() == fixedType {
+		if x > math.MaxInt64 {
+			panic(fmt.Errorf("%s: value %d overflows %s", f.Name, x, v.Type()))
+		}
+		v.Set(reflect.ValueOf(f.Fixed.unpackFixed(int64(x))))
+		return
+	}
+
 	switch v.Kind() {
 	case reflect.Bool:
 		b := x != 0
@@ -187,12 +282,34 @@ func (d *decoder) setUint(f field, v reflect.Value, x uint64) {
 			b = !b
 		}
 		v.SetBool(b)
+	case reflect.Struct:
+		unpackFlags(f, v, x)
+	case reflect.Float32, reflect.Float64:
+		if f.Fixed != nil {
+			v.SetFloat(f.Fixed.unpackUint(x))
+			break
+		}
+		ff, ok := smallFloat(f.BinaryType)
+		if !ok {
+			panic(fmt.Errorf("%s: cannot read %s into %s", f.Name, f.BinaryType, v.Type()))
+		}
+		v.SetFloat(ff.unpack(x))
 	default:
 		v.SetUint(x)
 	}
 }
 
 func (d *decoder) setInt(f field, v reflect.Value, x int64) {
+	if f.Time != timeNone {
+		setTime(f, v, uint64(x))
+		return
+	}
+
+	if f.Fixed != nil && v.Type() == fixedType {
+		v.Set(reflect.ValueOf(f.Fixed.unpackFixed(x)))
+		return
+	}
+
 	switch v.Kind() {
 	case reflect.Bool:
 		b := x != 0
@@ -200,6 +317,13 @@ func (d *decoder) setInt(f field, v reflect.Value, x int64) {
 			b = !b
 		}
 		v.SetBool(b)
+	case reflect.Struct:
+		unpackFlags(f, v, uint64(x))
+	case reflect.Float32, reflect.Float64:
+		if f.Fixed == nil {
+			panic(fmt.Errorf("%s: cannot read %s into %s", f.Name, f.BinaryType, v.Type()))
+		}
+		v.SetFloat(f.Fixed.unpack(x))
 	default:
 		v.SetInt(x)
 	}
@@ -217,7 +341,7 @@ func (d *decoder) switc(f field, v reflect.Value, on interface{}) {
 
 	// Zero out values for decoding.
 	for i := 0; i < l; i++ {
-		v := v.Field(f.Index)
+		v := v.Field(sfields[i].Index)
 		v.Set(reflect.Zero(v.Type()))
 	}
 
@@ -237,7 +361,7 @@ func (d *decoder) switc(f field, v reflect.Value, on interface{}) {
 			panic(fmt.Errorf("%s: only cases are valid inside switches", f.Name))
 		}
 
-		if d.evalExpr(f.CaseExpr) == on {
+		if d.matchCase(f, on) {
 			d.read(f, v)
 			return
 		}
@@ -248,6 +372,13 @@ func (d *decoder) switc(f field, v reflect.Value, on interface{}) {
 	}
 }
 
+// readElem reads the array element at index i.
+func (d *decoder) readElem(f field, v reflect.Value, i int) {
+	index, inelem := d.enterElem(i)
+	d.read(f, v)
+	d.leaveElem(index, inelem)
+}
+
 func (d *decoder) read(f field, v reflect.Value) {
 	if f.Flags&RootFlag == RootFlag {
 		d.setancestor(f, v, d.root())
@@ -317,6 +448,12 @@ func (d *decoder) read(f field, v reflect.Value) {
 				alen = int(sv.Int())
 			case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 				alen = int(sv.Uint())
+			case reflect.Array:
+				if _, signed, ok := wideInt(sf.BinaryType); ok {
+					alen = int(bigFromNative(sv, signed).Int64())
+					break
+				}
+				fallthrough
 			default:
 				panic(fmt.Errorf("unsupported size type %s: %s", sf.BinaryType.String(), sf.Name))
 			}
@@ -326,6 +463,23 @@ func (d *decoder) read(f field, v reflect.Value) {
 
 	switch f.BinaryType.Kind() {
 	case reflect.Array:
+		if size, signed, ok := wideInt(f.BinaryType); ok {
+			d.readWide(f, v, size, signed)
+			break
+		}
+		if f.Decimal != decimalNone {
+			d.readDecimal(f, v)
+			break
+		}
+		if f.ASCII != asciiNone {
+			d.readASCII(f, v)
+			break
+		}
+		if f.UUID != uuidNone {
+			d.readUUID(f, v)
+			break
+		}
+
 		l := f.BinaryType.Len()
 
 		// If the underlying value is a slice, initialize it.
@@ -336,22 +490,24 @@ func (d *decoder) read(f field, v reflect.Value) {
 		switch f.NativeType.Kind() {
 		case reflect.String:
 			// When using strings, treat as C string.
-			str := string(d.readBytes(d.fieldbytes(f, v)))
-			nul := strings.IndexByte(str, 0)
-			if nul != -1 {
-				str = str[0:nul]
-			}
-			v.SetString(str)
+			v.SetString(fixedString(f, d.readBytes(d.fieldbytes(f, v))))
 		case reflect.Slice, reflect.Array:
 			ef := f.Elem()
+			if d.readSmallFloats(ef, v, l) {
+				break
+			}
 			for i := 0; i < l; i++ {
-				d.read(ef, v.Index(i))
+				d.readElem(ef, v.Index(i), i)
 			}
 		default:
 			panic(fmt.Errorf("invalid array cast type: %s", f.NativeType.String()))
 		}
 
 	case reflect.Struct:
+		if f.SizeExpr != nil {
+			buf, size := d.limit(f, alen)
+			defer d.unlimit(buf, size, alen)
+		}
 		d.push(v)
 		d.sfields = cachedFieldsFromStruct(f.BinaryType)
 		l := len(d.sfields)
@@ -372,26 +528,37 @@ func (d *decoder) read(f field, v reflect.Value) {
 		d.read(f.Elem(), v.Elem())
 
 	case reflect.Slice, reflect.String:
+		if f.Terminator != nil {
+			d.readTerminated(f, v)
+			break
+		}
+		if f.Flags&RestFlag != 0 {
+			d.readRest(f, v)
+			break
+		}
 		fixed := func() {
 			switch f.NativeType.Elem().Kind() {
 			case reflect.Uint8:
 				v.SetBytes(d.readBytes(d.fieldbytes(f, v)))
 			default:
 				ef := f.Elem()
+				if d.readSmallFloats(ef, v, alen) {
+					return
+				}
 				for i := 0; i < alen; i++ {
-					d.read(ef, v.Index(i))
+					d.readElem(ef, v.Index(i), i)
 				}
 			}
 		}
 		switch f.NativeType.Kind() {
 		case reflect.String:
-			v.SetString(string(d.readBytes(alen)))
+			v.SetString(decodeString(f, d.readBytes(alen*f.unitSize())))
 		case reflect.Array:
 			if f.WhileExpr != nil {
 				i := 0
 				ef := f.Elem()
 				for d.evalWhile(f) {
-					d.read(ef, v.Index(i))
+					d.readElem(ef, v.Index(i), i)
 					i++
 				}
 			} else {
@@ -404,9 +571,26 @@ func (d *decoder) read(f field, v reflect.Value) {
 					ef := f.Elem()
 					for d.evalWhile(f) {
 						nv := reflect.New(ef.NativeType).Elem()
-						d.read(ef, nv)
+						d.readElem(ef, nv, v.Len())
+						v.Set(reflect.Append(v, nv))
+					}
+				}
+			} else if f.UntilExpr != nil {
+				ef := f.Elem()
+				v.Set(reflect.MakeSlice(f.NativeType, 0, 0))
+				for i := 0; ; i++ {
+					if len(d.buf) == 0 {
+						panic(fmt.Errorf("%s: unexpected eof before until condition was met", f.Name))
+					}
+					nv := reflect.New(ef.NativeType).Elem()
+					d.readElem(ef, nv, i)
+					done := d.evalUntil(f, nv, i)
+					if !done || f.Flags&ExcludeFlag == 0 {
 						v.Set(reflect.Append(v, nv))
 					}
+					if done {
+						break
+					}
 				}
 			} else {
 				v.Set(reflect.MakeSlice(f.NativeType, alen, alen))
@@ -454,4 +638,19 @@ func (d *decoder) read(f field, v reflect.Value) {
 	if f.InExpr != nil {
 		v.Set(reflect.ValueOf(d.evalExpr(f.InExpr)))
 	}
+
+	if f.Flags&StrictFlag != 0 {
+		switch v.Kind() {
+		case reflect.Array, reflect.Slice:
+			// Byte elements are read in bulk, bypassing the element check.
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				for i := 0; i < v.Len(); i++ {
+					checkEnum(v.Index(i))
+				}
+			}
+		default:
+			checkEnum(v)
+			checkFlags(v)
+		}
+	}
 }
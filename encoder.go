@@ -1,9 +1,11 @@
 package restruct
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math"
+	"math/big"
 	"reflect"
 )
 
@@ -21,10 +23,9 @@ type Packer interface {
 
 type encoder struct {
 	structstack
-	order      binary.ByteOrder
-	sfields    []field
-	bitCounter int
-	bitSize    int
+	order   binary.ByteOrder
+	sfields []field
+	bitSize int
 }
 
 func getBit(buf []byte, bitSize int, bit int) byte {
@@ -139,6 +140,14 @@ func (e *encoder) packer(v reflect.Value) (Packer, bool) {
 }
 
 func (e *encoder) intFromField(f field, v reflect.Value) int64 {
+	if f.Time != timeNone {
+		return int64(timeBits(f, v))
+	}
+
+	if f.Fixed != nil && v.Type() == fixedType {
+		return f.Fixed.packFixed(f, v.Interface().(Fixed)).Int64()
+	}
+
 	switch v.Kind() {
 	case reflect.Bool:
 		b := v.Bool()
@@ -152,12 +161,27 @@ func (e *encoder) intFromField(f field, v reflect.Value) int64 {
 			return 1
 		}
 		return 0
+	case reflect.Struct:
+		return int64(packFlags(v))
+	case reflect.Float32, reflect.Float64:
+		if f.Fixed == nil {
+			panic(fmt.Errorf("%s: cannot write %s as %s", f.Name, v.Type(), f.BinaryType))
+		}
+		return f.Fixed.pack(f, v.Float())
 	default:
 		return v.Int()
 	}
 }
 
 func (e *encoder) uintFromField(f field, v reflect.Value) uint64 {
+	if f.Time != timeNone {
+		return timeBits(f, v)
+	}
+
+	if f.Fixed != nil && v.Type() == fixedType {
+		return f.Fixed.packFixed(f, v.Interface().(Fixed)).Uint64()
+	}
+
 	switch v.Kind() {
 	case reflect.Bool:
 		b := v.Bool()
@@ -171,6 +195,17 @@ func (e *encoder) uintFromField(f field, v reflect.Value) uint64 {
 			return 1
 		}
 		return 0
+	case reflect.Struct:
+		return packFlags(v)
+	case reflect.Float32, reflect.Float64:
+		if f.Fixed != nil {
+			return f.Fixed.packUint(f, v.Float())
+		}
+		ff, ok := smallFloat(f.BinaryType)
+		if !ok {
+			panic(fmt.Errorf("%s: cannot write %s as %s", f.Name, v.Type(), f.BinaryType))
+		}
+		return ff.pack(v.Float())
 	default:
 		return v.Uint()
 	}
@@ -202,7 +237,7 @@ func (e *encoder) switc(f field, v reflect.Value, on interface{}) {
 			panic(fmt.Errorf("%s: only cases are valid inside switches", f.Name))
 		}
 
-		if e.evalExpr(f.CaseExpr) == on {
+		if e.matchCase(f, on) {
 			e.write(f, v)
 			return
 		}
@@ -213,6 +248,73 @@ func (e *encoder) switc(f field, v reflect.Value, on interface{}) {
 	}
 }
 
+// checkUntil ensures that a slice terminated by an until expression will
+// decode back to the same elements: only the terminator may satisfy the
+// condition.
+func (e *encoder) checkUntil(f field, v reflect.Value) {
+	l := v.Len()
+	for i := 0; i < l; i++ {
+		done := e.evalUntil(f, v.Index(i), i)
+		last := i == l-1 && f.Flags&ExcludeFlag == 0
+		if done && !last {
+			panic(fmt.Errorf("%s: element %d satisfies until condition before end of slice", f.Name, i))
+		} else if !done && last {
+			panic(fmt.Errorf("%s: last element does not satisfy until condition", f.Name))
+		}
+	}
+	if f.Flags&ExcludeFlag != 0 {
+		ef := f.Elem()
+		if !e.evalUntil(f, reflect.New(ef.NativeType).Elem(), l) {
+			panic(fmt.Errorf("%s: zero element does not satisfy until condition", f.Name))
+		}
+	} else if l == 0 {
+		panic(fmt.Errorf("%s: last element does not satisfy until condition", f.Name))
+	}
+}
+
+// checkTerminated ensures that a terminated string or slice will decode back
+// to the same value. Elements other than bytes are encoded on their own so
+// that the terminator is matched on element boundaries, as when decoding.
+func (e *encoder) checkTerminated(f field, v reflect.Value) {
+	var data []byte
+	unit := f.unitSize()
+	switch {
+	case v.Kind() == reflect.String:
+		data = encodeString(f, v.String())
+	case v.Type().Elem().Kind() == reflect.Uint8:
+		data = v.Bytes()
+	default:
+		ef := f.Elem()
+		unit = e.fieldbytes(ef, reflect.New(ef.NativeType).Elem())
+		data = make([]byte, v.Len()*unit)
+		elems := encoder{structstack: structstack{env: e.env, allowexpr: e.allowexpr, buf: data, size: len(data), stack: e.stack}, order: e.order}
+		for i := 0; i < v.Len(); i++ {
+			elems.writeElem(ef, v.Index(i), i)
+		}
+	}
+
+	expect := -1
+	if f.Flags&IncludeFlag != 0 {
+		expect = len(data) - len(f.Terminator)
+		if !bytes.HasSuffix(data, f.Terminator) {
+			panic(fmt.Errorf("%s: value does not end with terminator", f.Name))
+		}
+		if expect%unit != 0 {
+			panic(fmt.Errorf("%s: terminator is not a whole number of elements", f.Name))
+		}
+	}
+	if indexUnit(data, f.Terminator, unit) != expect {
+		panic(fmt.Errorf("%s: value contains terminator", f.Name))
+	}
+}
+
+// writeElem writes the array element at index i.
+func (e *encoder) writeElem(f field, v reflect.Value, i int) {
+	index, inelem := e.enterElem(i)
+	e.write(f, v)
+	e.leaveElem(index, inelem)
+}
+
 func (e *encoder) write(f field, v reflect.Value) {
 	if f.Flags&RootFlag == RootFlag {
 		e.setancestor(f, v, e.root())
@@ -270,12 +372,25 @@ func (e *encoder) write(f field, v reflect.Value) {
 	// If this is a sizeof field, pull the current slice length into it.
 	if f.TIndex != -1 {
 		sv := struc.Field(f.TIndex)
+		slen := sv.Len()
+		for _, tf := range sfields {
+			if tf.Index == f.TIndex {
+				slen = encodedLen(tf, sv)
+				break
+			}
+		}
 
 		switch f.BinaryType.Kind() {
 		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			v.SetInt(int64(sv.Len()))
+			v.SetInt(int64(slen))
 		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			v.SetUint(uint64(sv.Len()))
+			v.SetUint(uint64(slen))
+		case reflect.Array:
+			if _, signed, ok := wideInt(f.BinaryType); ok {
+				setBigNative(f, v, big.NewInt(int64(slen)), signed)
+				break
+			}
+			fallthrough
 		default:
 			panic(fmt.Errorf("unsupported size type %s: %s", f.BinaryType.String(), f.Name))
 		}
@@ -286,6 +401,11 @@ func (e *encoder) write(f field, v reflect.Value) {
 		ov = reflect.ValueOf(e.evalExpr(f.OutExpr))
 	}
 
+	if f.Flags&StrictFlag != 0 {
+		checkEnum(ov)
+		checkFlags(ov)
+	}
+
 	switch f.BinaryType.Kind() {
 	case reflect.Ptr:
 		// Skip if pointer is nil.
@@ -296,13 +416,42 @@ func (e *encoder) write(f field, v reflect.Value) {
 		e.write(f.Elem(), v.Elem())
 
 	case reflect.Array, reflect.Slice, reflect.String:
+		if size, signed, ok := wideInt(f.BinaryType); ok {
+			e.writeWide(f, ov, size, signed)
+			break
+		}
+		if f.Decimal != decimalNone {
+			e.writeDecimal(f, ov)
+			break
+		}
+		if f.ASCII != asciiNone {
+			e.writeASCII(f, ov)
+			break
+		}
+		if f.UUID != uuidNone {
+			e.writeUUID(f, ov)
+			break
+		}
+
 		switch f.NativeType.Kind() {
 		case reflect.Slice, reflect.String:
 			if f.SizeExpr != nil {
-				if l := e.evalSize(f); l != ov.Len() {
-					panic(fmt.Errorf("length does not match size expression (%d != %d)", ov.Len(), l))
+				if l, n := e.evalSize(f), encodedLen(f, ov); l != n {
+					panic(fmt.Errorf("length does not match size expression (%d != %d)", n, l))
 				}
 			}
+			if f.UntilExpr != nil {
+				e.checkUntil(f, ov)
+			}
+			if f.Terminator != nil {
+				e.checkTerminated(f, ov)
+			}
+			switch {
+			case f.isPadded():
+				ov = reflect.ValueOf(padFixed(f, encodeString(f, ov.String()), f.BinaryType.Len()))
+			case f.Encoding != nil:
+				ov = reflect.ValueOf(encodeString(f, ov.String()))
+			}
 			fallthrough
 		case reflect.Array:
 			ef := f.Elem()
@@ -310,18 +459,38 @@ func (e *encoder) write(f field, v reflect.Value) {
 			cap := len
 			if f.BinaryType.Kind() == reflect.Array {
 				cap = f.BinaryType.Len()
+				if len > cap {
+					panic(fmt.Errorf("%s: length %d exceeds fixed size %d", f.Name, len, cap))
+				}
 			}
-			for i := 0; i < len; i++ {
-				e.write(ef, ov.Index(i))
+			if !e.writeSmallFloats(ef, ov, len) {
+				for i := 0; i < len; i++ {
+					e.writeElem(ef, ov.Index(i), i)
+				}
 			}
+			pf := ef
+			pf.Flags &^= StrictFlag
 			for i := len; i < cap; i++ {
-				e.write(ef, reflect.New(f.BinaryType.Elem()).Elem())
+				e.writeElem(pf, reflect.New(f.BinaryType.Elem()).Elem(), i)
+			}
+			if f.UntilExpr != nil && f.Flags&ExcludeFlag != 0 {
+				e.write(ef, reflect.New(ef.NativeType).Elem())
+			}
+			if f.Terminator != nil && f.Flags&(IncludeFlag|NoConsumeFlag) == 0 {
+				for _, b := range f.Terminator {
+					e.write8(f, b)
+				}
 			}
 		default:
 			panic(fmt.Errorf("invalid array cast type: %s", f.NativeType.String()))
 		}
 
 	case reflect.Struct:
+		if f.SizeExpr != nil {
+			n := e.evalSize(f)
+			buf, size := e.limit(f, n)
+			defer e.unlimit(buf, size, n)
+		}
 		e.push(ov)
 		e.sfields = cachedFieldsFromStruct(f.BinaryType)
 		l := len(e.sfields)
@@ -0,0 +1,363 @@
+package restruct
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrInvalidEncoding is returned when a character encoding is used on an
+// invalid type.
+var ErrInvalidEncoding = errors.New("encoding specified on non-string type")
+
+// Encoding is a character encoding for string fields. Strings are always
+// UTF-8 in Go; an encoding converts them to and from their binary form.
+type Encoding interface {
+	// Encode converts a UTF-8 string to its binary form.
+	Encode(s string) ([]byte, error)
+
+	// Decode converts a binary string to UTF-8.
+	Decode(b []byte) (string, error)
+
+	// UnitSize returns the size of a code unit in bytes. Lengths of encoded
+	// strings are counted in code units, and terminators are only matched
+	// on code unit boundaries.
+	UnitSize() int
+}
+
+type utf8Encoding struct{}
+
+func (utf8Encoding) Encode(s string) ([]byte, error) {
+	if !utf8.ValidString(s) {
+		return nil, errors.New("invalid UTF-8")
+	}
+	return []byte(s), nil
+}
+
+func (utf8Encoding) Decode(b []byte) (string, error) {
+	if !utf8.Valid(b) {
+		return "", errors.New("invalid UTF-8")
+	}
+	return string(b), nil
+}
+
+func (utf8Encoding) UnitSize() int { return 1 }
+
+// byteEncoding is a single byte encoding whose code points are the first
+// Max+1 Unicode code points, such as ASCII or Latin-1.
+type byteEncoding struct {
+	Name string
+	Max  rune
+}
+
+func (be byteEncoding) Encode(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > be.Max {
+			return nil, fmt.Errorf("character %q cannot be encoded in %s", r, be.Name)
+		}
+		b = append(b, byte(r))
+	}
+	return b, nil
+}
+
+func (be byteEncoding) Decode(b []byte) (string, error) {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		if rune(c) > be.Max {
+			return "", fmt.Errorf("invalid %s byte %#x", be.Name, c)
+		}
+		runes[i] = rune(c)
+	}
+	return string(runes), nil
+}
+
+func (byteEncoding) UnitSize() int { return 1 }
+
+// utf16Encoding is UTF-16 in the given byte order. Unpaired surrogates
+// decode to U+FFFD.
+type utf16Encoding struct {
+	Order binary.ByteOrder
+}
+
+func (ue utf16Encoding) Encode(s string) ([]byte, error) {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, len(units)*2)
+	for i, u := range units {
+		ue.Order.PutUint16(b[i*2:], u)
+	}
+	return b, nil
+}
+
+func (ue utf16Encoding) Decode(b []byte) (string, error) {
+	if len(b)%2 != 0 {
+		return "", errors.New("odd number of bytes in UTF-16 string")
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = ue.Order.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+func (utf16Encoding) UnitSize() int { return 2 }
+
+//go:generate go run gen_shiftjis.go
+
+// shiftJISEncoding is Shift-JIS as specified by the WHATWG Encoding Standard,
+// which includes the NEC and IBM extensions of Windows code page 932. The
+// user-defined area decodes to and encodes from the Private Use Area.
+type shiftJISEncoding struct{}
+
+// jis0208Pointers maps code points to pointers of the JIS X 0208 index, for
+// encoding. It is built on first use.
+var jis0208Pointers map[rune]int
+var jis0208Once sync.Once
+
+func jis0208Pointer(r rune) (int, bool) {
+	jis0208Once.Do(func() {
+		jis0208Pointers = make(map[rune]int, len(jis0208))
+		for pointer, c := range jis0208 {
+			// Pointers 8272 to 8835 repeat the IBM extensions at their NEC
+			// positions, and are not used for encoding.
+			if c == 0 || pointer >= 8272 && pointer <= 8835 {
+				continue
+			}
+			if _, ok := jis0208Pointers[rune(c)]; !ok {
+				jis0208Pointers[rune(c)] = pointer
+			}
+		}
+	})
+	pointer, ok := jis0208Pointers[r]
+	return pointer, ok
+}
+
+func (shiftJISEncoding) Encode(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r <= 0x80:
+			b = append(b, byte(r))
+			continue
+		case r == 0xa5:
+			b = append(b, 0x5c)
+			continue
+		case r == 0x203e:
+			b = append(b, 0x7e)
+			continue
+		case r >= 0xff61 && r <= 0xff9f:
+			b = append(b, byte(r-0xff61+0xa1))
+			continue
+		case r == 0x2212:
+			r = 0xff0d
+		}
+
+		pointer, ok := jis0208Pointer(r)
+		if !ok && r >= 0xe000 && r <= 0xe757 {
+			pointer, ok = 8836+int(r-0xe000), true
+		}
+		if !ok {
+			return nil, fmt.Errorf("character %q cannot be encoded in Shift-JIS", r)
+		}
+
+		lead, trail := pointer/188, pointer%188
+		if lead < 0x1f {
+			lead += 0x81
+		} else {
+			lead += 0xc1
+		}
+		if trail < 0x3f {
+			trail += 0x40
+		} else {
+			trail += 0x41
+		}
+		b = append(b, byte(lead), byte(trail))
+	}
+	return b, nil
+}
+
+func (shiftJISEncoding) Decode(b []byte) (string, error) {
+	runes := make([]rune, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c <= 0x80:
+			runes = append(runes, rune(c))
+			continue
+		case c >= 0xa1 && c <= 0xdf:
+			runes = append(runes, 0xff61+rune(c-0xa1))
+			continue
+		case c >= 0x81 && c <= 0x9f, c >= 0xe0 && c <= 0xfc:
+		default:
+			return "", fmt.Errorf("invalid Shift-JIS byte %#x", c)
+		}
+
+		if i+1 == len(b) {
+			return "", fmt.Errorf("truncated Shift-JIS character %#x", c)
+		}
+		t := b[i+1]
+		if t < 0x40 || t == 0x7f || t > 0xfc {
+			return "", fmt.Errorf("invalid Shift-JIS character %#x %#x", c, t)
+		}
+		i++
+
+		lead, trail := int(c)-0x81, int(t)-0x40
+		if c >= 0xe0 {
+			lead = int(c) - 0xc1
+		}
+		if t > 0x7f {
+			trail--
+		}
+		pointer := lead*188 + trail
+
+		switch {
+		case pointer >= 8836 && pointer <= 10715:
+			runes = append(runes, 0xe000+rune(pointer-8836))
+		case pointer < len(jis0208) && jis0208[pointer] != 0:
+			runes = append(runes, rune(jis0208[pointer]))
+		default:
+			return "", fmt.Errorf("invalid Shift-JIS character %#x %#x", c, t)
+		}
+	}
+	return string(runes), nil
+}
+
+func (shiftJISEncoding) UnitSize() int { return 1 }
+
+var encodingCache = map[string]Encoding{
+	"utf8":       utf8Encoding{},
+	"utf-8":      utf8Encoding{},
+	"ascii":      byteEncoding{Name: "ASCII", Max: 0x7f},
+	"latin1":     byteEncoding{Name: "Latin-1", Max: 0xff},
+	"iso-8859-1": byteEncoding{Name: "Latin-1", Max: 0xff},
+	"utf16le":    utf16Encoding{Order: binary.LittleEndian},
+	"utf-16le":   utf16Encoding{Order: binary.LittleEndian},
+	"utf16be":    utf16Encoding{Order: binary.BigEndian},
+	"utf-16be":   utf16Encoding{Order: binary.BigEndian},
+	"shift-jis":  shiftJISEncoding{},
+	"shift_jis":  shiftJISEncoding{},
+	"sjis":       shiftJISEncoding{},
+}
+var encodingMutex = sync.RWMutex{}
+
+/*
+RegisterEncoding registers a character encoding for use with the encoding
+tag. Names are case insensitive, and registering a name again replaces it.
+The built in encodings are utf8, ascii, latin1 (or iso-8859-1), utf16le,
+utf16be and shift-jis (or sjis).
+
+Other encodings, such as EUC-KR, can be adapted from packages like
+golang.org/x/text:
+
+	type eucKR struct{}
+
+	func (eucKR) Encode(s string) ([]byte, error) {
+		return korean.EUCKR.NewEncoder().Bytes([]byte(s))
+	}
+
+	func (eucKR) Decode(b []byte) (string, error) {
+		d, err := korean.EUCKR.NewDecoder().Bytes(b)
+		return string(d), err
+	}
+
+	func (eucKR) UnitSize() int { return 1 }
+
+	restruct.RegisterEncoding("euc-kr", eucKR{})
+
+Encodings must be registered before the first use of a struct that uses them.
+*/
+func RegisterEncoding(name string, enc Encoding) {
+	if enc.UnitSize() <= 0 {
+		panic(fmt.Errorf("encoding %s has invalid unit size %d", name, enc.UnitSize()))
+	}
+
+	encodingMutex.Lock()
+	encodingCache[strings.ToLower(name)] = enc
+	encodingMutex.Unlock()
+}
+
+func lookupEncoding(name string) (Encoding, bool) {
+	encodingMutex.RLock()
+	defer encodingMutex.RUnlock()
+
+	enc, ok := encodingCache[strings.ToLower(name)]
+	return enc, ok
+}
+
+// validEncodingType returns true if an encoding can be used with the given
+// native and binary types.
+func validEncodingType(enc Encoding, native, binary reflect.Type) bool {
+	if native.Kind() != reflect.String {
+		return false
+	}
+	switch binary.Kind() {
+	case reflect.String:
+		return true
+	case reflect.Slice:
+		return binary.Elem().Kind() == reflect.Uint8
+	case reflect.Array:
+		return binary.Elem().Kind() == reflect.Uint8 && binary.Len()%enc.UnitSize() == 0
+	}
+	return false
+}
+
+// unitSize returns the size in bytes of a code unit of a string field.
+func (f *field) unitSize() int {
+	if f.Encoding == nil {
+		return 1
+	}
+	return f.Encoding.UnitSize()
+}
+
+// decodeString converts the bytes of a string field to a Go string.
+func decodeString(f field, b []byte) string {
+	if f.Encoding == nil {
+		return string(b)
+	}
+	s, err := f.Encoding.Decode(b)
+	if err != nil {
+		panic(fmt.Errorf("%s: %v", f.Name, err))
+	}
+	return s
+}
+
+// encodeString converts a Go string to the bytes of a string field.
+func encodeString(f field, s string) []byte {
+	if f.Encoding == nil {
+		return []byte(s)
+	}
+	b, err := f.Encoding.Encode(s)
+	if err != nil {
+		panic(fmt.Errorf("%s: %v", f.Name, err))
+	}
+	return b
+}
+
+// encodedLen returns the length of a field value as stored by a size field:
+// code units for encoded strings, or elements otherwise.
+func encodedLen(f field, v reflect.Value) int {
+	if f.Encoding == nil || v.Kind() != reflect.String {
+		return v.Len()
+	}
+	return len(encodeString(f, v.String())) / f.unitSize()
+}
+
+// indexUnit returns the index of the first instance of sep in b that starts
+// on a multiple of unit bytes, or -1 if there is none.
+func indexUnit(b, sep []byte, unit int) int {
+	if unit <= 1 {
+		return bytes.Index(b, sep)
+	}
+	for i := 0; i+len(sep) <= len(b); i += unit {
+		if bytes.HasPrefix(b[i:], sep) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,104 @@
+package restruct
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// EnumError is returned when a strict field holds a value that is not part
+// of its registered enumeration.
+type EnumError struct {
+	Type  reflect.Type
+	Value interface{}
+}
+
+func (e EnumError) Error() string {
+	return fmt.Sprintf("invalid value %v for enum type %s", e.Value, e.Type)
+}
+
+var enumCache = map[reflect.Type]map[interface{}]string{}
+var enumMutex = sync.RWMutex{}
+
+/*
+RegisterEnum registers the legal values of an enumeration type along with
+their symbolic names. The typ parameter is any value of the enumeration type,
+and names must be a map from that type to string, like so:
+
+	restruct.RegisterEnum(ColorType(0), map[ColorType]string{
+		ColorGreyscale: "Greyscale",
+		ColorTrueColor: "TrueColor",
+	})
+
+Fields tagged with strict will fail to pack or unpack with an EnumError if
+they hold an unregistered value. Registering a type again replaces its values.
+*/
+func RegisterEnum(typ interface{}, names interface{}) {
+	t := reflect.TypeOf(typ)
+	nv := reflect.ValueOf(names)
+	if nv.Kind() != reflect.Map || nv.Type().Key() != t || nv.Type().Elem().Kind() != reflect.String {
+		panic(fmt.Errorf("enum names for %s must be a map[%s]string", t, t))
+	}
+
+	values := map[interface{}]string{}
+	for _, key := range nv.MapKeys() {
+		values[key.Interface()] = nv.MapIndex(key).String()
+	}
+
+	enumMutex.Lock()
+	enumCache[t] = values
+	enumMutex.Unlock()
+}
+
+func enumValues(t reflect.Type) (map[interface{}]string, bool) {
+	enumMutex.RLock()
+	defer enumMutex.RUnlock()
+
+	values, ok := enumCache[t]
+	return values, ok
+}
+
+// EnumName returns the symbolic name of an enumeration value. It returns false
+// if the type is not registered or the value is not part of the enumeration.
+func EnumName(v interface{}) (string, bool) {
+	values, ok := enumValues(reflect.TypeOf(v))
+	if !ok {
+		return "", false
+	}
+	name, ok := values[v]
+	return name, ok
+}
+
+// EnumString returns the symbolic name of an enumeration value, or a string
+// of the form Type(value) if it has none. It is suitable for implementing
+// fmt.Stringer on enumeration types.
+func EnumString(v interface{}) string {
+	if name, ok := EnumName(v); ok {
+		return name
+	}
+
+	// Format the underlying value, since v's own String method may call us.
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%s(%d)", rv.Type().Name(), rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Sprintf("%s(%d)", rv.Type().Name(), rv.Uint())
+	case reflect.String:
+		return fmt.Sprintf("%s(%q)", rv.Type().Name(), rv.String())
+	default:
+		return fmt.Sprintf("%s(?)", rv.Type().Name())
+	}
+}
+
+// checkEnum panics with an EnumError if v is of a registered enumeration type
+// but does not hold one of its values.
+func checkEnum(v reflect.Value) {
+	values, ok := enumValues(v.Type())
+	if !ok {
+		return
+	}
+	if _, ok := values[v.Interface()]; !ok {
+		panic(EnumError{Type: v.Type(), Value: v.Interface()})
+	}
+}
@@ -9,6 +9,12 @@ var (
 	stdLibResolver     = expr.NewMapResolver(exprStdLib)
 )
 
+func init() {
+	for name, pkg := range expr.StdLib() {
+		exprStdLib[name] = pkg
+	}
+}
+
 // EnableExprBeta enables you to use restruct expr while it is still in beta.
 // Use at your own risk. Functionality may change in unforeseen, incompatible
 // ways at any time.
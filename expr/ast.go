@@ -3,6 +3,7 @@ package expr
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type node interface {
@@ -245,3 +246,71 @@ type ternaryexpr struct {
 func (n ternaryexpr) source() string {
 	return n.a.source() + " ? " + n.b.source() + " : " + n.c.source()
 }
+
+// Slice expression node. Either bound may be nil.
+type sliceexpr struct {
+	a, lo, hi node
+}
+
+func (n sliceexpr) source() string {
+	lo, hi := "", ""
+	if n.lo != nil {
+		lo = n.lo.source()
+	}
+	if n.hi != nil {
+		hi = n.hi.source()
+	}
+	return n.a.source() + "[" + lo + ":" + hi + "]"
+}
+
+// Range expression node, which is only valid as an alternative of a match.
+type rangeexpr struct {
+	lo, hi node
+}
+
+func (n rangeexpr) source() string {
+	return n.lo.source() + ".." + n.hi.source()
+}
+
+// Array literal node.
+type arraylit struct {
+	pos   int
+	elems []node
+}
+
+func (n arraylit) source() string {
+	elems := make([]string, len(n.elems))
+	for i, e := range n.elems {
+		elems[i] = e.source()
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// Map literal node.
+type maplit struct {
+	pos        int
+	keys, vals []node
+}
+
+func (n maplit) source() string {
+	elems := make([]string, len(n.keys))
+	for i := range n.keys {
+		elems[i] = n.keys[i].source() + ": " + n.vals[i].source()
+	}
+	return "{" + strings.Join(elems, ", ") + "}"
+}
+
+// Struct literal node.
+type structlit struct {
+	pos   int
+	names []string
+	vals  []node
+}
+
+func (n structlit) source() string {
+	elems := make([]string, len(n.names))
+	for i := range n.names {
+		elems[i] = n.names[i] + ": " + n.vals[i].source()
+	}
+	return "struct{" + strings.Join(elems, ", ") + "}"
+}
@@ -0,0 +1,264 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// builtin is a predeclared function. Builtins accept arguments of many
+// types, so unlike other functions they are type checked and called by their
+// own functions rather than through a FuncType.
+type builtin struct {
+	name string
+
+	// nargs is the number of arguments, or -1 for one or more arguments.
+	nargs int
+
+	// check returns the result type for the given argument types, or an error
+	// message.
+	check func(args []node, types []Type) (Type, string)
+
+	call func(args []Value) Value
+}
+
+// BuiltinType is the type of builtin functions.
+type BuiltinType struct {
+	b *builtin
+}
+
+// String implements Type.
+func (t BuiltinType) String() string {
+	return "func"
+}
+
+// Kind implements Type.
+func (BuiltinType) Kind() Kind {
+	return Func
+}
+
+// builtinval is the value of a builtin function.
+type builtinval struct {
+	val
+	b *builtin
+}
+
+func (v builtinval) Call(in []Value) Value {
+	if v.b.nargs >= 0 && len(in) != v.b.nargs || len(in) == 0 {
+		panic(fmt.Errorf("wrong number of arguments in call to %s", v.b.name))
+	}
+	return v.b.call(in)
+}
+
+var builtins = map[string]*builtin{}
+
+func init() {
+	for _, b := range []*builtin{
+		{name: "len", nargs: 1, check: checklen, call: calllen},
+		{name: "cap", nargs: 1, check: checkcap, call: callcap},
+		{name: "min", nargs: -1, check: checkminmax, call: callmin},
+		{name: "max", nargs: -1, check: checkminmax, call: callmax},
+		{name: "abs", nargs: 1, check: checkabs, call: callabs},
+		{name: "align", nargs: 2, check: checkalign, call: callalign},
+		{name: "hasPrefix", nargs: 2, check: checkaffix, call: callhasprefix},
+		{name: "hasSuffix", nargs: 2, check: checkaffix, call: callhassuffix},
+	} {
+		builtins[b.name] = b
+	}
+
+	for name, kind := range map[string]Kind{
+		"bool": Bool, "byte": Uint8, "string": String,
+		"int": Int, "int8": Int8, "int16": Int16, "int32": Int32, "int64": Int64,
+		"uint": Uint, "uint8": Uint8, "uint16": Uint16, "uint32": Uint32, "uint64": Uint64,
+		"uintptr": Uintptr, "float32": Float32, "float64": Float64,
+	} {
+		builtins[name] = conversion(name, NewPrimitiveType(kind))
+	}
+}
+
+// Builtin returns the builtin function with the given name, or nil if there
+// is none. Builtins are resolved when a resolver does not resolve a name, so
+// resolvers may shadow them.
+func Builtin(ident string) Value {
+	b, ok := builtins[ident]
+	if !ok {
+		return nil
+	}
+	return builtinval{val{reflect.ValueOf(b.call), &BuiltinType{b}}, b}
+}
+
+func invalidarg(arg node, t Type, name string) string {
+	return fmt.Sprintf("invalid argument %s (type %s) for %s", arg.source(), t, name)
+}
+
+func checklen(args []node, types []Type) (Type, string) {
+	switch types[0].Kind() {
+	case String, Array, Slice, Map:
+		return NewPrimitiveType(Int), ""
+	}
+	return nil, invalidarg(args[0], types[0], "len")
+}
+
+func calllen(args []Value) Value {
+	return ValueOf(args[0].Value().Len())
+}
+
+func checkcap(args []node, types []Type) (Type, string) {
+	switch types[0].Kind() {
+	case Array, Slice:
+		return NewPrimitiveType(Int), ""
+	}
+	return nil, invalidarg(args[0], types[0], "cap")
+}
+
+func callcap(args []Value) Value {
+	return ValueOf(args[0].Value().Cap())
+}
+
+func checkminmax(args []node, types []Type) (Type, string) {
+	t := types[0]
+	for _, u := range types[1:] {
+		var ok bool
+		if t, ok = unify(t, u); !ok {
+			names := make([]string, len(types))
+			for i, t := range types {
+				names[i] = t.String()
+			}
+			return nil, fmt.Sprintf("mismatched types %s", strings.Join(names, ", "))
+		}
+	}
+	if !isOrdered(t) {
+		return nil, invalidarg(args[0], t, "min or max")
+	}
+	return defaulttype(t), ""
+}
+
+// callminmax returns the argument that less orders first, converted to the
+// common type of the arguments.
+func callminmax(args []Value, less func(a, b Value) bool) Value {
+	result := args[0]
+	t := args[0].Type()
+	for _, arg := range args[1:] {
+		if less(arg, result) {
+			result = arg
+		}
+		if assignable(t, arg.Type()) {
+			t = arg.Type()
+		}
+	}
+	return promote(result, defaulttype(t))
+}
+
+func callmin(args []Value) Value {
+	return callminmax(args, func(a, b Value) bool { return a.Lesser(b).RawValue().(bool) })
+}
+
+func callmax(args []Value) Value {
+	return callminmax(args, func(a, b Value) bool { return a.Greater(b).RawValue().(bool) })
+}
+
+func checkabs(args []node, types []Type) (Type, string) {
+	if !isNumeric(types[0]) {
+		return nil, invalidarg(args[0], types[0], "abs")
+	}
+	return defaulttype(types[0]), ""
+}
+
+func callabs(args []Value) Value {
+	x := promote(args[0], defaulttype(args[0].Type()))
+	if x.Lesser(literalintval(0)).RawValue().(bool) {
+		return x.Negate()
+	}
+	return x
+}
+
+func checkalign(args []node, types []Type) (Type, string) {
+	for i, t := range types {
+		if !isInteger(t) {
+			return nil, invalidarg(args[i], t, "align")
+		}
+	}
+	t, ok := unify(types[0], types[1])
+	if !ok {
+		return nil, fmt.Sprintf("mismatched types %s and %s", types[0], types[1])
+	}
+	return defaulttype(t), ""
+}
+
+// callalign rounds x up to a multiple of n.
+func callalign(args []Value) Value {
+	x, n := args[0], args[1]
+	return x.Add(n).Sub(literalintval(1)).Div(n).Mul(n)
+}
+
+func checkaffix(args []node, types []Type) (Type, string) {
+	for i, t := range types {
+		if !isBytesOrString(t) {
+			return nil, invalidarg(args[i], t, "hasPrefix or hasSuffix")
+		}
+	}
+	return NewPrimitiveType(Bool), ""
+}
+
+// affixargs returns the contents of the arguments of hasPrefix or
+// hasSuffix, which may be strings or byte arrays or slices.
+func affixargs(name string, args []Value) (string, string) {
+	s, ok := bytesOrString(args[0])
+	if !ok {
+		panic(InvalidOpError{Op: name, V: args[0]})
+	}
+	affix, ok := bytesOrString(args[1])
+	if !ok {
+		panic(InvalidOpError{Op: name, V: args[1]})
+	}
+	return s, affix
+}
+
+func callhasprefix(args []Value) Value {
+	s, prefix := affixargs("hasPrefix", args)
+	return ValueOf(strings.HasPrefix(s, prefix))
+}
+
+func callhassuffix(args []Value) Value {
+	s, suffix := affixargs("hasSuffix", args)
+	return ValueOf(strings.HasSuffix(s, suffix))
+}
+
+// convertible returns true if a value of type from can be converted to the
+// primitive type to.
+func convertible(from Type, to Type) bool {
+	switch {
+	case isNumeric(to):
+		return isNumeric(from)
+	case isBool(to):
+		return isBool(from)
+	case to.Kind() == String:
+		if st, ok := from.(*SliceType); ok {
+			return st.Elem().Kind() == Uint8
+		}
+		return from.Kind() == String
+	}
+	return false
+}
+
+// conversion returns a builtin that converts its argument to a primitive
+// type.
+func conversion(name string, to Type) *builtin {
+	return &builtin{
+		name:  name,
+		nargs: 1,
+		check: func(args []node, types []Type) (Type, string) {
+			if !convertible(types[0], to) {
+				return nil, fmt.Sprintf("cannot convert %s (type %s) to type %s", args[0].source(), types[0], to)
+			}
+			return to, ""
+		},
+		call: func(args []Value) Value {
+			from := args[0].Type()
+			if !convertible(from, to) {
+				panic(ConversionError{From: from, To: to})
+			}
+			return val{args[0].Value().Convert(toreflecttype(to)), to}
+		},
+	}
+}
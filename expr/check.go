@@ -0,0 +1,578 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckError is a type error found in an expression by Check. Pos is the
+// offset of the offending subexpression in the source.
+type CheckError struct {
+	Pos int
+	Msg string
+}
+
+func (e CheckError) Error() string {
+	return fmt.Sprintf("col %d: %s", e.Pos+1, e.Msg)
+}
+
+// CheckErrors is a list of type errors, in the order they were found.
+type CheckErrors []CheckError
+
+func (e CheckErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Check type checks a program against the identifiers given by resolver, and
+// returns the type of its result. All errors found are returned together as
+// CheckErrors.
+func Check(resolver TypeResolver, program *Program) (Type, error) {
+	c := checker{resolver: resolver}
+	if program.root == nil {
+		return nil, CheckErrors{{Pos: 0, Msg: "empty expression"}}
+	}
+	t := c.check(program.root)
+	if len(c.errs) > 0 {
+		return nil, c.errs
+	}
+	return t, nil
+}
+
+// Assignable returns true if a value of type from can be used where a value
+// of type to is expected.
+func Assignable(from Type, to Type) bool {
+	return assignable(from, to)
+}
+
+type checker struct {
+	resolver TypeResolver
+	errs     CheckErrors
+}
+
+// nodepos returns the source offset of the start of a node.
+func nodepos(n node) int {
+	switch n := n.(type) {
+	case identnode:
+		return n.pos
+	case intnode:
+		return n.pos
+	case floatnode:
+		return n.pos
+	case boolnode:
+		return n.pos
+	case strnode:
+		return n.pos
+	case runenode:
+		return n.pos
+	case nilnode:
+		return n.pos
+	case unaryexpr:
+		return nodepos(n.n)
+	case binaryexpr:
+		return nodepos(n.a)
+	case ternaryexpr:
+		return nodepos(n.a)
+	case sliceexpr:
+		return nodepos(n.a)
+	case rangeexpr:
+		return nodepos(n.lo)
+	case arraylit:
+		return n.pos
+	case maplit:
+		return n.pos
+	case structlit:
+		return n.pos
+	}
+	return 0
+}
+
+func (c *checker) errorf(n node, format string, args ...interface{}) {
+	c.errs = append(c.errs, CheckError{Pos: nodepos(n), Msg: fmt.Sprintf(format, args...)})
+}
+
+func isInteger(t Type) bool {
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, UntypedInt:
+		return true
+	}
+	return false
+}
+
+func isUnsigned(t Type) bool {
+	switch t.Kind() {
+	case Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, UntypedInt:
+		return true
+	}
+	return false
+}
+
+func isNumeric(t Type) bool {
+	switch t.Kind() {
+	case Float32, Float64, UntypedFloat:
+		return true
+	}
+	return isInteger(t)
+}
+
+func isBool(t Type) bool {
+	return t.Kind() == Bool || t.Kind() == UntypedBool
+}
+
+func isBytesOrString(t Type) bool {
+	return t.Kind() == String || isBytes(t)
+}
+
+func isOrdered(t Type) bool {
+	return isNumeric(t) || t.Kind() == String
+}
+
+// defaulttype returns the type an untyped constant takes on when it is used
+// in an operation with another untyped constant.
+func defaulttype(t Type) Type {
+	switch t.Kind() {
+	case UntypedBool:
+		return NewPrimitiveType(Bool)
+	case UntypedInt:
+		return NewPrimitiveType(Int)
+	case UntypedFloat:
+		return NewPrimitiveType(Float64)
+	}
+	return t
+}
+
+// unify returns the common type of two operands, following the same rules
+// as coerce.
+func unify(a, b Type) (Type, bool) {
+	switch {
+	case TypeEqual(a, b):
+		return a, true
+	case assignable(a, b):
+		return b, true
+	case assignable(b, a):
+		return a, true
+	}
+	return nil, false
+}
+
+// check returns the type of a node, or nil if it has errors. Errors are not
+// reported for operations on nodes that already have errors.
+func (c *checker) check(n node) Type {
+	switch n := n.(type) {
+	case identnode:
+		return c.checkident(n)
+	case intnode:
+		return NewLiteralType(UntypedInt)
+	case floatnode:
+		return NewLiteralType(UntypedFloat)
+	case boolnode:
+		return NewLiteralType(UntypedBool)
+	case strnode:
+		return NewPrimitiveType(String)
+	case runenode:
+		return NewLiteralType(UntypedInt)
+	case nilnode:
+		return NewLiteralType(UntypedNil)
+	case unaryexpr:
+		return c.checkunary(n)
+	case binaryexpr:
+		return c.checkbinary(n)
+	case ternaryexpr:
+		return c.checkternary(n)
+	case sliceexpr:
+		return c.checkslice(n)
+	case arraylit:
+		if et := c.checkelems("array literal", n.elems); et != nil {
+			return NewArrayType(len(n.elems), et)
+		}
+		return nil
+	case maplit:
+		return c.checkmaplit(n)
+	case structlit:
+		return c.checkstructlit(n)
+	case rangeexpr:
+		lo, hi := c.check(n.lo), c.check(n.hi)
+		if lo != nil && hi != nil {
+			c.errorf(n, "range %s used outside of a match", n.source())
+		}
+		return nil
+	}
+	panic("invalid node")
+}
+
+func (c *checker) checkident(n identnode) (t Type) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			c.errorf(n, "%s: %v", n.ident, err)
+			t = nil
+		}
+	}()
+
+	if t = c.resolver.TypeResolve(n.ident); t != nil {
+		return t
+	}
+	if b := Builtin(n.ident); b != nil {
+		return b.Type()
+	}
+	c.errorf(n, "undefined: %s", n.ident)
+	return nil
+}
+
+func (c *checker) checkunary(n unaryexpr) Type {
+	t := c.check(n.n)
+	if t == nil {
+		return nil
+	}
+
+	invalid := func(op string) Type {
+		c.errorf(n, "invalid operation: operator %s not defined on %s (type %s)", op, n.n.source(), t)
+		return nil
+	}
+
+	switch n.op {
+	case unaryplus:
+		if !isNumeric(t) {
+			return invalid("+")
+		}
+		return t
+	case unarynegate:
+		if !isNumeric(t) {
+			return invalid("-")
+		}
+		return t
+	case unarynot:
+		if !isBool(t) {
+			return invalid("!")
+		}
+		return t
+	case unarybitnot:
+		if !isInteger(t) {
+			return invalid("^")
+		}
+		return t
+	case unaryderef:
+		if pt, ok := t.(*PtrType); ok {
+			return pt.Elem()
+		}
+		c.errorf(n, "invalid indirect of %s (type %s)", n.n.source(), t)
+		return nil
+	case unaryref:
+		return NewPtrType(t)
+	}
+	panic("invalid unary expression")
+}
+
+func (c *checker) checkbinary(n binaryexpr) Type {
+	switch n.op {
+	case binarymember:
+		return c.checkmember(n)
+	case binarycall:
+		return c.checkcall(n)
+	case binarygroup:
+		c.check(n.a)
+		return c.check(n.b)
+	}
+
+	a, b := c.check(n.a), c.check(n.b)
+	if a == nil || b == nil {
+		return nil
+	}
+
+	switch n.op {
+	case binarysubscript:
+		return c.checkindex(n, a, b)
+	case binarylsh, binaryrsh:
+		if !isInteger(a) {
+			c.errorf(n, "invalid operation: shift of %s (type %s)", n.a.source(), a)
+			return nil
+		}
+		if !isUnsigned(b) {
+			c.errorf(n.b, "invalid operation: shift count %s (type %s) must be unsigned", n.b.source(), b)
+			return nil
+		}
+		return defaulttype(a)
+	}
+
+	if n.op == binaryequal || n.op == binarynotequal {
+		if isBytesOrString(a) && isBytesOrString(b) && (isBytes(a) || isBytes(b)) {
+			return NewPrimitiveType(Bool)
+		}
+	}
+
+	t, ok := unify(a, b)
+	if !ok {
+		c.errorf(n, "invalid operation: %s (mismatched types %s and %s)", n.source(), a, b)
+		return nil
+	}
+
+	var valid bool
+	switch n.op {
+	case binarylogicalor, binarylogicaland:
+		valid = isBool(t)
+	case binaryequal, binarynotequal:
+		return NewPrimitiveType(Bool)
+	case binarylesser, binarylesserequal, binarygreater, binarygreaterequal:
+		if !isOrdered(t) {
+			c.errorf(n, "invalid operation: %s (operator not defined on %s)", n.source(), t)
+			return nil
+		}
+		return NewPrimitiveType(Bool)
+	case binaryadd:
+		valid = isNumeric(t) || t.Kind() == String
+	case binarysub, binarymul, binarydiv:
+		valid = isNumeric(t)
+	case binaryrem, binaryor, binaryxor, binaryand, binaryandnot:
+		valid = isInteger(t)
+	default:
+		panic("invalid binary expression")
+	}
+	if !valid {
+		c.errorf(n, "invalid operation: %s (operator not defined on %s)", n.source(), t)
+		return nil
+	}
+	return defaulttype(t)
+}
+
+func (c *checker) checkmember(n binaryexpr) Type {
+	a := c.check(n.a)
+	id, ok := n.b.(identnode)
+	if !ok {
+		c.errorf(n.b, "expected identifier after ., got %s", n.b.source())
+		return nil
+	}
+	if a == nil {
+		return nil
+	}
+
+	if pt, ok := a.(*PtrType); ok {
+		a = pt.Elem()
+	}
+	switch t := a.(type) {
+	case *PackageType:
+		if s := t.Symbol(id.ident); s != nil {
+			return s
+		}
+		c.errorf(id, "undefined: %s", n.source())
+		return nil
+	case *StructType:
+		if f, ok := t.FieldByName(id.ident); ok {
+			return f.Type
+		}
+	}
+	c.errorf(id, "%s undefined (type %s has no field %s)", n.source(), a, id.ident)
+	return nil
+}
+
+func (c *checker) checkcall(n binaryexpr) Type {
+	f := c.check(n.a)
+
+	var args []node
+	if n.b != nil {
+		args = flattengroup(n.b)
+	}
+	types := make([]Type, len(args))
+	for i, arg := range args {
+		types[i] = c.check(arg)
+	}
+	if f == nil {
+		return nil
+	}
+
+	if bt, ok := f.(*BuiltinType); ok {
+		return c.checkbuiltin(n, bt.b, args, types)
+	}
+
+	ft, ok := f.(*FuncType)
+	if !ok {
+		c.errorf(n, "cannot call non-function %s (type %s)", n.a.source(), f)
+		return nil
+	}
+	if len(args) != ft.NumIn() {
+		c.errorf(n, "wrong number of arguments in call to %s: have %d, want %d", n.a.source(), len(args), ft.NumIn())
+		return nil
+	}
+	for i, t := range types {
+		if t != nil && !assignable(t, ft.In(i)) && !TypeEqual(t, ft.In(i)) {
+			c.errorf(args[i], "cannot use %s (type %s) as type %s in argument to %s", args[i].source(), t, ft.In(i), n.a.source())
+		}
+	}
+	if ft.NumOut() != 1 {
+		c.errorf(n, "%s must return exactly one value", n.a.source())
+		return nil
+	}
+	return ft.Out(0)
+}
+
+func (c *checker) checkbuiltin(n binaryexpr, b *builtin, args []node, types []Type) Type {
+	if b.nargs >= 0 && len(args) != b.nargs {
+		c.errorf(n, "wrong number of arguments in call to %s: have %d, want %d", b.name, len(args), b.nargs)
+		return nil
+	}
+	if len(args) == 0 {
+		c.errorf(n, "not enough arguments in call to %s", b.name)
+		return nil
+	}
+	for _, t := range types {
+		if t == nil {
+			return nil
+		}
+	}
+	t, msg := b.check(args, types)
+	if msg != "" {
+		c.errorf(n, "%s", msg)
+		return nil
+	}
+	return t
+}
+
+func (c *checker) checkindex(n binaryexpr, a, b Type) Type {
+	switch t := a.(type) {
+	case *MapType:
+		if !assignable(b, t.Key()) && !TypeEqual(b, t.Key()) && !(isInteger(b) && isInteger(t.Key())) {
+			c.errorf(n.b, "cannot use %s (type %s) as type %s in map index", n.b.source(), b, t.Key())
+			return nil
+		}
+		return t.Value()
+	}
+
+	var elem Type
+	switch t := a.(type) {
+	case *ArrayType:
+		elem = t.Elem()
+	case *SliceType:
+		elem = t.Elem()
+	default:
+		if a.Kind() != String {
+			c.errorf(n, "invalid operation: %s (type %s does not support indexing)", n.source(), a)
+			return nil
+		}
+		elem = NewPrimitiveType(Uint8)
+	}
+	if !isInteger(b) {
+		c.errorf(n.b, "invalid index %s (type %s must be integer)", n.b.source(), b)
+		return nil
+	}
+	return elem
+}
+
+func (c *checker) checkslice(n sliceexpr) Type {
+	a := c.check(n.a)
+	valid := true
+	for _, bound := range []node{n.lo, n.hi} {
+		if bound == nil {
+			continue
+		}
+		if t := c.check(bound); t == nil {
+			valid = false
+		} else if !isInteger(t) {
+			c.errorf(bound, "invalid slice index %s (type %s must be integer)", bound.source(), t)
+			valid = false
+		}
+	}
+	if a == nil || !valid {
+		return nil
+	}
+
+	switch t := a.(type) {
+	case *ArrayType:
+		return NewSliceType(t.Elem())
+	case *SliceType:
+		return t
+	}
+	if a.Kind() != String {
+		c.errorf(n, "cannot slice %s (type %s)", n.a.source(), a)
+		return nil
+	}
+	return a
+}
+
+func (c *checker) checkternary(n ternaryexpr) Type {
+	cond, a, b := c.check(n.a), c.check(n.b), c.check(n.c)
+	if cond != nil && !isBool(cond) {
+		c.errorf(n.a, "non-bool %s (type %s) used as condition", n.a.source(), cond)
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	t, ok := unify(a, b)
+	if !ok {
+		c.errorf(n, "mismatched types %s and %s in conditional", a, b)
+		return nil
+	}
+	return t
+}
+
+// checkelems returns the common type of the elements of a literal, with
+// untyped constants taking on their default types.
+func (c *checker) checkelems(what string, elems []node) Type {
+	types := make([]Type, len(elems))
+	for i, e := range elems {
+		types[i] = c.check(e)
+	}
+	for _, t := range types {
+		if t == nil {
+			return nil
+		}
+	}
+	t := types[0]
+	for i, u := range types[1:] {
+		var ok bool
+		if t, ok = unify(t, u); !ok {
+			c.errorf(elems[i+1], "mismatched types %s and %s in %s", types[0], u, what)
+			return nil
+		}
+	}
+	if t.Kind() == UntypedNil {
+		c.errorf(elems[0], "use of untyped nil in %s", what)
+		return nil
+	}
+	return defaulttype(t)
+}
+
+func (c *checker) checkmaplit(n maplit) Type {
+	kt, vt := c.checkelems("map keys", n.keys), c.checkelems("map values", n.vals)
+	if kt == nil || vt == nil {
+		return nil
+	}
+	if !isComparable(kt) {
+		c.errorf(n, "invalid map key type %s", kt)
+		return nil
+	}
+	return NewMapType(kt, vt)
+}
+
+func (c *checker) checkstructlit(n structlit) Type {
+	fields := make([]Field, len(n.names))
+	seen := map[string]bool{}
+	ok := true
+	for i, name := range n.names {
+		t := c.check(n.vals[i])
+		switch {
+		case t == nil:
+			ok = false
+			continue
+		case !isExported(name):
+			c.errorf(n.vals[i], "struct literal field %s is not exported", name)
+			ok = false
+		case seen[name]:
+			c.errorf(n.vals[i], "duplicate field %s in struct literal", name)
+			ok = false
+		case t.Kind() == UntypedNil:
+			c.errorf(n.vals[i], "use of untyped nil in struct literal field %s", name)
+			ok = false
+		}
+		seen[name] = true
+		fields[i] = Field{Name: name, Type: defaulttype(t)}
+	}
+	if !ok {
+		return nil
+	}
+	return NewStructType(fields)
+}
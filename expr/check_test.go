@@ -0,0 +1,115 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestCheckStruct struct {
+	Len   uint8
+	Count int32
+	Ratio float64
+	Name  string
+	Flag  bool
+	Data  []byte
+	Inner *TestStruct1
+	Table map[string]int
+	Func  func(int) int
+}
+
+func TestCheck(t *testing.T) {
+	resolver := NewStructTypeResolver(TestCheckStruct{})
+
+	tests := []struct {
+		expr   string
+		result Kind
+		err    string
+	}{
+		{"Len * 2", Uint8, ""},
+		{"1 + 2", Int, ""},
+		{"Count > 0 && Flag", Bool, ""},
+		{"Ratio * 2.5", Float64, ""},
+		{"Name + \"x\"", String, ""},
+		{"Data[Len]", Uint8, ""},
+		{"Name[0]", Uint8, ""},
+		{"Inner.A", Int, ""},
+		{"Table[Name]", Int, ""},
+		{"Func(3)", Int, ""},
+		{"Len << 2", Uint8, ""},
+		{"Flag ? Len : 1", Uint8, ""},
+		{"-Count", Int32, ""},
+		{"len(Data)", Int, ""},
+		{"min(Len, 3)", Uint8, ""},
+		{"abs(Ratio)", Float64, ""},
+		{"align(Count, 4)", Int32, ""},
+		{"uint32(Len)", Uint32, ""},
+		{"string(Data)", String, ""},
+		{"Data[1:Len]", Slice, ""},
+		{"Name[:2]", String, ""},
+		{"Data == \"abc\"", Bool, ""},
+		{"hasPrefix(Data, \"ab\")", Bool, ""},
+		{"{1: 4, 2: 8}[Len]", Int, ""},
+		{"[1, 2, 3][Len]", Int, ""},
+		{"[Len, 2]", Array, ""},
+		{"struct{A: Len, B: 2.5}.B", Float64, ""},
+		{"Missing", Invalid, "col 1: undefined: Missing"},
+		{"[1, \"a\"]", Invalid, "col 5: mismatched types untyped int constant and string in array literal"},
+		{"{Data: 1}", Invalid, "col 1: invalid map key type []uint8"},
+		{"struct{a: 1}", Invalid, "col 11: struct literal field a is not exported"},
+		{"struct{A: 1, A: 2}", Invalid, "col 17: duplicate field A in struct literal"},
+		{"Len[1:]", Invalid, "col 1: cannot slice Len (type uint8)"},
+		{"Data[Name:]", Invalid, "col 6: invalid slice index Name (type string must be integer)"},
+		{"Len..2", Invalid, "col 1: range Len..2 used outside of a match"},
+		{"Data == 1", Invalid, "col 1: invalid operation: Data == 1 (mismatched types []uint8 and untyped int constant)"},
+		{"hasPrefix(Len, \"a\")", Invalid, "col 1: invalid argument Len (type uint8) for hasPrefix or hasSuffix"},
+		{"len(Len)", Invalid, "col 1: invalid argument Len (type uint8) for len"},
+		{"min(Len, Count)", Invalid, "col 1: mismatched types uint8, int32"},
+		{"string(Len)", Invalid, "col 1: cannot convert Len (type uint8) to type string"},
+		{"len(Data, Data)", Invalid, "col 1: wrong number of arguments in call to len: have 2, want 1"},
+		{"max()", Invalid, "col 1: not enough arguments in call to max"},
+		{"Len + Count", Invalid, "col 1: invalid operation: Len + Count (mismatched types uint8 and int32)"},
+		{"Name - \"x\"", Invalid, "col 1: invalid operation: Name - \"x\" (operator not defined on string)"},
+		{"Len << Count", Invalid, "col 8: invalid operation: shift count Count (type int32) must be unsigned"},
+		{"Inner.C", Invalid, "col 7: Inner.C undefined (type struct has no field C)"},
+		{"Flag[0]", Invalid, "col 1: invalid operation: Flag[0] (type bool does not support indexing)"},
+		{"Func(Name)", Invalid, "col 6: cannot use Name (type string) as type int in argument to Func"},
+		{"Func(1, 2)", Invalid, "col 1: wrong number of arguments in call to Func: have 2, want 1"},
+		{"Len(1)", Invalid, "col 1: cannot call non-function Len (type uint8)"},
+		{"Count ? 1 : 2", Invalid, "col 1: non-bool Count (type int32) used as condition"},
+		{"!Len", Invalid, "col 2: invalid operation: operator ! not defined on Len (type uint8)"},
+		{"*Len", Invalid, "col 2: invalid indirect of Len (type uint8)"},
+		{"A + B", Invalid, "col 1: undefined: A; col 5: undefined: B"},
+		{"Missing + Len + Count", Invalid, "col 1: undefined: Missing"},
+	}
+
+	for _, test := range tests {
+		program, err := ParseString(test.expr)
+		if !assert.Nil(t, err, test.expr) {
+			continue
+		}
+		typ, err := Check(resolver, program)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.expr)
+			assert.Nil(t, typ)
+			continue
+		}
+		if assert.Nil(t, err, test.expr) {
+			assert.Equal(t, test.result, typ.Kind(), test.expr)
+		}
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	program, err := ParseString("Foo(Bar, Len + Name)")
+	assert.Nil(t, err)
+	_, err = Check(NewStructTypeResolver(TestCheckStruct{}), program)
+	errs, ok := err.(CheckErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 3)
+	assert.Equal(t, 0, errs[0].Pos)
+	assert.Equal(t, 4, errs[1].Pos)
+	assert.Equal(t, 9, errs[2].Pos)
+	assert.True(t, strings.Contains(errs[2].Msg, "mismatched types"))
+}
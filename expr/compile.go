@@ -0,0 +1,422 @@
+package expr
+
+import (
+	"reflect"
+	"sync"
+)
+
+// opcode is an instruction of the expression virtual machine.
+type opcode uint8
+
+// This is a definition of all of the instructions of the machine.
+const (
+	// opconst pushes consts[arg].
+	opconst opcode = iota
+
+	// opload pushes the value of the identifier names[arg].
+	opload
+
+	// opunary applies unaryop(arg) to the top of the stack.
+	opunary
+
+	// opbinary applies binaryop(arg) to the top two values on the stack.
+	opbinary
+
+	// opmember replaces the top of the stack with its member names[arg].
+	opmember
+
+	// opcall calls the function below the top arg values on the stack with
+	// those values as arguments.
+	opcall
+
+	// oppop discards the top of the stack.
+	oppop
+
+	// opjump jumps to instruction arg.
+	opjump
+
+	// opjumpfalse pops a condition, and jumps to instruction arg if it is
+	// false.
+	opjumpfalse
+
+	// opslice slices a value by the bounds above it on the stack. Bit 0 of
+	// arg is set if there is a low bound, and bit 1 if there is a high bound.
+	opslice
+
+	// opliteral replaces the top literals[arg].n values on the stack with the
+	// composite value built from them by literals[arg].
+	opliteral
+
+	// oprange fails, as ranges are only evaluated by matches. Its bounds are
+	// compiled so that they are included in the dependencies of the code.
+	oprange
+)
+
+const (
+	slicelo = 1 << iota
+	slicehi
+)
+
+type instr struct {
+	op  opcode
+	arg int
+}
+
+// code is a program compiled for the machine.
+type code struct {
+	instrs   []instr
+	consts   []slot
+	names    []string
+	literals []literal
+	depth    int
+
+	// fields caches the field binding of each name for each struct type
+	// and type of FieldResolver. Names that are not fields of the struct
+	// have a nil index.
+	fieldmu sync.RWMutex
+	fields  map[bindkey][]binding
+
+	// globalvals caches the value of each name for each resolver of
+	// globals. Names that do not resolve have a nil value.
+	globalvals map[Resolver][]Value
+}
+
+// compiler compiles a syntax tree into code.
+type compiler struct {
+	c     *code
+	names map[string]int
+	depth int
+}
+
+func compile(root node) *code {
+	p := compiler{c: &code{}, names: map[string]int{}}
+	if root != nil {
+		p.compile(root)
+	}
+	return p.c
+}
+
+func (p *compiler) emit(op opcode, arg int) int {
+	p.c.instrs = append(p.c.instrs, instr{op: op, arg: arg})
+	return len(p.c.instrs) - 1
+}
+
+// push records that an instruction grows the stack by n values.
+func (p *compiler) push(n int) {
+	p.depth += n
+	if p.depth > p.c.depth {
+		p.c.depth = p.depth
+	}
+}
+
+func (p *compiler) name(ident string) int {
+	if i, ok := p.names[ident]; ok {
+		return i
+	}
+	p.c.names = append(p.c.names, ident)
+	p.names[ident] = len(p.c.names) - 1
+	return len(p.c.names) - 1
+}
+
+func (p *compiler) constant(s slot) bool {
+	p.c.consts = append(p.c.consts, s)
+	p.emit(opconst, len(p.c.consts)-1)
+	p.push(1)
+	return true
+}
+
+// fold replaces the last n instructions, which must push constants, with the
+// constant computed by f from their values. If f panics, the instructions are
+// kept so that the error occurs when the program is run.
+func (p *compiler) fold(n int, f func(args []slot) slot) (ok bool) {
+	instrs := p.c.instrs[len(p.c.instrs)-n:]
+	args := make([]slot, n)
+	for i, in := range instrs {
+		args[i] = p.c.consts[in.arg]
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	result := f(args)
+
+	for i := 0; i < n; i++ {
+		p.drop()
+	}
+	return p.constant(result)
+}
+
+// drop removes the last instruction, which must push a constant, along with
+// its constant. Constants are always removed from the end, since trailing
+// constant instructions refer to the last constants.
+func (p *compiler) drop() {
+	in := p.c.instrs[len(p.c.instrs)-1]
+	p.c.instrs = p.c.instrs[:len(p.c.instrs)-1]
+	p.c.consts = p.c.consts[:in.arg]
+	p.depth--
+}
+
+// compile compiles a node, and returns true if it was folded into a single
+// constant.
+func (p *compiler) compile(n node) bool {
+	switch n := n.(type) {
+	case identnode:
+		p.emit(opload, p.name(n.ident))
+		p.push(1)
+		return false
+	case intnode:
+		if n.sign {
+			return p.constant(slot{kind: UntypedInt, bits: uint64(n.ival), signed: true})
+		}
+		return p.constant(slot{kind: UntypedInt, bits: n.uval})
+	case floatnode:
+		return p.constant(slot{v: literalfloatval(n.fval)})
+	case boolnode:
+		return p.constant(boolslot(UntypedBool, n.val))
+	case strnode:
+		return p.constant(slot{v: literalstrval(n.val)})
+	case runenode:
+		return p.constant(slot{kind: UntypedInt, bits: uint64(n.val), signed: true})
+	case nilnode:
+		return p.constant(slot{v: literalnilval()})
+	case unaryexpr:
+		if p.compile(n.n) && n.op != unaryderef && n.op != unaryref {
+			if p.fold(1, func(args []slot) slot { return unaryslot(n.op, args[0]) }) {
+				return true
+			}
+		}
+		p.emit(opunary, int(n.op))
+		return false
+	case binaryexpr:
+		return p.compilebinary(n)
+	case sliceexpr:
+		p.compile(n.a)
+		arg, bounds := 0, 0
+		if n.lo != nil {
+			p.compile(n.lo)
+			arg |= slicelo
+			bounds++
+		}
+		if n.hi != nil {
+			p.compile(n.hi)
+			arg |= slicehi
+			bounds++
+		}
+		p.emit(opslice, arg)
+		p.depth -= bounds
+		return false
+	case arraylit:
+		return p.compileliteral(n.elems, makearray)
+	case maplit:
+		elems := make([]node, 0, len(n.keys)*2)
+		for i := range n.keys {
+			elems = append(elems, n.keys[i], n.vals[i])
+		}
+		return p.compileliteral(elems, makemap)
+	case structlit:
+		return p.compileliteral(n.vals, structmaker(n.names))
+	case rangeexpr:
+		p.compile(n.lo)
+		p.compile(n.hi)
+		p.emit(oprange, 0)
+		p.depth--
+		return false
+	case ternaryexpr:
+		if p.compile(n.a) {
+			cond := p.c.consts[p.c.instrs[len(p.c.instrs)-1].arg]
+			if b, ok := foldcondition(cond); ok {
+				p.drop()
+				if b {
+					return p.compile(n.b)
+				}
+				return p.compile(n.c)
+			}
+		}
+		jumpfalse := p.emit(opjumpfalse, 0)
+		p.depth--
+		p.compile(n.b)
+		jump := p.emit(opjump, 0)
+		p.depth--
+		p.c.instrs[jumpfalse].arg = len(p.c.instrs)
+		p.compile(n.c)
+		p.c.instrs[jump].arg = len(p.c.instrs)
+		return false
+	default:
+		panic("invalid node")
+	}
+}
+
+// compileliteral compiles a composite literal built from elems by make. It
+// is folded into a constant if all of its elements are constant.
+func (p *compiler) compileliteral(elems []node, make func(args []Value) Value) bool {
+	constant := true
+	for _, e := range elems {
+		if !p.compile(e) {
+			constant = false
+		}
+	}
+	if constant {
+		if p.fold(len(elems), func(args []slot) slot { return unbox(make(boxslots(args))) }) {
+			return true
+		}
+	}
+	p.c.literals = append(p.c.literals, literal{n: len(elems), make: make})
+	p.emit(opliteral, len(p.c.literals)-1)
+	p.depth -= len(elems)
+	p.push(1)
+	return false
+}
+
+// boxslots boxes the values of slots.
+func boxslots(slots []slot) []Value {
+	vals := make([]Value, len(slots))
+	for i, s := range slots {
+		vals[i] = s.box()
+	}
+	return vals
+}
+
+// foldcondition returns the value of a constant condition, if it is valid.
+func foldcondition(s slot) (b bool, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	return condition(s), true
+}
+
+func (p *compiler) compilebinary(n binaryexpr) bool {
+	switch n.op {
+	case binarymember:
+		id, ok := n.b.(identnode)
+		if !ok {
+			panic("expected ident node")
+		}
+		p.compile(n.a)
+		p.emit(opmember, p.name(id.ident))
+		return false
+	case binarycall:
+		p.compile(n.a)
+		var args []node
+		if n.b != nil {
+			args = flattengroup(n.b)
+		}
+		for _, arg := range args {
+			p.compile(arg)
+		}
+		p.emit(opcall, len(args))
+		p.depth -= len(args)
+		return false
+	case binarygroup:
+		if p.compile(n.a) {
+			p.drop()
+		} else {
+			p.emit(oppop, 0)
+			p.depth--
+		}
+		return p.compile(n.b)
+	default:
+		a := p.compile(n.a)
+		b := p.compile(n.b)
+		if a && b {
+			if p.fold(2, func(args []slot) slot { return binaryslot(n.op, args[0], args[1]) }) {
+				return true
+			}
+		}
+		p.emit(opbinary, int(n.op))
+		p.depth--
+		return false
+	}
+}
+
+// constant returns the value of the code, if it is constant.
+func (c *code) constant() (interface{}, bool) {
+	if len(c.instrs) != 1 || c.instrs[0].op != opconst {
+		return nil, false
+	}
+	return c.consts[c.instrs[0].arg].box().RawValue(), true
+}
+
+// deps returns the identifiers loaded by the code, in order of first use.
+func (c *code) deps() []string {
+	seen := make(map[int]bool)
+	deps := []string{}
+	for _, in := range c.instrs {
+		if in.op == opload && !seen[in.arg] {
+			seen[in.arg] = true
+			deps = append(deps, c.names[in.arg])
+		}
+	}
+	return deps
+}
+
+// bindkey identifies the struct type and the type of FieldResolver that a
+// program is bound to.
+type bindkey struct {
+	typ      reflect.Type
+	resolver reflect.Type
+}
+
+// binding is the field a name is bound to in a struct type, and the
+// converter for its values, if any.
+type binding struct {
+	index []int
+	conv  func(reflect.Value) Value
+}
+
+// bind returns the field binding of each name in a struct type, as resolved
+// by a FieldResolver. Unexported fields are not bound.
+func (c *code) bind(fr FieldResolver, typ reflect.Type) []binding {
+	key := bindkey{typ, reflect.TypeOf(fr)}
+	c.fieldmu.RLock()
+	fields, ok := c.fields[key]
+	c.fieldmu.RUnlock()
+	if ok {
+		return fields
+	}
+
+	fields = make([]binding, len(c.names))
+	for i, name := range c.names {
+		if sf, ok := typ.FieldByName(name); ok && sf.PkgPath == "" {
+			fields[i] = binding{index: sf.Index, conv: fr.FieldConverter(typ, sf.Index)}
+		}
+	}
+
+	c.fieldmu.Lock()
+	if c.fields == nil {
+		c.fields = map[bindkey][]binding{}
+	}
+	c.fields[key] = fields
+	c.fieldmu.Unlock()
+	return fields
+}
+
+// maxGlobalResolvers bounds the number of resolvers of globals that a program
+// caches values for, so that short-lived resolvers do not accumulate.
+const maxGlobalResolvers = 16
+
+// globals returns the value of each name resolved by a resolver of globals.
+func (c *code) globals(g Resolver) []Value {
+	c.fieldmu.RLock()
+	values, ok := c.globalvals[g]
+	c.fieldmu.RUnlock()
+	if ok {
+		return values
+	}
+
+	values = make([]Value, len(c.names))
+	for i, name := range c.names {
+		values[i] = g.Resolve(name)
+	}
+
+	c.fieldmu.Lock()
+	if c.globalvals == nil || len(c.globalvals) >= maxGlobalResolvers {
+		c.globalvals = map[Resolver][]Value{}
+	}
+	c.globalvals[g] = values
+	c.fieldmu.Unlock()
+	return values
+}
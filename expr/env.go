@@ -9,6 +9,7 @@ var (
 	_ = Resolver(&MetaResolver{})
 	_ = TypeResolver(&StructTypeResolver{})
 	_ = Resolver(&StructResolver{})
+	_ = FieldResolver(&StructResolver{})
 	_ = TypeResolver(&MapTypeResolver{})
 	_ = Resolver(&MapResolver{})
 )
@@ -128,6 +129,26 @@ func (r *StructResolver) Resolve(ident string) Value {
 	return nil
 }
 
+// ResolveBuiltin implements FieldResolver.
+func (r *StructResolver) ResolveBuiltin(ident string) Value {
+	return nil
+}
+
+// Globals implements FieldResolver.
+func (r *StructResolver) Globals() Resolver {
+	return nil
+}
+
+// Struct implements FieldResolver.
+func (r *StructResolver) Struct() reflect.Value {
+	return r.struc
+}
+
+// FieldConverter implements FieldResolver. Fields are converted with ValueOf.
+func (r *StructResolver) FieldConverter(typ reflect.Type, index []int) func(reflect.Value) Value {
+	return nil
+}
+
 // MapTypeResolver resolves map keys.
 type MapTypeResolver struct {
 	m map[string]Type
@@ -1,9 +1,5 @@
 package expr
 
-import (
-	"fmt"
-)
-
 // EvalProgram returns the result of executing the program with the given resolver.
 func EvalProgram(resolver Resolver, program *Program) (v interface{}, err error) {
 	defer func() {
@@ -16,52 +12,22 @@ func EvalProgram(resolver Resolver, program *Program) (v interface{}, err error)
 		}
 	}()
 
-	v = evalnode(resolver, program.root).RawValue()
+	v = program.code.run(resolver).RawValue()
 	return
 }
 
 // Eval returns the result of evaluating the provided expression.
 func Eval(resolver Resolver, expr string) (interface{}, error) {
-	return EvalProgram(resolver, ParseString(expr))
-}
-
-func evalnode(resolver Resolver, node node) Value {
-	switch n := node.(type) {
-	case identnode:
-		v := resolver.Resolve(n.ident)
-		if v == nil {
-			panic(fmt.Errorf("unresolved name %s", n.ident))
-		}
-		return v
-	case intnode:
-		if n.sign {
-			return literalintval(n.ival)
-		}
-		return literaluintval(n.uval)
-	case floatnode:
-		return literalfloatval(n.fval)
-	case boolnode:
-		return literalboolval(n.val)
-	case strnode:
-		return literalstrval(n.val)
-	case runenode:
-		return literalintval(int64(n.val))
-	case nilnode:
-		return literalnilval()
-	case unaryexpr:
-		return evalunary(resolver, n)
-	case binaryexpr:
-		return evalbinary(resolver, n)
-	case ternaryexpr:
-		return evalternary(resolver, n)
-	default:
-		panic("invalid node")
+	program, err := ParseString(expr)
+	if err != nil {
+		return nil, err
 	}
+	return EvalProgram(resolver, program)
 }
 
-func evalunary(resolver Resolver, node unaryexpr) Value {
-	n := evalnode(resolver, node.n)
-	switch node.op {
+// applyunary applies a unary operator to a value.
+func applyunary(op unaryop, n Value) Value {
+	switch op {
 	case unaryplus:
 		return n
 	case unarynegate:
@@ -88,26 +54,10 @@ func flattengroup(n node) []node {
 	return []node{n}
 }
 
-func evalbinary(resolver Resolver, node binaryexpr) Value {
-	a := evalnode(resolver, node.a)
-	switch node.op {
-	case binarymember:
-		if id, ok := node.b.(identnode); ok {
-			return a.Dot(id.ident)
-		}
-		panic(fmt.Errorf("expected ident node, got %T", node.b))
-	case binarycall:
-		in := []Value{}
-		for _, n := range flattengroup(node.b) {
-			in = append(in, evalnode(resolver, n))
-		}
-		return a.Call(in)
-	case binarygroup:
-		return evalnode(resolver, node.b)
-	}
-
-	b := evalnode(resolver, node.b)
-	switch node.op {
+// applybinary applies a binary operator to two values. Members, calls and
+// groups are handled by the machine.
+func applybinary(op binaryop, a Value, b Value) Value {
+	switch op {
 	case binarylogicalor:
 		return a.LogicalOr(b)
 	case binarylogicaland:
@@ -152,15 +102,3 @@ func evalbinary(resolver Resolver, node binaryexpr) Value {
 		panic("invalid binary expression")
 	}
 }
-
-func evalternary(resolver Resolver, node ternaryexpr) Value {
-	a := evalnode(resolver, node.a).Value().Interface()
-	cond, ok := a.(bool)
-	if !ok {
-		panic(fmt.Errorf("unexpected type %T for ternary", cond))
-	}
-	if cond {
-		return evalnode(resolver, node.b)
-	}
-	return evalnode(resolver, node.c)
-}
@@ -147,3 +147,174 @@ func TestError(t *testing.T) {
 		assert.EqualError(t, err, test.err)
 	}
 }
+
+type TestBuiltinStruct struct {
+	Items []uint16
+	Array [4]byte
+	Table map[string]int
+	Name  string
+	Data  []byte
+	Len   uint8
+	Count int32
+	Ratio float64
+}
+
+func TestEvalBuiltins(t *testing.T) {
+	s := TestBuiltinStruct{
+		Items: make([]uint16, 3, 8),
+		Table: map[string]int{"a": 1, "b": 2},
+		Name:  "héllo",
+		Data:  []byte{0x01, 0x02, 0x03},
+		Len:   200,
+		Count: -5,
+		Ratio: -2.5,
+	}
+
+	tests := []struct {
+		expr   string
+		result interface{}
+	}{
+		{"len(Items)", 3},
+		{"cap(Items)", 8},
+		{"len(Array)", 4},
+		{"cap(Array)", 4},
+		{"len(Table)", 2},
+		{"len(Name)", 6},
+		{`len("abc") < int(Count)`, false},
+		{"len(Items) < 4", true},
+		{"min(3, 1, 2)", 1},
+		{"max(3, 1, 2)", 3},
+		{"min(Len, 10)", uint8(10)},
+		{"max(Len, 10)", uint8(200)},
+		{"max(Count, -10)", int32(-5)},
+		{"min(Ratio, 1)", -2.5},
+		{"max(1.5, Ratio)", 1.5},
+		{`min("b", "a", "c")`, "a"},
+		{"abs(Count)", int32(5)},
+		{"abs(Ratio)", 2.5},
+		{"abs(-3)", 3},
+		{"align(Len, 16)", uint8(208)},
+		{"align(5, 4)", 8},
+		{"align(8, 4)", 8},
+		{"uint32(Len) * 2", uint32(400)},
+		{"uint8(Count)", uint8(251)},
+		{"int(Ratio)", -2},
+		{"float64(Count) / 2", -2.5},
+		{"byte(0x1ff)", uint8(0xff)},
+		{"int64(len(Items))", int64(3)},
+		{"bool(Len > 1)", true},
+		{"string(Data) == \"\\x01\\x02\\x03\"", true},
+		{"string(Name)", "héllo"},
+	}
+
+	for _, test := range tests {
+		resolver := NewStructResolver(reflect.ValueOf(s))
+		result, err := Eval(resolver, test.expr)
+		if assert.Nil(t, err, test.expr) {
+			assert.Equal(t, test.result, result, test.expr)
+		}
+	}
+}
+
+func TestEvalStdLib(t *testing.T) {
+	s := TestBuiltinStruct{
+		Name:  "  Hello, World  ",
+		Data:  []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+		Items: []uint16{1},
+		Ratio: 2,
+	}
+
+	resolver := NewMetaResolver()
+	resolver.AddResolver(NewStructResolver(reflect.ValueOf(s)))
+	resolver.AddResolver(NewMapResolver(StdLib()))
+
+	tests := []struct {
+		expr   string
+		result interface{}
+	}{
+		{`strings.TrimSpace(Name)`, "Hello, World"},
+		{`strings.HasPrefix(strings.TrimSpace(Name), "Hello")`, true},
+		{`strings.Index(Name, "World")`, 9},
+		{`strings.ToUpper("abc")`, "ABC"},
+		{`strings.Repeat("ab", 3)`, "ababab"},
+		{`bytes.HasPrefix(Data, Data)`, true},
+		{`bytes.IndexByte(Data, 4)`, 3},
+		{`bytes.Equal(Data, Data)`, true},
+		{`math.Sqrt(16)`, 4.0},
+		{`math.Pow(Ratio, 10)`, 1024.0},
+		{`math.Floor(-1.5)`, -2.0},
+		{`math.MaxUint16 + 1`, 65536},
+		{`math.MaxUint8 == 255`, true},
+		{`math.Float32bits(1)`, uint32(0x3f800000)},
+		{`binary.BigEndian.Uint16(Data)`, uint16(0x0102)},
+		{`binary.LittleEndian.Uint32(Data)`, uint32(0x04030201)},
+		{`binary.BigEndian.Uint64(Data)`, uint64(0x0102030405060708)},
+		{`binary.Uvarint(Data)`, uint64(1)},
+		{`utf8.RuneCountInString("héllo")`, 5},
+		{`utf8.ValidString(Name)`, true},
+		{`utf8.RuneLen('é')`, 2},
+		{`utf8.UTFMax`, int64(4)},
+	}
+
+	for _, test := range tests {
+		result, err := Eval(resolver, test.expr)
+		if assert.Nil(t, err, test.expr) {
+			assert.Equal(t, test.result, result, test.expr)
+		}
+	}
+}
+
+func TestEvalErrorResult(t *testing.T) {
+	resolver := NewMetaResolver()
+	resolver.AddResolver(NewMapResolver(map[string]Value{"Data": ValueOf([]byte{0x80})}))
+	resolver.AddResolver(NewMapResolver(StdLib()))
+
+	_, err := Eval(resolver, "binary.Uvarint(Data)")
+	assert.Equal(t, ErrInvalidVarint, err)
+}
+
+func TestEvalSlices(t *testing.T) {
+	s := TestBuiltinStruct{
+		Array: [4]byte{'I', 'H', 'D', 'R'},
+		Data:  []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a},
+		Name:  "hello",
+		Len:   2,
+	}
+
+	tests := []struct {
+		expr   string
+		result interface{}
+	}{
+		{"Name[1:3]", "el"},
+		{"Name[:Len]", "he"},
+		{"Name[3:]", "lo"},
+		{"Name[:]", "hello"},
+		{"Data[1:4]", []byte("PNG")},
+		{"Array[2:]", []byte("DR")},
+		{"len(Data[Len:])", 4},
+		{"Data[1:4] == \"PNG\"", true},
+		{"Array == \"IHDR\"", true},
+		{"\"IHDR\" == Array", true},
+		{"Array != \"IEND\"", true},
+		{"Array[:2] == Array[:2]", true},
+		{"Data == Array", false},
+		{"hasPrefix(Data, \"\\x89PNG\")", true},
+		{"hasPrefix(Array, \"IH\")", true},
+		{"hasSuffix(Name, \"llo\")", true},
+		{"hasPrefix(Name, Data)", false},
+	}
+
+	for _, test := range tests {
+		resolver := NewStructResolver(reflect.ValueOf(s))
+		result, err := Eval(resolver, test.expr)
+		if assert.Nil(t, err, test.expr) {
+			assert.Equal(t, test.result, result, test.expr)
+		}
+	}
+
+	resolver := NewStructResolver(reflect.ValueOf(s))
+	_, err := Eval(resolver, "Name[2:6]")
+	assert.EqualError(t, err, "slice bounds out of range [2:6] with length 5")
+	_, err = Eval(resolver, "Array[3:2]")
+	assert.EqualError(t, err, "slice bounds out of range [3:2] with length 4")
+}
@@ -43,7 +43,7 @@ func isident(c rune) bool {
 }
 
 func iswhitespace(c rune) bool {
-	return c == ' ' || c == '\t'
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
 }
 
 // tokenkind is an enumeration of different kinds of tokens.
@@ -88,11 +88,14 @@ const (
 
 	leftparentoken
 	leftbrackettoken
+	leftbracetoken
 	commatoken
 	periodtoken
+	rangetoken
 
 	rightparentoken
 	rightbrackettoken
+	rightbracetoken
 	colontoken
 	ternarytoken
 
@@ -112,6 +115,7 @@ const (
 	uint64keyword
 	uintptrkeyword
 	nilkeyword
+	structkeyword
 )
 
 var keywordmap = map[string]tokenkind{
@@ -131,6 +135,7 @@ var keywordmap = map[string]tokenkind{
 	"uint64":  uint64keyword,
 	"uintptr": uintptrkeyword,
 	"nil":     nilkeyword,
+	"struct":  structkeyword,
 }
 
 const eof = utf8.MaxRune + 0x0001
@@ -154,6 +159,16 @@ type scanner struct {
 	r   io.RuneScanner
 	p   int
 	eof bool
+
+	// prev holds the last nprev runes read, and back the runes that have been
+	// unread, most recent last. io.RuneScanner can only unread one rune, but
+	// telling a number from a range operator takes two runes of lookahead.
+	prev  [2]rune
+	nprev int
+	back  []rune
+
+	// lines holds the offsets at which each line after the first starts.
+	lines []int
 }
 
 func newscanner(r io.RuneScanner) *scanner {
@@ -161,30 +176,72 @@ func newscanner(r io.RuneScanner) *scanner {
 }
 
 func (s *scanner) readrune() rune {
-	if s.eof {
-		return eof
+	c := rune(eof)
+	switch {
+	case len(s.back) > 0:
+		c = s.back[len(s.back)-1]
+		s.back = s.back[:len(s.back)-1]
+	case !s.eof:
+		r, _, err := s.r.ReadRune()
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			panic(err)
+		} else {
+			c = r
+		}
+	}
+	if s.nprev == len(s.prev) {
+		copy(s.prev[:], s.prev[1:])
+		s.nprev--
 	}
-	c, _, err := s.r.ReadRune()
-	if err == io.EOF {
-		s.eof = true
+	s.prev[s.nprev] = c
+	s.nprev++
+	if c == eof {
 		return eof
-	} else if err != nil {
-		panic(err)
 	}
 	s.p++
+	if c == '\n' && (len(s.lines) == 0 || s.lines[len(s.lines)-1] < s.p) {
+		s.lines = append(s.lines, s.p)
+	}
 	return c
 }
 
 func (s *scanner) unreadrune() {
-	if s.eof {
-		return
+	if s.nprev == 0 {
+		panic("unreadrune: no rune to unread")
 	}
-	if err := s.r.UnreadRune(); err != nil {
-		panic(err)
+	s.nprev--
+	c := s.prev[s.nprev]
+	if c == eof {
+		return
 	}
+	s.back = append(s.back, c)
 	s.p--
 }
 
+// position returns the 1-based line and column of an offset.
+func (s *scanner) position(pos int) (line, column int) {
+	start := 0
+	line = 1
+	for _, l := range s.lines {
+		if l > pos {
+			break
+		}
+		start = l
+		line++
+	}
+	return line, pos - start + 1
+}
+
+// runetext describes a rune for error messages.
+func runetext(r rune) string {
+	if r == eof {
+		return "EOF"
+	}
+	return strconv.QuoteRune(r)
+}
+
 func (s *scanner) skipws() {
 	for {
 		c := s.readrune()
@@ -206,7 +263,7 @@ func (s *scanner) accept(c rune) bool {
 func (s *scanner) expect(c rune) {
 	r := s.readrune()
 	if r != c {
-		panic(fmt.Errorf("expected %c", r))
+		panic(fmt.Errorf("expected %s, got %s", runetext(c), runetext(r)))
 	}
 }
 
@@ -228,7 +285,7 @@ func (s *scanner) acceptfn(f func(rune) bool) (rune, bool) {
 func (s *scanner) expectfn(f func(rune) bool) rune {
 	r, ok := s.acceptfn(f)
 	if !ok {
-		panic(fmt.Errorf("unexpected %c", r))
+		panic(fmt.Errorf("unexpected %s", runetext(r)))
 	}
 	return r
 }
@@ -279,6 +336,12 @@ func (s *scanner) scannumber(t token) token {
 	}
 	for {
 		if r, ok := s.acceptfn(isnumber); ok {
+			if r == '.' && s.accept('.') {
+				// A range operator follows the number.
+				s.unreadrune()
+				s.unreadrune()
+				break
+			}
 			t.sval += string(r)
 			continue
 		}
@@ -375,6 +438,8 @@ func (s *scanner) scanstring(quote byte) token {
 		switch {
 		case s.accept(rune(quote)):
 			return token{kind: strtoken, sval: string(str)}
+		case s.accept(eof):
+			return s.errsymf("unterminated string literal")
 		case s.accept('\\'):
 			str = append(str, s.scanescape(quote)...)
 		default:
@@ -487,7 +552,7 @@ func (s *scanner) scan() (t token) {
 		case s.accept('='):
 			return s.tokensym(equaltoken, "==")
 		default:
-			return s.errsymf("unexpected rune %c", s.readrune())
+			return s.errsymf("unexpected rune %s", runetext(s.readrune()))
 		}
 	case s.accept('!'):
 		switch {
@@ -500,12 +565,16 @@ func (s *scanner) scan() (t token) {
 		return s.tokensym(leftparentoken, "(")
 	case s.accept('['):
 		return s.tokensym(leftbrackettoken, "[")
+	case s.accept('{'):
+		return s.tokensym(leftbracetoken, "{")
 	case s.accept(','):
 		return s.tokensym(commatoken, ",")
 	case s.accept('.'):
 		switch {
 		default:
 			return s.tokensym(periodtoken, ".")
+		case s.accept('.'):
+			return s.tokensym(rangetoken, "..")
 		case s.peekmatch(isdigit):
 			return s.scannumber(token{sval: "."})
 		}
@@ -513,11 +582,13 @@ func (s *scanner) scan() (t token) {
 		return s.tokensym(rightparentoken, ")")
 	case s.accept(']'):
 		return s.tokensym(rightbrackettoken, "]")
+	case s.accept('}'):
+		return s.tokensym(rightbracetoken, "}")
 	case s.accept(':'):
 		return s.tokensym(colontoken, ":")
 	case s.accept('?'):
 		return s.tokensym(ternarytoken, "?")
 	default:
-		return s.errsymf("unexpected rune %c", s.readrune())
+		return s.errsymf("unexpected rune %s", runetext(s.readrune()))
 	}
 }
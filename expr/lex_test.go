@@ -132,6 +132,21 @@ func TestScanValid(t *testing.T) {
 				{kind: eoftoken, pos: 45},
 			},
 		},
+		{
+			input: "0x10..0x1F, 1.5..a.b",
+			expected: []token{
+				{kind: inttoken, pos: 0, sval: "0x10", ival: 16, uval: 16, fval: 16},
+				{kind: rangetoken, pos: 4, sval: ".."},
+				{kind: inttoken, pos: 6, sval: "0x1F", ival: 31, uval: 31, fval: 31},
+				{kind: commatoken, pos: 10, sval: ","},
+				{kind: floattoken, pos: 12, sval: "1.5", ival: 1, uval: 1, fval: 1.5},
+				{kind: rangetoken, pos: 15, sval: ".."},
+				{kind: identtoken, pos: 17, sval: "a"},
+				{kind: periodtoken, pos: 18, sval: "."},
+				{kind: identtoken, pos: 19, sval: "b"},
+				{kind: eoftoken, pos: 20},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,111 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LiteralError is returned when a composite literal cannot be built, such as
+// when its elements have mismatched types.
+type LiteralError struct {
+	Msg string
+}
+
+func (e LiteralError) Error() string {
+	return e.Msg
+}
+
+// literal builds a composite value from the top n values on the stack.
+type literal struct {
+	n    int
+	make func(args []Value) Value
+}
+
+// elemtype returns the common type of the elements of a literal. Untyped
+// constants take on their default types, as in Go.
+func elemtype(what string, vals []Value) Type {
+	t := vals[0].Type()
+	for _, v := range vals[1:] {
+		var ok bool
+		if t, ok = unify(t, v.Type()); !ok {
+			panic(LiteralError{fmt.Sprintf("mismatched types %s and %s in %s", vals[0].Type(), v.Type(), what)})
+		}
+	}
+	if t.Kind() == UntypedNil {
+		panic(LiteralError{fmt.Sprintf("use of untyped nil in %s", what)})
+	}
+	return defaulttype(t)
+}
+
+// isExported returns true if a struct field name is exported.
+func isExported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// isComparable returns true if values of type t may be used as map keys.
+func isComparable(t Type) bool {
+	return isNumeric(t) || isBool(t) || t.Kind() == String
+}
+
+func makearray(elems []Value) Value {
+	et := elemtype("array literal", elems)
+	t := NewArrayType(len(elems), et)
+	v := reflect.New(toreflecttype(t)).Elem()
+	for i, e := range elems {
+		v.Index(i).Set(promote(e, et).Value())
+	}
+	return val{v, t}
+}
+
+// makemap builds a map from keys and values, which alternate in args. Later
+// values replace earlier values with the same key.
+func makemap(args []Value) Value {
+	keys := make([]Value, 0, len(args)/2)
+	vals := make([]Value, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+		vals = append(vals, args[i+1])
+	}
+	kt, vt := elemtype("map keys", keys), elemtype("map values", vals)
+	if !isComparable(kt) {
+		panic(LiteralError{fmt.Sprintf("invalid map key type %s", kt)})
+	}
+	t := NewMapType(kt, vt)
+	v := reflect.MakeMap(toreflecttype(t))
+	for i := range keys {
+		v.SetMapIndex(promote(keys[i], kt).Value(), promote(vals[i], vt).Value())
+	}
+	return val{v, t}
+}
+
+// structmaker returns a function that builds a struct with the given field
+// names, which must be exported and distinct.
+func structmaker(names []string) func(args []Value) Value {
+	return func(args []Value) Value {
+		fields := make([]Field, len(names))
+		seen := map[string]bool{}
+		for i, name := range names {
+			if !isExported(name) {
+				panic(LiteralError{fmt.Sprintf("struct literal field %s is not exported", name)})
+			}
+			if seen[name] {
+				panic(LiteralError{fmt.Sprintf("duplicate field %s in struct literal", name)})
+			}
+			seen[name] = true
+			ft := args[i].Type()
+			if ft.Kind() == UntypedNil {
+				panic(LiteralError{fmt.Sprintf("use of untyped nil in struct literal field %s", name)})
+			}
+			fields[i] = Field{Name: name, Type: defaulttype(ft)}
+		}
+		t := NewStructType(fields)
+		v := reflect.New(toreflecttype(t)).Elem()
+		for i, f := range fields {
+			v.Field(i).Set(promote(args[i], f.Type).Value())
+		}
+		return val{v, t}
+	}
+}
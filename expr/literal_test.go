@@ -0,0 +1,74 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalLiterals(t *testing.T) {
+	s := TestBuiltinStruct{
+		Items: []uint16{1, 2, 3},
+		Name:  "hello",
+		Len:   2,
+		Count: -1,
+	}
+
+	tests := []struct {
+		expr   string
+		result interface{}
+	}{
+		{"[1, 2, 3]", [3]int{1, 2, 3}},
+		{"[Len, 3]", [2]uint8{2, 3}},
+		{"[1.5, 2.5][1]", 2.5},
+		{"[\"a\", Name][Len - 1]", "hello"},
+		{"{1: 4, 2: 8, 3: 16}[Len]", 8},
+		{"{1: 4, 2: 8, 3: 16}[Items[2]]", 16},
+		{"{1: 4, 2: 8, 3: 16}[7]", 0},
+		{"{255: 1}[Count]", 0},
+		{"{\"hello\": true}[Name]", true},
+		{"{\"hello\": true}[\"world\"]", false},
+		{"len({1: 2, 1: 3})", 1},
+		{"struct{A: Len, B: Name}", struct {
+			A uint8
+			B string
+		}{2, "hello"}},
+		{"struct{Size: Len * 2}.Size", uint8(4)},
+		{"len([Items[0], Items[1]])", 2},
+	}
+
+	for _, test := range tests {
+		resolver := NewStructResolver(reflect.ValueOf(s))
+		result, err := Eval(resolver, test.expr)
+		if assert.Nil(t, err, test.expr) {
+			assert.Equal(t, test.result, result, test.expr)
+		}
+	}
+
+	resolver := NewStructResolver(reflect.ValueOf(s))
+	_, err := Eval(resolver, "[1, 2][Len]")
+	assert.EqualError(t, err, "index out of range [2] with length 2")
+	_, err = Eval(resolver, "Items[Count]")
+	assert.EqualError(t, err, "index out of range [-1] with length 3")
+	_, err = Eval(resolver, "Name[5]")
+	assert.EqualError(t, err, "index out of range [5] with length 5")
+	_, err = Eval(resolver, "[1, \"a\"]")
+	assert.EqualError(t, err, "mismatched types untyped int constant and string in array literal")
+	_, err = Eval(resolver, "struct{a: 1}")
+	assert.EqualError(t, err, "struct literal field a is not exported")
+}
+
+func TestProgramConstantLiteral(t *testing.T) {
+	program, err := ParseString("{1: 4, 2: 8}")
+	assert.Nil(t, err)
+	v, ok := program.Constant()
+	assert.True(t, ok)
+	assert.Equal(t, map[int]int{1: 4, 2: 8}, v)
+
+	program, err = ParseString("[1, Len]")
+	assert.Nil(t, err)
+	_, ok = program.Constant()
+	assert.False(t, ok)
+	assert.Equal(t, []string{"Len"}, program.Deps())
+}
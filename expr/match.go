@@ -0,0 +1,141 @@
+package expr
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrMisplacedRange is returned when a range is evaluated outside of a match.
+var ErrMisplacedRange = errors.New("range used outside of a match")
+
+// alternative is an alternative of a match, compiled on its own. hi is only
+// set for ranges.
+type alternative struct {
+	lo, hi *code
+}
+
+// matchcode holds the alternatives of a program, compiled the first time
+// the program is matched.
+type matchcode struct {
+	once sync.Once
+	alts []alternative
+}
+
+// alternatives returns the alternatives of a program.
+func (p *Program) alternatives() []alternative {
+	p.match.once.Do(func() {
+		if p.root == nil {
+			return
+		}
+		for _, n := range flattengroup(p.root) {
+			if r, ok := n.(rangeexpr); ok {
+				p.match.alts = append(p.match.alts, alternative{lo: compile(r.lo), hi: compile(r.hi)})
+			} else {
+				p.match.alts = append(p.match.alts, alternative{lo: compile(n)})
+			}
+		}
+	})
+	return p.match.alts
+}
+
+// EvalMatch returns true if a value matches a program, such as a case of a
+// switch. The program is a comma separated list of alternatives, which are
+// tried in order until one matches:
+//
+//	1, 2, 5          matches any of the values
+//	0x10..0x1F       matches values in a range, inclusive of both bounds
+//	x > 0x80         matches if a boolean expression is true
+//
+// Alternatives of type bool are treated as predicates unless the value is
+// itself a bool; predicates refer to the value through the resolver. Other
+// alternatives are compared with ==, so byte arrays and slices also match
+// strings with the same contents.
+func EvalMatch(resolver Resolver, program *Program, value interface{}) (match bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if rerr, ok := r.(error); ok {
+				err = rerr
+			} else {
+				panic(r)
+			}
+		}
+	}()
+
+	v := ValueOf(value)
+	for _, alt := range program.alternatives() {
+		if alt.hi != nil {
+			lo, hi := alt.lo.run(resolver), alt.hi.run(resolver)
+			if lo.LesserEqual(v).RawValue().(bool) && v.LesserEqual(hi).RawValue().(bool) {
+				return true, nil
+			}
+			continue
+		}
+		a := alt.lo.run(resolver)
+		if isBool(a.Type()) && !isBool(v.Type()) {
+			if a.RawValue().(bool) {
+				return true, nil
+			}
+			continue
+		}
+		if v.Equal(a).RawValue().(bool) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckMatch type checks the alternatives of a program, as matched by
+// EvalMatch against values of type value. Ranges must have bounds of an
+// ordered type that is comparable with the value, and other alternatives
+// must be predicates or comparable with the value. All errors found are
+// returned together as CheckErrors.
+func CheckMatch(resolver TypeResolver, program *Program, value Type) error {
+	c := checker{resolver: resolver}
+	if program.root == nil {
+		return CheckErrors{{Pos: 0, Msg: "empty expression"}}
+	}
+	for _, n := range flattengroup(program.root) {
+		c.checkalternative(n, value)
+	}
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+	return nil
+}
+
+// checkalternative checks an alternative of a match against the type of the
+// matched value.
+func (c *checker) checkalternative(n node, value Type) {
+	if r, ok := n.(rangeexpr); ok {
+		lo, hi := c.check(r.lo), c.check(r.hi)
+		if lo == nil || hi == nil {
+			return
+		}
+		t, ok := unify(lo, hi)
+		if ok {
+			t, ok = unify(t, value)
+		}
+		if !ok {
+			c.errorf(n, "invalid range %s (mismatched types %s, %s and %s)", r.source(), lo, hi, value)
+			return
+		}
+		if !isOrdered(t) {
+			c.errorf(n, "invalid range %s (operator not defined on %s)", r.source(), t)
+		}
+		return
+	}
+
+	t := c.check(n)
+	if t == nil {
+		return
+	}
+	if isBool(t) && !isBool(value) {
+		return
+	}
+	if isBytesOrString(t) && isBytesOrString(value) && (isBytes(t) || isBytes(value)) {
+		return
+	}
+	if _, ok := unify(t, value); !ok {
+		c.errorf(n, "invalid case %s (mismatched types %s and %s)", n.source(), t, value)
+	}
+}
@@ -0,0 +1,97 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalMatch(t *testing.T) {
+	tests := []struct {
+		expr  string
+		value interface{}
+		match bool
+	}{
+		{"1", uint8(1), true},
+		{"1", uint8(2), false},
+		{"1, 2, 5", uint16(5), true},
+		{"1, 2, 5", uint16(3), false},
+		{"0x10..0x1F", uint8(0x10), true},
+		{"0x10..0x1F", uint8(0x1F), true},
+		{"0x10..0x1F", uint8(0x20), false},
+		{"1, 0x10..0x1F, Max", int32(7), true},
+		{"X > 0x80", uint8(1), true},
+		{"X < 0x80, 0xff", uint8(0xff), true},
+		{"X < 0x80", uint8(0xff), false},
+		{"true", true, true},
+		{"Flag", false, true},
+		{`"IHDR"`, [4]byte{'I', 'H', 'D', 'R'}, true},
+		{`"IEND", "IDAT"`, []byte("IDAT"), true},
+		{`"a".."m"`, "hello", true},
+		{`"a".."m"`, "world", false},
+	}
+
+	resolver := NewMapResolver(map[string]Value{
+		"X":    ValueOf(uint8(0xff)),
+		"Max":  ValueOf(int32(7)),
+		"Flag": ValueOf(false),
+	})
+
+	for _, test := range tests {
+		program, err := ParseString(test.expr)
+		if !assert.Nil(t, err, test.expr) {
+			continue
+		}
+		match, err := EvalMatch(resolver, program, test.value)
+		assert.Nil(t, err, test.expr)
+		assert.Equal(t, test.match, match, test.expr)
+	}
+}
+
+func TestEvalMatchErrors(t *testing.T) {
+	program, err := ParseString("1..2")
+	assert.Nil(t, err)
+
+	_, err = EvalProgram(NewMapResolver(nil), program)
+	assert.Equal(t, ErrMisplacedRange, err)
+
+	_, err = EvalMatch(NewMapResolver(nil), program, true)
+	assert.NotNil(t, err)
+}
+
+func TestCheckMatch(t *testing.T) {
+	tests := []struct {
+		expr  string
+		value Type
+		err   string
+	}{
+		{"1, 2, 5", NewPrimitiveType(Uint8), ""},
+		{"0x10..0x1F", NewPrimitiveType(Uint8), ""},
+		{"1, 0x10..0x1F, Len", NewPrimitiveType(Uint8), ""},
+		{"Len > 0x80, 0xff", NewPrimitiveType(Int32), ""},
+		{"true", NewPrimitiveType(Bool), ""},
+		{"Flag", NewPrimitiveType(Bool), ""},
+		{`"IHDR"`, NewSliceType(NewPrimitiveType(Uint8)), ""},
+		{`"a".."m"`, NewPrimitiveType(String), ""},
+		{"Count", NewPrimitiveType(Uint8), "col 1: invalid case Count (mismatched types int32 and uint8)"},
+		{`1, "a"`, NewPrimitiveType(Uint8), "col 4: invalid case \"a\" (mismatched types string and uint8)"},
+		{"1..Name", NewPrimitiveType(Uint8), "col 1: invalid range 1..Name (mismatched types untyped int constant, string and uint8)"},
+		{"Flag..true", NewPrimitiveType(Bool), "col 1: invalid range Flag..true (operator not defined on bool)"},
+		{"Missing", NewPrimitiveType(Uint8), "col 1: undefined: Missing"},
+	}
+
+	resolver := NewStructTypeResolver(TestCheckStruct{})
+
+	for _, test := range tests {
+		program, err := ParseString(test.expr)
+		if !assert.Nil(t, err, test.expr) {
+			continue
+		}
+		err = CheckMatch(resolver, program, test.value)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.expr)
+		} else {
+			assert.Nil(t, err, test.expr)
+		}
+	}
+}
@@ -4,20 +4,82 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 // Program represents a parsed expression.
 type Program struct {
 	root node
+	code *code
+
+	// match holds the code used by EvalMatch.
+	match matchcode
 }
 
-// Parse parses an expression into a program.
-func Parse(r io.RuneScanner) *Program {
-	return &Program{newparser(newscanner(r)).parse()}
+// SyntaxError is returned by Parse when an expression is malformed. Pos is
+// the offset of the offending token in runes; Line and Column are 1-based.
+type SyntaxError struct {
+	Pos    int
+	Line   int
+	Column int
+
+	// Token is the source of the offending token, or EOF at the end of the
+	// expression.
+	Token string
+
+	// Expected lists the alternatives that would have been valid at Pos, if
+	// the error is not lexical.
+	Expected []string
+
+	// Msg describes a lexical error, such as an unterminated string.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "unexpected " + e.Token
+		if len(e.Expected) > 0 {
+			msg += ", expected " + strings.Join(e.Expected, " or ")
+		}
+	}
+	return fmt.Sprintf("line %d, col %d: %s", e.Line, e.Column, msg)
+}
+
+// Parse parses an expression into a program. Malformed expressions return a
+// *SyntaxError.
+func Parse(r io.RuneScanner) (program *Program, err error) {
+	s := newscanner(r)
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			serr.Line, serr.Column = s.position(serr.Pos)
+			program, err = nil, serr
+		}
+	}()
+	root := newparser(s).parse()
+	return &Program{root: root, code: compile(root)}, nil
+}
+
+// Constant returns the value of the program if it does not depend on any
+// identifiers, having been folded into a constant when it was parsed.
+func (p *Program) Constant() (interface{}, bool) {
+	return p.code.constant()
+}
+
+// Deps returns the identifiers that the program depends on, in order of first
+// use. Members of values are not included, and neither are identifiers in
+// branches removed by constant folding.
+func (p *Program) Deps() []string {
+	return p.code.deps()
 }
 
 // ParseString parses an expression from a string.
-func ParseString(s string) *Program {
+func ParseString(s string) (*Program, error) {
 	return Parse(bytes.NewBufferString(s))
 }
 
@@ -26,6 +88,9 @@ type parser struct {
 
 	a bool
 	t token
+
+	// closers is the stack of tokens closing the groups being parsed.
+	closers []tokenkind
 }
 
 func newparser(s *scanner) *parser {
@@ -52,36 +117,98 @@ func (p *parser) accept(k tokenkind) bool {
 	return false
 }
 
-func (p *parser) expect(k tokenkind) {
+// accepttype accepts a type keyword. Types are only used as conversion
+// functions, so they are parsed as identifiers naming builtins.
+func (p *parser) accepttype() bool {
+	if k := p.readtoken().kind; k >= boolkeyword && k <= uintptrkeyword {
+		p.consume()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(k tokenkind, expected ...string) {
 	if p.readtoken().kind != k {
-		panic(fmt.Errorf("expected %s token, got %s", k, p.t.kind))
+		p.fail(p.readtoken(), expected...)
 	}
 	p.consume()
 }
 
+// fail panics with a syntax error at token t.
+func (p *parser) fail(t *token, expected ...string) {
+	if t.kind == errtoken {
+		panic(&SyntaxError{Pos: t.pos, Msg: t.eval.Error()})
+	}
+	panic(&SyntaxError{Pos: t.pos, Token: t.describe(), Expected: expected})
+}
+
+// describe returns the source of a token for error messages.
+func (t *token) describe() string {
+	switch t.kind {
+	case eoftoken:
+		return "EOF"
+	case strtoken:
+		return strconv.Quote(t.sval)
+	case runetoken:
+		return strconv.QuoteRune(rune(t.ival))
+	}
+	return t.sval
+}
+
+// group parses the contents of a parenthesized or bracketed expression, up
+// to and including the closing token. Empty groups return nil.
+func (p *parser) group(closer tokenkind) node {
+	if p.accept(closer) {
+		return nil
+	}
+	p.closers = append(p.closers, closer)
+	n := p.parseexpr(1)
+	p.closers = p.closers[:len(p.closers)-1]
+	return n
+}
+
 // This parser is strongly based on byuu's modified recursive-descent algorithm
 // (particularly the 'depth' parameter.)
 // https://github.com/byuu/bsnes/blob/master/nall/string/eval/parser.hpp
 func (p *parser) parseexpr(depth int) node {
 	var n node
 
+	// operand parses an operand, which must be present.
+	operand := func(depth int) node {
+		o := p.parseexpr(depth)
+		if o == nil {
+			p.fail(p.readtoken(), "expression")
+		}
+		return o
+	}
+
+	// closed parses a non-empty group.
+	closed := func(closer tokenkind) node {
+		t := *p.readtoken()
+		o := p.group(closer)
+		if o == nil {
+			p.fail(&t, "expression")
+		}
+		return o
+	}
+
 	unary := func(op unaryop, depth int) {
-		n = unaryexpr{op: op, n: p.parseexpr(depth)}
+		n = unaryexpr{op: op, n: operand(depth)}
 	}
 
 	binary := func(op binaryop, depth int) {
 		if n == nil {
-			panic("unexpected binary op")
+			p.fail(&p.t, "expression")
 		}
-		n = binaryexpr{op: op, a: n, b: p.parseexpr(depth)}
+		n = binaryexpr{op: op, a: n, b: operand(depth)}
 	}
 
 	ternary := func(depth int) {
 		t := ternaryexpr{}
 		t.a = n
-		t.b = p.parseexpr(depth)
-		p.expect(colontoken)
-		t.c = p.parseexpr(depth)
+		t.b = operand(depth)
+		p.expect(colontoken, ":")
+		t.c = operand(depth)
 		n = t
 	}
 
@@ -100,8 +227,18 @@ func (p *parser) parseexpr(depth int) node {
 		n = newrunenode(p.t)
 	case p.accept(nilkeyword):
 		n = newnilnode(p.t)
+	case p.accepttype():
+		n = newidentnode(p.t)
 	case p.accept(leftparentoken):
-		n = p.parseexpr(1)
+		n = closed(rightparentoken)
+	case p.accept(leftbrackettoken):
+		n = p.arraylit(p.t.pos)
+	case p.accept(leftbracetoken):
+		n = p.maplit(p.t.pos)
+	case p.accept(structkeyword):
+		pos := p.t.pos
+		p.expect(leftbracetoken, "{")
+		n = p.structlit(pos)
 	default:
 	}
 
@@ -110,15 +247,16 @@ func (p *parser) parseexpr(depth int) node {
 			break
 		}
 		if n != nil && p.accept(periodtoken) {
-			binary(binarymember, 8)
+			p.expect(identtoken, "identifier")
+			n = binaryexpr{op: binarymember, a: n, b: newidentnode(p.t)}
 			continue
 		}
 		if n != nil && p.accept(leftparentoken) {
-			binary(binarycall, 1)
+			n = binaryexpr{op: binarycall, a: n, b: p.group(rightparentoken)}
 			continue
 		}
 		if n != nil && p.accept(leftbrackettoken) {
-			binary(binarysubscript, 1)
+			n = p.subscript(n)
 			continue
 		}
 		if n == nil && p.accept(addtoken) {
@@ -230,6 +368,13 @@ func (p *parser) parseexpr(depth int) node {
 			ternary(3)
 			continue
 		}
+		if p.accept(rangetoken) {
+			if n == nil {
+				p.fail(&p.t, "expression")
+			}
+			n = rangeexpr{lo: n, hi: operand(3)}
+			continue
+		}
 		if depth >= 2 {
 			break
 		}
@@ -237,15 +382,109 @@ func (p *parser) parseexpr(depth int) node {
 			binary(binarygroup, 2)
 			continue
 		}
-		if depth >= 1 && (p.accept(rightparentoken) || p.accept(rightbrackettoken)) {
+		if n == nil {
+			p.fail(p.readtoken(), "expression")
+		}
+		if depth >= 1 {
+			closer := p.closers[len(p.closers)-1]
+			if closer == colontoken {
+				// The first operand of a subscript is followed by : or ], which
+				// is left for subscript.
+				if k := p.readtoken().kind; k != colontoken && k != rightbrackettoken {
+					p.fail(p.readtoken(), "operator", ":", "]")
+				}
+				break
+			}
+			p.expect(closer, "operator", closertext[closer])
 			break
 		}
-		p.expect(eoftoken)
+		p.expect(eoftoken, "operator", "EOF")
 		break
 	}
 	return n
 }
 
+// subscript parses an index or slice expression of a, after the [.
+func (p *parser) subscript(a node) node {
+	var lo node
+	if !p.accept(colontoken) {
+		lo = p.group(colontoken)
+		if p.accept(rightbrackettoken) {
+			return binaryexpr{op: binarysubscript, a: a, b: lo}
+		}
+		p.expect(colontoken, ":")
+	}
+	if p.accept(rightbrackettoken) {
+		return sliceexpr{a: a, lo: lo}
+	}
+	return sliceexpr{a: a, lo: lo, hi: p.group(rightbrackettoken)}
+}
+
+// element parses an element of a literal, which must be present.
+func (p *parser) element() node {
+	n := p.parseexpr(2)
+	if n == nil {
+		p.fail(p.readtoken(), "expression")
+	}
+	return n
+}
+
+// elements parses the comma separated elements of a literal up to and
+// including closer, calling elem for each. A trailing comma is allowed.
+func (p *parser) elements(closer tokenkind, elem func()) {
+	for !p.accept(closer) {
+		elem()
+		if !p.accept(commatoken) {
+			p.expect(closer, ",", closertext[closer])
+			return
+		}
+	}
+}
+
+// arraylit parses an array literal, after the [.
+func (p *parser) arraylit(pos int) node {
+	n := arraylit{pos: pos}
+	p.elements(rightbrackettoken, func() {
+		n.elems = append(n.elems, p.element())
+	})
+	if len(n.elems) == 0 {
+		p.fail(&p.t, "expression")
+	}
+	return n
+}
+
+// maplit parses a map literal, after the {.
+func (p *parser) maplit(pos int) node {
+	n := maplit{pos: pos}
+	p.elements(rightbracetoken, func() {
+		n.keys = append(n.keys, p.element())
+		p.expect(colontoken, ":")
+		n.vals = append(n.vals, p.element())
+	})
+	if len(n.keys) == 0 {
+		p.fail(&p.t, "expression")
+	}
+	return n
+}
+
+// structlit parses a struct literal, after the {.
+func (p *parser) structlit(pos int) node {
+	n := structlit{pos: pos}
+	p.elements(rightbracetoken, func() {
+		p.expect(identtoken, "identifier")
+		n.names = append(n.names, p.t.sval)
+		p.expect(colontoken, ":")
+		n.vals = append(n.vals, p.element())
+	})
+	return n
+}
+
+var closertext = map[tokenkind]string{
+	rightparentoken:   ")",
+	rightbrackettoken: "]",
+	rightbracetoken:   "}",
+}
+
 func (p *parser) parse() node {
 	return p.parseexpr(0)
 }
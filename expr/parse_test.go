@@ -103,3 +103,185 @@ func TestParseBasic(t *testing.T) {
 		assert.Equal(t, test.output, p.parse())
 	}
 }
+
+func TestParseSlice(t *testing.T) {
+	tests := []struct {
+		input  string
+		output node
+	}{
+		{"a[1:2]", sliceexpr{identnode{0, "a"}, intnode{2, 1, 1, false}, intnode{4, 2, 2, false}}},
+		{"a[:2]", sliceexpr{identnode{0, "a"}, nil, intnode{3, 2, 2, false}}},
+		{"a[1:]", sliceexpr{identnode{0, "a"}, intnode{2, 1, 1, false}, nil}},
+		{"a[:]", sliceexpr{identnode{0, "a"}, nil, nil}},
+		{"a[b ? 1 : 2:]", sliceexpr{
+			identnode{0, "a"},
+			ternaryexpr{identnode{2, "b"}, intnode{6, 1, 1, false}, intnode{10, 2, 2, false}},
+			nil,
+		}},
+		{"a[1:2][0]", binaryexpr{
+			binarysubscript,
+			sliceexpr{identnode{0, "a"}, intnode{2, 1, 1, false}, intnode{4, 2, 2, false}},
+			intnode{7, 0, 0, false},
+		}},
+	}
+
+	for _, test := range tests {
+		program, err := ParseString(test.input)
+		if assert.Nil(t, err, test.input) {
+			assert.Equal(t, test.output, program.root, test.input)
+			assert.Equal(t, test.input, program.root.source(), test.input)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		input  string
+		output node
+	}{
+		{"1..2", rangeexpr{intnode{0, 1, 1, false}, intnode{3, 2, 2, false}}},
+		{"a+1..b", rangeexpr{
+			binaryexpr{binaryadd, identnode{0, "a"}, intnode{2, 1, 1, false}},
+			identnode{5, "b"},
+		}},
+		{"0, 1..2", binaryexpr{
+			binarygroup,
+			intnode{0, 0, 0, false},
+			rangeexpr{intnode{3, 1, 1, false}, intnode{6, 2, 2, false}},
+		}},
+	}
+
+	for _, test := range tests {
+		program, err := ParseString(test.input)
+		if assert.Nil(t, err, test.input) {
+			assert.Equal(t, test.output, program.root, test.input)
+		}
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	tests := []struct {
+		input  string
+		output node
+	}{
+		{"[1, a]", arraylit{0, []node{intnode{1, 1, 1, false}, identnode{4, "a"}}}},
+		{"{1: 4, 2: 8}[a]", binaryexpr{
+			binarysubscript,
+			maplit{0, []node{intnode{1, 1, 1, false}, intnode{7, 2, 2, false}}, []node{intnode{4, 4, 4, false}, intnode{10, 8, 8, false}}},
+			identnode{13, "a"},
+		}},
+		{"struct{A: 1, B: a ? 2 : 3}.B", binaryexpr{
+			binarymember,
+			structlit{0, []string{"A", "B"}, []node{
+				intnode{10, 1, 1, false},
+				ternaryexpr{identnode{16, "a"}, intnode{20, 2, 2, false}, intnode{24, 3, 3, false}},
+			}},
+			identnode{27, "B"},
+		}},
+		{"struct{}", structlit{0, nil, nil}},
+	}
+
+	for _, test := range tests {
+		program, err := ParseString(test.input)
+		if assert.Nil(t, err, test.input) {
+			assert.Equal(t, test.output, program.root, test.input)
+			assert.Equal(t, test.input, program.root.source(), test.input)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   SyntaxError
+	}{
+		{"", SyntaxError{Pos: 0, Line: 1, Column: 1, Token: "EOF", Expected: []string{"expression"}}},
+		{"a +", SyntaxError{Pos: 3, Line: 1, Column: 4, Token: "EOF", Expected: []string{"expression"}}},
+		{"/ 2", SyntaxError{Pos: 0, Line: 1, Column: 1, Token: "/", Expected: []string{"expression"}}},
+		{"a b", SyntaxError{Pos: 2, Line: 1, Column: 3, Token: "b", Expected: []string{"operator", "EOF"}}},
+		{"(a + b", SyntaxError{Pos: 6, Line: 1, Column: 7, Token: "EOF", Expected: []string{"operator", ")"}}},
+		{"a[1)", SyntaxError{Pos: 3, Line: 1, Column: 4, Token: ")", Expected: []string{"operator", ":", "]"}}},
+		{"a[1:2)", SyntaxError{Pos: 5, Line: 1, Column: 6, Token: ")", Expected: []string{"operator", "]"}}},
+		{"a[1:2:3]", SyntaxError{Pos: 5, Line: 1, Column: 6, Token: ":", Expected: []string{"operator", "]"}}},
+		{"()", SyntaxError{Pos: 1, Line: 1, Column: 2, Token: ")", Expected: []string{"expression"}}},
+		{"a[]", SyntaxError{Pos: 2, Line: 1, Column: 3, Token: "]", Expected: []string{"expression"}}},
+		{"a ? b 1", SyntaxError{Pos: 6, Line: 1, Column: 7, Token: "1", Expected: []string{":"}}},
+		{"a &&\n  == b", SyntaxError{Pos: 7, Line: 2, Column: 3, Token: "==", Expected: []string{"expression"}}},
+		{"a == \"b", SyntaxError{Pos: 5, Line: 1, Column: 6, Msg: "unterminated string literal"}},
+		{"a = b", SyntaxError{Pos: 2, Line: 1, Column: 3, Msg: "unexpected rune ' '"}},
+		{"[]", SyntaxError{Pos: 1, Line: 1, Column: 2, Token: "]", Expected: []string{"expression"}}},
+		{"[1 2]", SyntaxError{Pos: 3, Line: 1, Column: 4, Token: "2", Expected: []string{",", "]"}}},
+		{"{1, 2}", SyntaxError{Pos: 2, Line: 1, Column: 3, Token: ",", Expected: []string{":"}}},
+		{"struct{1: 2}", SyntaxError{Pos: 7, Line: 1, Column: 8, Token: "1", Expected: []string{"identifier"}}},
+	}
+
+	for _, test := range tests {
+		program, err := ParseString(test.input)
+		assert.Nil(t, program, test.input)
+		if assert.IsType(t, &SyntaxError{}, err, test.input) {
+			assert.Equal(t, test.err, *err.(*SyntaxError), test.input)
+		}
+	}
+}
+
+func TestSyntaxErrorString(t *testing.T) {
+	_, err := ParseString("(a + b")
+	assert.EqualError(t, err, "line 1, col 7: unexpected EOF, expected operator or )")
+
+	_, err = ParseString("'a")
+	assert.EqualError(t, err, "line 1, col 3: expected '\\'', got EOF")
+}
+
+func TestProgramConstant(t *testing.T) {
+	tests := []struct {
+		input    string
+		constant bool
+		value    interface{}
+	}{
+		{"4 * 8", true, 32},
+		{"1", true, uint64(1)},
+		{"-1", true, int64(-1)},
+		{"$'IHDR'", true, "IHDR"},
+		{`"ab" + "cd"`, true, "abcd"},
+		{"1 < 2 && !false", true, true},
+		{"true ? 16 : A", true, uint64(16)},
+		{"false ? A : 2 * 2", true, 4},
+		{"(A, 3)", false, nil},
+		{"(1, 3)", true, uint64(3)},
+		{"1 / 0", false, nil},
+		{"!1", false, nil},
+		{"A * 2", false, nil},
+		{"f()", false, nil},
+	}
+
+	for _, test := range tests {
+		program, err := ParseString(test.input)
+		if !assert.Nil(t, err, test.input) {
+			continue
+		}
+		value, constant := program.Constant()
+		assert.Equal(t, test.constant, constant, test.input)
+		assert.Equal(t, test.value, value, test.input)
+	}
+}
+
+func TestProgramDeps(t *testing.T) {
+	tests := []struct {
+		input string
+		deps  []string
+	}{
+		{"4 * 8", []string{}},
+		{"A * B + A", []string{"A", "B"}},
+		{"A.B[C]", []string{"A", "C"}},
+		{"f(X, 1)", []string{"f", "X"}},
+		{"true ? A : B", []string{"A"}},
+		{"C ? A : B", []string{"C", "A", "B"}},
+	}
+
+	for _, test := range tests {
+		program, err := ParseString(test.input)
+		if assert.Nil(t, err, test.input) {
+			assert.Equal(t, test.deps, program.Deps(), test.input)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidVarint is returned when decoding a varint fails.
+var ErrInvalidVarint = errors.New("invalid varint")
+
+// StdLib returns packages of functions and constants from the Go standard
+// library: strings, bytes, math, binary and utf8. Constants are untyped, as
+// in Go. A new map is returned on each call, so it may be extended freely.
+func StdLib() map[string]Value {
+	return map[string]Value{
+		"strings": ValueOf(stringsPackage()),
+		"bytes":   ValueOf(bytesPackage()),
+		"math":    ValueOf(mathPackage()),
+		"binary":  ValueOf(binaryPackage()),
+		"utf8":    ValueOf(utf8Package()),
+	}
+}
+
+func stringsPackage() Package {
+	return NewPackage(map[string]Value{
+		"Compare":       ValueOf(strings.Compare),
+		"Contains":      ValueOf(strings.Contains),
+		"ContainsAny":   ValueOf(strings.ContainsAny),
+		"ContainsRune":  ValueOf(strings.ContainsRune),
+		"Count":         ValueOf(strings.Count),
+		"EqualFold":     ValueOf(strings.EqualFold),
+		"HasPrefix":     ValueOf(strings.HasPrefix),
+		"HasSuffix":     ValueOf(strings.HasSuffix),
+		"Index":         ValueOf(strings.Index),
+		"IndexAny":      ValueOf(strings.IndexAny),
+		"IndexByte":     ValueOf(strings.IndexByte),
+		"IndexRune":     ValueOf(strings.IndexRune),
+		"LastIndex":     ValueOf(strings.LastIndex),
+		"LastIndexAny":  ValueOf(strings.LastIndexAny),
+		"LastIndexByte": ValueOf(strings.LastIndexByte),
+		"Repeat":        ValueOf(strings.Repeat),
+		"Replace":       ValueOf(strings.Replace),
+		"ToLower":       ValueOf(strings.ToLower),
+		"ToUpper":       ValueOf(strings.ToUpper),
+		"Trim":          ValueOf(strings.Trim),
+		"TrimLeft":      ValueOf(strings.TrimLeft),
+		"TrimPrefix":    ValueOf(strings.TrimPrefix),
+		"TrimRight":     ValueOf(strings.TrimRight),
+		"TrimSpace":     ValueOf(strings.TrimSpace),
+		"TrimSuffix":    ValueOf(strings.TrimSuffix),
+	})
+}
+
+func bytesPackage() Package {
+	return NewPackage(map[string]Value{
+		"Compare":       ValueOf(bytes.Compare),
+		"Contains":      ValueOf(bytes.Contains),
+		"ContainsAny":   ValueOf(bytes.ContainsAny),
+		"ContainsRune":  ValueOf(bytes.ContainsRune),
+		"Count":         ValueOf(bytes.Count),
+		"Equal":         ValueOf(bytes.Equal),
+		"EqualFold":     ValueOf(bytes.EqualFold),
+		"HasPrefix":     ValueOf(bytes.HasPrefix),
+		"HasSuffix":     ValueOf(bytes.HasSuffix),
+		"Index":         ValueOf(bytes.Index),
+		"IndexAny":      ValueOf(bytes.IndexAny),
+		"IndexByte":     ValueOf(bytes.IndexByte),
+		"IndexRune":     ValueOf(bytes.IndexRune),
+		"LastIndex":     ValueOf(bytes.LastIndex),
+		"LastIndexAny":  ValueOf(bytes.LastIndexAny),
+		"LastIndexByte": ValueOf(bytes.LastIndexByte),
+		"TrimSpace":     ValueOf(bytes.TrimSpace),
+	})
+}
+
+func mathPackage() Package {
+	return NewPackage(map[string]Value{
+		"Abs":             ValueOf(math.Abs),
+		"Cbrt":            ValueOf(math.Cbrt),
+		"Ceil":            ValueOf(math.Ceil),
+		"Copysign":        ValueOf(math.Copysign),
+		"Exp":             ValueOf(math.Exp),
+		"Exp2":            ValueOf(math.Exp2),
+		"Float32bits":     ValueOf(math.Float32bits),
+		"Float32frombits": ValueOf(math.Float32frombits),
+		"Float64bits":     ValueOf(math.Float64bits),
+		"Float64frombits": ValueOf(math.Float64frombits),
+		"Floor":           ValueOf(math.Floor),
+		"Hypot":           ValueOf(math.Hypot),
+		"Inf":             ValueOf(math.Inf),
+		"IsInf":           ValueOf(math.IsInf),
+		"IsNaN":           ValueOf(math.IsNaN),
+		"Log":             ValueOf(math.Log),
+		"Log10":           ValueOf(math.Log10),
+		"Log2":            ValueOf(math.Log2),
+		"Max":             ValueOf(math.Max),
+		"Min":             ValueOf(math.Min),
+		"Mod":             ValueOf(math.Mod),
+		"NaN":             ValueOf(math.NaN),
+		"Pow":             ValueOf(math.Pow),
+		"Round":           ValueOf(math.Round),
+		"Signbit":         ValueOf(math.Signbit),
+		"Sqrt":            ValueOf(math.Sqrt),
+		"Trunc":           ValueOf(math.Trunc),
+
+		"E":     literalfloatval(math.E),
+		"Pi":    literalfloatval(math.Pi),
+		"Ln2":   literalfloatval(math.Ln2),
+		"Sqrt2": literalfloatval(math.Sqrt2),
+
+		"MaxInt8":  literalintval(math.MaxInt8),
+		"MinInt8":  literalintval(math.MinInt8),
+		"MaxInt16": literalintval(math.MaxInt16),
+		"MinInt16": literalintval(math.MinInt16),
+		"MaxInt32": literalintval(math.MaxInt32),
+		"MinInt32": literalintval(math.MinInt32),
+		"MaxInt64": literalintval(math.MaxInt64),
+		"MinInt64": literalintval(math.MinInt64),
+
+		"MaxUint8":  literaluintval(math.MaxUint8),
+		"MaxUint16": literaluintval(math.MaxUint16),
+		"MaxUint32": literaluintval(math.MaxUint32),
+		"MaxUint64": literaluintval(math.MaxUint64),
+
+		"MaxFloat32":             literalfloatval(math.MaxFloat32),
+		"MaxFloat64":             literalfloatval(math.MaxFloat64),
+		"SmallestNonzeroFloat32": literalfloatval(math.SmallestNonzeroFloat32),
+		"SmallestNonzeroFloat64": literalfloatval(math.SmallestNonzeroFloat64),
+	})
+}
+
+// byteOrderPackage returns the functions of a byte order that decode values.
+func byteOrderPackage(order binary.ByteOrder) Package {
+	return NewPackage(map[string]Value{
+		"Uint16": ValueOf(order.Uint16),
+		"Uint32": ValueOf(order.Uint32),
+		"Uint64": ValueOf(order.Uint64),
+	})
+}
+
+func binaryPackage() Package {
+	return NewPackage(map[string]Value{
+		"BigEndian":    ValueOf(byteOrderPackage(binary.BigEndian)),
+		"LittleEndian": ValueOf(byteOrderPackage(binary.LittleEndian)),
+
+		// The varint functions return only the decoded value, and fail if the
+		// buffer does not hold a valid varint.
+		"Uvarint": ValueOf(func(buf []byte) (uint64, error) {
+			v, n := binary.Uvarint(buf)
+			if n <= 0 {
+				return 0, ErrInvalidVarint
+			}
+			return v, nil
+		}),
+		"Varint": ValueOf(func(buf []byte) (int64, error) {
+			v, n := binary.Varint(buf)
+			if n <= 0 {
+				return 0, ErrInvalidVarint
+			}
+			return v, nil
+		}),
+
+		"MaxVarintLen16": literalintval(binary.MaxVarintLen16),
+		"MaxVarintLen32": literalintval(binary.MaxVarintLen32),
+		"MaxVarintLen64": literalintval(binary.MaxVarintLen64),
+	})
+}
+
+func utf8Package() Package {
+	return NewPackage(map[string]Value{
+		"FullRune":          ValueOf(utf8.FullRune),
+		"FullRuneInString":  ValueOf(utf8.FullRuneInString),
+		"RuneCount":         ValueOf(utf8.RuneCount),
+		"RuneCountInString": ValueOf(utf8.RuneCountInString),
+		"RuneLen":           ValueOf(utf8.RuneLen),
+		"Valid":             ValueOf(utf8.Valid),
+		"ValidRune":         ValueOf(utf8.ValidRune),
+		"ValidString":       ValueOf(utf8.ValidString),
+
+		"MaxRune":   literalintval(utf8.MaxRune),
+		"RuneError": literalintval(utf8.RuneError),
+		"RuneSelf":  literalintval(utf8.RuneSelf),
+		"UTFMax":    literalintval(utf8.UTFMax),
+	})
+}
@@ -39,33 +39,37 @@ func _() {
 	_ = x[greaterequaltoken-28]
 	_ = x[leftparentoken-29]
 	_ = x[leftbrackettoken-30]
-	_ = x[commatoken-31]
-	_ = x[periodtoken-32]
-	_ = x[rightparentoken-33]
-	_ = x[rightbrackettoken-34]
-	_ = x[colontoken-35]
-	_ = x[ternarytoken-36]
-	_ = x[boolkeyword-37]
-	_ = x[bytekeyword-38]
-	_ = x[float32keyword-39]
-	_ = x[float64keyword-40]
-	_ = x[intkeyword-41]
-	_ = x[int8keyword-42]
-	_ = x[int16keyword-43]
-	_ = x[int32keyword-44]
-	_ = x[int64keyword-45]
-	_ = x[uintkeyword-46]
-	_ = x[uint8keyword-47]
-	_ = x[uint16keyword-48]
-	_ = x[uint32keyword-49]
-	_ = x[uint64keyword-50]
-	_ = x[uintptrkeyword-51]
-	_ = x[nilkeyword-52]
+	_ = x[leftbracetoken-31]
+	_ = x[commatoken-32]
+	_ = x[periodtoken-33]
+	_ = x[rangetoken-34]
+	_ = x[rightparentoken-35]
+	_ = x[rightbrackettoken-36]
+	_ = x[rightbracetoken-37]
+	_ = x[colontoken-38]
+	_ = x[ternarytoken-39]
+	_ = x[boolkeyword-40]
+	_ = x[bytekeyword-41]
+	_ = x[float32keyword-42]
+	_ = x[float64keyword-43]
+	_ = x[intkeyword-44]
+	_ = x[int8keyword-45]
+	_ = x[int16keyword-46]
+	_ = x[int32keyword-47]
+	_ = x[int64keyword-48]
+	_ = x[uintkeyword-49]
+	_ = x[uint8keyword-50]
+	_ = x[uint16keyword-51]
+	_ = x[uint32keyword-52]
+	_ = x[uint64keyword-53]
+	_ = x[uintptrkeyword-54]
+	_ = x[nilkeyword-55]
+	_ = x[structkeyword-56]
 }
 
-const _tokenkind_name = "niltokenerrtokeneoftokenidenttokeninttokenfloattokenbooltokenstrtokenrunetokenaddtokensubtokenmultokenquotokenremtokenandtokennottokenortokenxortokenshltokenshrtokenandnottokenlogicalandtokenlogicalortokenequaltokenlessertokengreatertokennotequaltokenlesserequaltokengreaterequaltokenleftparentokenleftbrackettokencommatokenperiodtokenrightparentokenrightbrackettokencolontokenternarytokenboolkeywordbytekeywordfloat32keywordfloat64keywordintkeywordint8keywordint16keywordint32keywordint64keyworduintkeyworduint8keyworduint16keyworduint32keyworduint64keyworduintptrkeywordnilkeyword"
+const _tokenkind_name = "niltokenerrtokeneoftokenidenttokeninttokenfloattokenbooltokenstrtokenrunetokenaddtokensubtokenmultokenquotokenremtokenandtokennottokenortokenxortokenshltokenshrtokenandnottokenlogicalandtokenlogicalortokenequaltokenlessertokengreatertokennotequaltokenlesserequaltokengreaterequaltokenleftparentokenleftbrackettokenleftbracetokencommatokenperiodtokenrangetokenrightparentokenrightbrackettokenrightbracetokencolontokenternarytokenboolkeywordbytekeywordfloat32keywordfloat64keywordintkeywordint8keywordint16keywordint32keywordint64keyworduintkeyworduint8keyworduint16keyworduint32keyworduint64keyworduintptrkeywordnilkeywordstructkeyword"
 
-var _tokenkind_index = [...]uint16{0, 8, 16, 24, 34, 42, 52, 61, 69, 78, 86, 94, 102, 110, 118, 126, 134, 141, 149, 157, 165, 176, 191, 205, 215, 226, 238, 251, 267, 284, 298, 314, 324, 335, 350, 367, 377, 389, 400, 411, 425, 439, 449, 460, 472, 484, 496, 507, 519, 532, 545, 558, 572, 582}
+var _tokenkind_index = [...]uint16{0, 8, 16, 24, 34, 42, 52, 61, 69, 78, 86, 94, 102, 110, 118, 126, 134, 141, 149, 157, 165, 176, 191, 205, 215, 226, 238, 251, 267, 284, 298, 314, 328, 338, 349, 359, 374, 391, 406, 416, 428, 439, 450, 464, 478, 488, 499, 511, 523, 535, 546, 558, 571, 584, 597, 611, 621, 634}
 
 func (i tokenkind) String() string {
 	if i < 0 || i >= tokenkind(len(_tokenkind_index)-1) {
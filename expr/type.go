@@ -287,7 +287,7 @@ type MapType struct {
 
 // NewMapType returns a new map type.
 func NewMapType(key Type, val Type) Type {
-	return MapType{key: key, val: val}
+	return &MapType{key: key, val: val}
 }
 
 // String implements Type.
@@ -387,6 +387,10 @@ type FuncType struct {
 	in       []Type
 	out      []Type
 	variadic bool
+
+	// err is true for functions that return an error after their results.
+	// A non-nil error fails the evaluation.
+	err bool
 }
 
 // NewFuncType returns a new function type.
@@ -429,6 +433,12 @@ func (t FuncType) Out(i int) Type {
 	return t.out[i]
 }
 
+// ReturnsError returns true if the function returns an error after its
+// output parameters.
+func (t FuncType) ReturnsError() bool {
+	return t.err
+}
+
 // TypeEqual returns true if the two types are equal.
 func TypeEqual(a, b Type) bool {
 	// TODO: this could be a bit more precise.
@@ -469,12 +479,17 @@ func toreflecttype(t Type) reflect.Type {
 		for i := 0; i < nout; i++ {
 			out = append(out, toreflecttype(t.Out(i)))
 		}
+		if t.err {
+			out = append(out, errorType)
+		}
 		return reflect.FuncOf(in, out, t.IsVariadic())
 	default:
 		panic(ErrNotRepresentable)
 	}
 }
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 var typemap = map[reflect.Type]Type{}
 var typemutex = sync.Mutex{}
 
@@ -517,11 +532,17 @@ func fromreflecttype(t reflect.Type) Type {
 			in = append(in, fromreflecttype(t.In(i)))
 		}
 		nout := t.NumOut()
+		err := nout > 1 && t.Out(nout-1) == errorType
+		if err {
+			nout--
+		}
 		out := make([]Type, 0, nout)
 		for i := 0; i < nout; i++ {
 			out = append(out, fromreflecttype(t.Out(i)))
 		}
-		return NewFuncType(in, out, t.IsVariadic())
+		ft := NewFuncType(in, out, t.IsVariadic())
+		ft.err = err
+		return ft
 	case reflect.Map:
 		return NewMapType(fromreflecttype(t.Key()), fromreflecttype(t.Elem()))
 	case reflect.Ptr:
@@ -593,3 +614,9 @@ func TypeOf(i interface{}) Type {
 
 	return fromreflecttype(reflect.TypeOf(i))
 }
+
+// FromReflectType returns the type for a runtime type. It panics with
+// ErrNotRepresentable if the type cannot be represented.
+func FromReflectType(t reflect.Type) Type {
+	return fromreflecttype(t)
+}
@@ -67,6 +67,7 @@ type Value interface {
 	And(rhs Value) Value
 	AndNot(rhs Value) Value
 	Index(rhs Value) Value
+	Slice(lo, hi Value) Value
 	Call(in []Value) Value
 }
 
@@ -92,9 +93,9 @@ func promote(from Value, to Type) Value {
 	case UntypedFloat:
 		switch to.Kind() {
 		case Float32:
-			return val{reflect.ValueOf(float32(reflect.ValueOf(from.Value).Float())), to}
+			return val{reflect.ValueOf(float32(from.Value().Float())), to}
 		case Float64:
-			return val{reflect.ValueOf(float64(reflect.ValueOf(from.Value).Float())), to}
+			return val{reflect.ValueOf(float64(from.Value().Float())), to}
 		default:
 			panic(ConversionError{From: ftype, To: to})
 		}
@@ -312,12 +313,58 @@ func (v val) LogicalAnd(rhs Value) Value {
 	return val{reflect.ValueOf(lv && rv), NewPrimitiveType(Bool)}
 }
 
+// isBytes returns true for byte arrays and slices.
+func isBytes(t Type) bool {
+	switch t := t.(type) {
+	case *ArrayType:
+		return t.Elem().Kind() == Uint8
+	case *SliceType:
+		return t.Elem().Kind() == Uint8
+	}
+	return false
+}
+
+// bytesOrString returns the contents of a string, byte array or byte slice.
+func bytesOrString(v Value) (string, bool) {
+	t := v.Type()
+	switch {
+	case t.Kind() == String:
+		return v.Value().String(), true
+	case isBytes(t):
+		rv := v.Value()
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return string(b), true
+	}
+	return "", false
+}
+
+// bytesEqual compares byte arrays and slices with each other or with strings
+// by their contents. It returns false for ok if either value is not one.
+func bytesEqual(lhs Value, rhs Value) (eq bool, ok bool) {
+	if !isBytes(lhs.Type()) && !isBytes(rhs.Type()) {
+		return false, false
+	}
+	l, lok := bytesOrString(lhs)
+	r, rok := bytesOrString(rhs)
+	if !lok || !rok {
+		return false, false
+	}
+	return l == r, true
+}
+
 func (v val) Equal(rhs Value) Value {
+	if eq, ok := bytesEqual(v, rhs); ok {
+		return val{reflect.ValueOf(eq), NewPrimitiveType(Bool)}
+	}
 	l, r := coerce(v, rhs)
 	return val{reflect.ValueOf(l.RawValue() == r.RawValue()), NewPrimitiveType(Bool)}
 }
 
 func (v val) NotEqual(rhs Value) Value {
+	if eq, ok := bytesEqual(v, rhs); ok {
+		return val{reflect.ValueOf(!eq), NewPrimitiveType(Bool)}
+	}
 	l, r := coerce(v, rhs)
 	return val{reflect.ValueOf(l.RawValue() != r.RawValue()), NewPrimitiveType(Bool)}
 }
@@ -351,6 +398,8 @@ func (v val) Lesser(rhs Value) Value {
 		return val{reflect.ValueOf(lv < r.RawValue().(float32)), NewPrimitiveType(Bool)}
 	case float64:
 		return val{reflect.ValueOf(lv < r.RawValue().(float64)), NewPrimitiveType(Bool)}
+	case string:
+		return val{reflect.ValueOf(lv < r.RawValue().(string)), NewPrimitiveType(Bool)}
 	default:
 		panic(InvalidOpError{Op: "<", V: l})
 	}
@@ -385,6 +434,8 @@ func (v val) LesserEqual(rhs Value) Value {
 		return val{reflect.ValueOf(lv <= r.RawValue().(float32)), NewPrimitiveType(Bool)}
 	case float64:
 		return val{reflect.ValueOf(lv <= r.RawValue().(float64)), NewPrimitiveType(Bool)}
+	case string:
+		return val{reflect.ValueOf(lv <= r.RawValue().(string)), NewPrimitiveType(Bool)}
 	default:
 		panic(InvalidOpError{Op: "<=", V: l})
 	}
@@ -419,6 +470,8 @@ func (v val) Greater(rhs Value) Value {
 		return val{reflect.ValueOf(lv > r.RawValue().(float32)), NewPrimitiveType(Bool)}
 	case float64:
 		return val{reflect.ValueOf(lv > r.RawValue().(float64)), NewPrimitiveType(Bool)}
+	case string:
+		return val{reflect.ValueOf(lv > r.RawValue().(string)), NewPrimitiveType(Bool)}
 	default:
 		panic(InvalidOpError{Op: ">", V: l})
 	}
@@ -453,6 +506,8 @@ func (v val) GreaterEqual(rhs Value) Value {
 		return val{reflect.ValueOf(lv >= r.RawValue().(float32)), NewPrimitiveType(Bool)}
 	case float64:
 		return val{reflect.ValueOf(lv >= r.RawValue().(float64)), NewPrimitiveType(Bool)}
+	case string:
+		return val{reflect.ValueOf(lv >= r.RawValue().(string)), NewPrimitiveType(Bool)}
 	default:
 		panic(InvalidOpError{Op: ">=", V: l})
 	}
@@ -846,21 +901,134 @@ func (v val) AndNot(rhs Value) Value {
 
 func (v val) Index(rhs Value) Value {
 	if v.t.Kind() == String {
-		index := promote(rhs, NewPrimitiveType(Int)).RawValue().(int)
-		return val{reflect.ValueOf(v.RawValue().(string)[index]), NewPrimitiveType(Uint8)}
+		s := v.RawValue().(string)
+		i := checkindex(rhs, len(s))
+		return val{reflect.ValueOf(s[i]), NewPrimitiveType(Uint8)}
 	}
 	switch t := v.t.(type) {
 	case *ArrayType:
-		return val{v.v.Index(promote(rhs, NewPrimitiveType(Int)).RawValue().(int)), t.Elem()}
+		return val{v.v.Index(checkindex(rhs, v.v.Len())), t.Elem()}
 	case *SliceType:
-		return val{v.v.Index(promote(rhs, NewPrimitiveType(Int)).RawValue().(int)), t.Elem()}
+		return val{v.v.Index(checkindex(rhs, v.v.Len())), t.Elem()}
 	case *MapType:
-		return val{v.v.MapIndex(promote(rhs, t.Key()).Value()), t.Value()}
+		// Missing keys yield the zero value, as in Go.
+		var e reflect.Value
+		if k, ok := mapkey(rhs, t.Key()); ok {
+			e = v.v.MapIndex(k)
+		}
+		if !e.IsValid() {
+			e = reflect.Zero(v.v.Type().Elem())
+		}
+		return val{e, t.Value()}
 	default:
 		panic(InvalidOpError{Op: "[]", V: v})
 	}
 }
 
+// mapkey converts a value to the key type of a map. Integers of other types
+// are converted if their value is preserved, so that tables written as map
+// literals may be indexed by fields of any integer type; otherwise ok is
+// false, as no key can match.
+func mapkey(v Value, kt Type) (k reflect.Value, ok bool) {
+	vt := v.Type()
+	if !isInteger(vt) || !isInteger(kt) || vt.Kind() == UntypedInt || TypeEqual(vt, kt) {
+		return promote(v, kt).Value(), true
+	}
+	rv := v.Value()
+	k = rv.Convert(toreflecttype(kt))
+	if k.Convert(rv.Type()).Interface() != rv.Interface() || isnegative(k) != isnegative(rv) {
+		return reflect.Value{}, false
+	}
+	return k, true
+}
+
+// isnegative returns true if an integer value is negative.
+func isnegative(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() < 0
+	}
+	return false
+}
+
+// IndexError is returned when an index is out of range.
+type IndexError struct {
+	Index, Len int
+}
+
+func (e IndexError) Error() string {
+	return fmt.Sprintf("index out of range [%d] with length %d", e.Index, e.Len)
+}
+
+// checkindex returns the value of an integer index into a value of length n.
+func checkindex(v Value, n int) int {
+	i := intindex(v)
+	if i < 0 || i >= n {
+		panic(IndexError{Index: i, Len: n})
+	}
+	return i
+}
+
+// SliceBoundsError is returned when the bounds of a slice expression are out
+// of range.
+type SliceBoundsError struct {
+	Lo, Hi, Len int
+}
+
+func (e SliceBoundsError) Error() string {
+	return fmt.Sprintf("slice bounds out of range [%d:%d] with length %d", e.Lo, e.Hi, e.Len)
+}
+
+// intindex returns the value of an integer index.
+func intindex(v Value) int {
+	rv := v.Value()
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(rv.Uint())
+	}
+	panic(InvalidOpError{Op: "[:]", V: v})
+}
+
+// Slice returns the elements from lo up to hi. Either bound may be nil, for
+// the start or end of the value. Slicing an array returns a slice.
+func (v val) Slice(lo, hi Value) Value {
+	rv := v.v
+	switch v.t.(type) {
+	case *ArrayType:
+		// Arrays in values are not addressable, so slice a copy.
+		arr := reflect.New(rv.Type()).Elem()
+		arr.Set(rv)
+		rv = arr
+	case *SliceType:
+	default:
+		if v.t.Kind() != String {
+			panic(InvalidOpError{Op: "[:]", V: v})
+		}
+	}
+
+	l, h, max := 0, rv.Len(), rv.Len()
+	if v.t.Kind() == Slice {
+		max = rv.Cap()
+	}
+	if lo != nil {
+		l = intindex(lo)
+	}
+	if hi != nil {
+		h = intindex(hi)
+	}
+	if l < 0 || h < l || h > max {
+		panic(SliceBoundsError{Lo: l, Hi: h, Len: rv.Len()})
+	}
+
+	sv := rv.Slice(l, h)
+	if v.t.Kind() == String {
+		return val{sv, v.t}
+	}
+	return val{sv, fromreflecttype(sv.Type())}
+}
+
 func (v val) Call(in []Value) Value {
 	ft, ok := v.t.(*FuncType)
 	if !ok {
@@ -874,6 +1042,12 @@ func (v val) Call(in []Value) Value {
 		inconv = append(inconv, promote(n, ft.In(i)).Value())
 	}
 	out := v.v.Call(inconv)
+	if ft.err {
+		if err := out[len(out)-1]; !err.IsNil() {
+			panic(err.Interface().(error))
+		}
+		out = out[:len(out)-1]
+	}
 	if len(out) != 1 {
 		panic("only functions returning 1 value are supported")
 	}
@@ -0,0 +1,515 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// FieldResolver is an optional interface for resolvers that resolve
+// identifiers to the fields of a struct. Programs bind such identifiers to
+// field indices once per struct type, rather than looking them up by name on
+// every evaluation. Identifiers are resolved in order as builtins, if they
+// begin with an underscore, then as fields, then as globals.
+type FieldResolver interface {
+	Resolver
+
+	// ResolveBuiltin resolves identifiers beginning with an underscore,
+	// which take precedence over fields. It returns nil for identifiers that
+	// should be looked up as fields.
+	ResolveBuiltin(ident string) Value
+
+	// Globals returns the resolver for identifiers that are neither builtins
+	// nor fields, or nil if there is none. Programs cache the values it
+	// resolves by resolver, so it must be comparable, and must always resolve
+	// an identifier to the same value once it has resolved it.
+	Globals() Resolver
+
+	// Struct returns the struct whose fields are resolved. It returns an
+	// invalid value if there is none.
+	Struct() reflect.Value
+
+	// FieldConverter returns the function that converts the values of the
+	// field of a struct type at the given index, as for
+	// reflect.Type.FieldByIndex, or nil to convert them with ValueOf. It is
+	// called once when a program is bound to the struct type, and must only
+	// depend on the struct type and index.
+	FieldConverter(typ reflect.Type, index []int) func(reflect.Value) Value
+}
+
+// slot is a value on the stack of the machine. Bools and integers of
+// predeclared types are unboxed into bits, with kind giving their type;
+// integers are sign extended. Other values are kept in v, with kind Invalid.
+type slot struct {
+	kind Kind
+	bits uint64
+
+	// signed is true for untyped integers that are represented as int64
+	// values when boxed.
+	signed bool
+
+	v Value
+}
+
+// slottypes holds the types of unboxed slots.
+var slottypes = map[Kind]Type{
+	Bool:        NewPrimitiveType(Bool),
+	Int:         NewPrimitiveType(Int),
+	Int8:        NewPrimitiveType(Int8),
+	Int16:       NewPrimitiveType(Int16),
+	Int32:       NewPrimitiveType(Int32),
+	Int64:       NewPrimitiveType(Int64),
+	Uint:        NewPrimitiveType(Uint),
+	Uint8:       NewPrimitiveType(Uint8),
+	Uint16:      NewPrimitiveType(Uint16),
+	Uint32:      NewPrimitiveType(Uint32),
+	Uint64:      NewPrimitiveType(Uint64),
+	UntypedBool: NewLiteralType(UntypedBool),
+	UntypedInt:  NewLiteralType(UntypedInt),
+}
+
+func boolslot(k Kind, b bool) slot {
+	if b {
+		return slot{kind: k, bits: 1}
+	}
+	return slot{kind: k}
+}
+
+func isintslot(k Kind) bool {
+	switch k {
+	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, UntypedInt:
+		return true
+	}
+	return false
+}
+
+func isboolslot(k Kind) bool {
+	return k == Bool || k == UntypedBool
+}
+
+func issignedslot(k Kind) bool {
+	switch k {
+	case Int, Int8, Int16, Int32, Int64:
+		return true
+	}
+	return false
+}
+
+// truncate converts integer bits to the range of kind k, as a Go conversion
+// would.
+func truncate(k Kind, x uint64) uint64 {
+	switch k {
+	case Int:
+		return uint64(int64(int(x)))
+	case Int8:
+		return uint64(int64(int8(x)))
+	case Int16:
+		return uint64(int64(int16(x)))
+	case Int32:
+		return uint64(int64(int32(x)))
+	case Uint:
+		return uint64(uint(x))
+	case Uint8:
+		return uint64(uint8(x))
+	case Uint16:
+		return uint64(uint16(x))
+	case Uint32:
+		return uint64(uint32(x))
+	}
+	return x
+}
+
+// unbox converts a value to a slot, unboxing it if possible.
+func unbox(v Value) slot {
+	k := v.Type().Kind()
+	switch k {
+	case Bool, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64:
+		rv := v.Value()
+		if !rv.IsValid() || rv.Type() != primRType[k] {
+			break
+		}
+		switch k {
+		case Bool:
+			return boolslot(k, rv.Bool())
+		case Int, Int8, Int16, Int32, Int64:
+			return slot{kind: k, bits: uint64(rv.Int())}
+		default:
+			return slot{kind: k, bits: rv.Uint()}
+		}
+	case UntypedBool:
+		if b, ok := v.RawValue().(bool); ok {
+			return boolslot(k, b)
+		}
+	case UntypedInt:
+		switch n := v.RawValue().(type) {
+		case int64:
+			return slot{kind: k, bits: uint64(n), signed: true}
+		case uint64:
+			return slot{kind: k, bits: n}
+		}
+	}
+	return slot{v: v}
+}
+
+// box converts a slot to a value.
+func (s slot) box() Value {
+	var i interface{}
+	switch s.kind {
+	case Invalid:
+		return s.v
+	case Bool, UntypedBool:
+		i = s.bits != 0
+	case Int:
+		i = int(s.bits)
+	case Int8:
+		i = int8(s.bits)
+	case Int16:
+		i = int16(s.bits)
+	case Int32:
+		i = int32(s.bits)
+	case Int64:
+		i = int64(s.bits)
+	case Uint:
+		i = uint(s.bits)
+	case Uint8:
+		i = uint8(s.bits)
+	case Uint16:
+		i = uint16(s.bits)
+	case Uint32:
+		i = uint32(s.bits)
+	case Uint64:
+		i = s.bits
+	case UntypedInt:
+		if s.signed {
+			i = int64(s.bits)
+		} else {
+			i = s.bits
+		}
+	}
+	return val{reflect.ValueOf(i), slottypes[s.kind]}
+}
+
+// fieldslot reads a field of a predeclared bool or integer type into a slot.
+func fieldslot(v reflect.Value) (slot, bool) {
+	k := primType[v.Kind()]
+	if k == nil || v.Type() != primRType[k.Kind()] {
+		return slot{}, false
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return boolslot(Bool, v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return slot{kind: k.Kind(), bits: uint64(v.Int())}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return slot{kind: k.Kind(), bits: v.Uint()}, true
+	}
+	return slot{}, false
+}
+
+// run executes the code, and returns the result.
+func (c *code) run(resolver Resolver) Value {
+	if c == nil || len(c.instrs) == 0 {
+		panic("invalid node")
+	}
+
+	var buf [16]slot
+	stack := buf[:0]
+	if c.depth > len(buf) {
+		stack = make([]slot, 0, c.depth)
+	}
+
+	fr, _ := resolver.(FieldResolver)
+	var fields []binding
+	var g Resolver
+	var globals []Value
+	var st reflect.Value
+
+	load := func(name int) slot {
+		ident := c.names[name]
+		if fr == nil {
+			if v := resolver.Resolve(ident); v != nil {
+				return unbox(v)
+			}
+			if v := Builtin(ident); v != nil {
+				return unbox(v)
+			}
+			panic(fmt.Errorf("unresolved name %s", ident))
+		}
+		if ident[0] == '_' {
+			if v := fr.ResolveBuiltin(ident); v != nil {
+				return unbox(v)
+			}
+		}
+		if fields == nil {
+			if st = fr.Struct(); st.IsValid() {
+				fields = c.bind(fr, st.Type())
+			}
+		}
+		if fields != nil && fields[name].index != nil {
+			b := fields[name]
+			fv := st.FieldByIndex(b.index)
+			if b.conv != nil {
+				return unbox(b.conv(fv))
+			}
+			if s, ok := fieldslot(fv); ok {
+				return s
+			}
+			return unbox(ValueOf(fv.Interface()))
+		}
+		if globals == nil {
+			if g = fr.Globals(); g != nil {
+				globals = c.globals(g)
+			}
+		}
+		if globals != nil {
+			if v := globals[name]; v != nil {
+				return unbox(v)
+			}
+			// The name may have been defined since the values were cached.
+			if v := g.Resolve(ident); v != nil {
+				return unbox(v)
+			}
+		}
+		if v := Builtin(ident); v != nil {
+			return unbox(v)
+		}
+		panic(fmt.Errorf("unresolved name %s", ident))
+	}
+
+	for pc := 0; pc < len(c.instrs); pc++ {
+		in := c.instrs[pc]
+		switch in.op {
+		case opconst:
+			stack = append(stack, c.consts[in.arg])
+		case opload:
+			stack = append(stack, load(in.arg))
+		case opunary:
+			top := len(stack) - 1
+			stack[top] = unaryslot(unaryop(in.arg), stack[top])
+		case opbinary:
+			top := len(stack) - 1
+			stack[top-1] = binaryslot(binaryop(in.arg), stack[top-1], stack[top])
+			stack = stack[:top]
+		case opmember:
+			top := len(stack) - 1
+			stack[top] = unbox(stack[top].box().Dot(c.names[in.arg]))
+		case opcall:
+			base := len(stack) - in.arg - 1
+			args := make([]Value, in.arg)
+			for i := range args {
+				args[i] = stack[base+1+i].box()
+			}
+			stack[base] = unbox(stack[base].box().Call(args))
+			stack = stack[:base+1]
+		case oppop:
+			stack = stack[:len(stack)-1]
+		case opjump:
+			pc = in.arg - 1
+		case opjumpfalse:
+			top := len(stack) - 1
+			cond := stack[top]
+			stack = stack[:top]
+			if !condition(cond) {
+				pc = in.arg - 1
+			}
+		case opslice:
+			var lo, hi Value
+			if in.arg&slicehi != 0 {
+				hi = stack[len(stack)-1].box()
+				stack = stack[:len(stack)-1]
+			}
+			if in.arg&slicelo != 0 {
+				lo = stack[len(stack)-1].box()
+				stack = stack[:len(stack)-1]
+			}
+			top := len(stack) - 1
+			stack[top] = unbox(stack[top].box().Slice(lo, hi))
+		case opliteral:
+			lit := c.literals[in.arg]
+			base := len(stack) - lit.n
+			v := lit.make(boxslots(stack[base:]))
+			stack = append(stack[:base], unbox(v))
+		case oprange:
+			panic(ErrMisplacedRange)
+		}
+	}
+
+	return stack[0].box()
+}
+
+// condition returns the value of the condition of a ternary expression.
+func condition(s slot) bool {
+	if isboolslot(s.kind) {
+		return s.bits != 0
+	}
+	a := s.box().Value().Interface()
+	cond, ok := a.(bool)
+	if !ok {
+		panic(fmt.Errorf("unexpected type %T for ternary", a))
+	}
+	return cond
+}
+
+// unaryslot applies a unary operator to a slot. Operations that cannot be
+// done on unboxed values are done on boxed values.
+func unaryslot(op unaryop, s slot) slot {
+	switch {
+	case op == unaryplus:
+		return s
+	case op == unarynegate && isintslot(s.kind):
+		s.bits = truncate(s.kind, -s.bits)
+		return s
+	case op == unarybitnot && isintslot(s.kind):
+		s.bits = truncate(s.kind, ^s.bits)
+		return s
+	case op == unarynot && isboolslot(s.kind):
+		s.bits ^= 1
+		return s
+	}
+	return unbox(applyunary(op, s.box()))
+}
+
+// binaryslot applies a binary operator to two slots. Operations that cannot
+// be done on unboxed values are done on boxed values.
+func binaryslot(op binaryop, a, b slot) slot {
+	if a.kind != Invalid && b.kind != Invalid {
+		if r, ok := binaryfast(op, a, b); ok {
+			return r
+		}
+	}
+	return unbox(applybinary(op, a.box(), b.box()))
+}
+
+// commonkind returns the kind that two unboxed operands are converted to, as
+// by coerce.
+func commonkind(a, b Kind) (Kind, bool) {
+	switch {
+	case a == b && a == UntypedInt:
+		return Int, true
+	case a == b:
+		return a, true
+	case a == UntypedInt && isintslot(b), a == UntypedBool && b == Bool:
+		return b, true
+	case b == UntypedInt && isintslot(a), b == UntypedBool && a == Bool:
+		return a, true
+	}
+	return Invalid, false
+}
+
+func binaryfast(op binaryop, a, b slot) (slot, bool) {
+	switch op {
+	case binarylogicalor:
+		if isboolslot(a.kind) && isboolslot(b.kind) {
+			return boolslot(Bool, a.bits|b.bits != 0), true
+		}
+		return slot{}, false
+	case binarylogicaland:
+		if isboolslot(a.kind) && isboolslot(b.kind) {
+			return boolslot(Bool, a.bits&b.bits != 0), true
+		}
+		return slot{}, false
+	case binarylsh, binaryrsh:
+		return shiftfast(op, a, b)
+	}
+
+	k, ok := commonkind(a.kind, b.kind)
+	if !ok {
+		return slot{}, false
+	}
+	x, y := truncate(k, a.bits), truncate(k, b.bits)
+
+	switch op {
+	case binaryequal:
+		return boolslot(Bool, x == y), true
+	case binarynotequal:
+		return boolslot(Bool, x != y), true
+	}
+	if !isintslot(k) {
+		return slot{}, false
+	}
+
+	signed := issignedslot(k)
+	switch op {
+	case binarylesser:
+		if signed {
+			return boolslot(Bool, int64(x) < int64(y)), true
+		}
+		return boolslot(Bool, x < y), true
+	case binarylesserequal:
+		if signed {
+			return boolslot(Bool, int64(x) <= int64(y)), true
+		}
+		return boolslot(Bool, x <= y), true
+	case binarygreater:
+		if signed {
+			return boolslot(Bool, int64(x) > int64(y)), true
+		}
+		return boolslot(Bool, x > y), true
+	case binarygreaterequal:
+		if signed {
+			return boolslot(Bool, int64(x) >= int64(y)), true
+		}
+		return boolslot(Bool, x >= y), true
+	}
+
+	var r uint64
+	switch op {
+	case binaryadd:
+		r = x + y
+	case binarysub:
+		r = x - y
+	case binarymul:
+		r = x * y
+	case binarydiv:
+		if signed {
+			r = uint64(int64(x) / int64(y))
+		} else {
+			r = x / y
+		}
+	case binaryrem:
+		if signed {
+			r = uint64(int64(x) % int64(y))
+		} else {
+			r = x % y
+		}
+	case binaryor:
+		r = x | y
+	case binaryxor:
+		r = x ^ y
+	case binaryand:
+		r = x & y
+	case binaryandnot:
+		r = x &^ y
+	default:
+		return slot{}, false
+	}
+	return slot{kind: k, bits: truncate(k, r)}, true
+}
+
+func shiftfast(op binaryop, a, b slot) (slot, bool) {
+	k := a.kind
+	if k == UntypedInt {
+		k = Int
+	}
+	if !isintslot(k) {
+		return slot{}, false
+	}
+	if issignedslot(b.kind) || !isintslot(b.kind) || b.kind == UntypedInt && b.signed {
+		return slot{}, false
+	}
+
+	x, n := truncate(k, a.bits), b.bits
+	var r uint64
+	switch {
+	case op == binarylsh && n < 64:
+		r = x << n
+	case op == binarylsh:
+		r = 0
+	case issignedslot(k) && n < 64:
+		r = uint64(int64(x) >> n)
+	case issignedslot(k):
+		r = uint64(int64(x) >> 63)
+	case n < 64:
+		r = x >> n
+	}
+	return slot{kind: k, bits: truncate(k, r)}, true
+}
@@ -0,0 +1,328 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestVMStruct struct {
+	I   int
+	I8  int8
+	I16 int16
+	I32 int32
+	I64 int64
+	U   uint
+	U8  uint8
+	U16 uint16
+	U32 uint32
+	U64 uint64
+	F   float64
+	B   bool
+	S   string
+	N   TestNamedInt
+	P   *TestVMStruct
+}
+
+type TestNamedInt uint8
+
+// evaltree evaluates a syntax tree directly using the operations on Value, as
+// a reference for the machine.
+func evaltree(resolver Resolver, n node) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if rerr, ok := r.(error); ok {
+				err = rerr
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	var eval func(n node) Value
+	eval = func(n node) Value {
+		switch n := n.(type) {
+		case identnode:
+			v := resolver.Resolve(n.ident)
+			if v == nil {
+				panic(fmt.Errorf("unresolved name %s", n.ident))
+			}
+			return v
+		case intnode:
+			if n.sign {
+				return literalintval(n.ival)
+			}
+			return literaluintval(n.uval)
+		case floatnode:
+			return literalfloatval(n.fval)
+		case boolnode:
+			return literalboolval(n.val)
+		case strnode:
+			return literalstrval(n.val)
+		case runenode:
+			return literalintval(int64(n.val))
+		case unaryexpr:
+			return applyunary(n.op, eval(n.n))
+		case binaryexpr:
+			a := eval(n.a)
+			switch n.op {
+			case binarymember:
+				return a.Dot(n.b.(identnode).ident)
+			case binarygroup:
+				return eval(n.b)
+			case binarycall:
+				in := []Value{}
+				for _, arg := range flattengroup(n.b) {
+					in = append(in, eval(arg))
+				}
+				return a.Call(in)
+			}
+			return applybinary(n.op, a, eval(n.b))
+		case ternaryexpr:
+			if eval(n.a).RawValue().(bool) {
+				return eval(n.b)
+			}
+			return eval(n.c)
+		case sliceexpr:
+			var lo, hi Value
+			if n.lo != nil {
+				lo = eval(n.lo)
+			}
+			if n.hi != nil {
+				hi = eval(n.hi)
+			}
+			return eval(n.a).Slice(lo, hi)
+		case arraylit:
+			return makearray(evalall(eval, n.elems))
+		case maplit:
+			elems := []node{}
+			for i := range n.keys {
+				elems = append(elems, n.keys[i], n.vals[i])
+			}
+			return makemap(evalall(eval, elems))
+		case structlit:
+			return structmaker(n.names)(evalall(eval, n.vals))
+		}
+		panic("invalid node")
+	}
+
+	return eval(n).RawValue(), nil
+}
+
+func evalall(eval func(n node) Value, nodes []node) []Value {
+	vals := make([]Value, len(nodes))
+	for i, n := range nodes {
+		vals[i] = eval(n)
+	}
+	return vals
+}
+
+func TestVMMatchesTree(t *testing.T) {
+	s := TestVMStruct{
+		I: -7, I8: -128, I16: 300, I32: -5, I64: 1 << 40,
+		U: 7, U8: 250, U16: 65535, U32: 1 << 31, U64: 1 << 63,
+		F: 2.5, B: true, S: "abc", N: 3,
+	}
+	s.P = &TestVMStruct{I: 42}
+
+	exprs := []string{
+		"I", "I8", "U8", "B", "S", "N", "F",
+		"1", "-1", "'a'", "true", "1.5", `"x"`,
+		"I + 1", "I - 10", "I * I", "I / 2", "I % 4", "I | 8", "I ^ 3", "I & 6",
+		"I8 - 1", "I8 * -1", "I8 / -1", "U8 + 10", "U8 * 2", "U16 + 1", "U32 << 1",
+		"U64 >> 63", "I64 >> 41", "I8 >> 10", "I8 << 1", "U8 << 100", "I << 2",
+		"1 << 3", "-1 >> 1", "I << U8", "U8 >> U8",
+		"I < 0", "I8 <= -128", "U8 > 200", "U64 >= 1", "I32 < -1",
+		"I == -7", "U8 != 250", "1 == 1", "-1 == 18446744073709551615",
+		"B && true", "B || false", "!B", "!true", "true && false",
+		"-I", "-U8", "^U8", "^I8", "+I", "-(1)", "^0",
+		"B ? I : 0", "!B ? 1 : 2", "I > 0 ? \"pos\" : \"neg\"",
+		"(I, U8)", "F * 2", "F + 1", "F > 2", "S + \"d\"", "S == \"abc\"", "S[1]",
+		"P.I", "P.I + 1", "N + 1", "N == 3",
+		"S[1:]", "S[:U8 - 248]", "S[I+8:2] == \"b\"", "S[2:1]", "S[:4]",
+		"[1, 2, I][2]", "[U8, 1][1]", "{1: 4, 2: 8}[U8 - 249]", "{1: 4}[I]", "{\"a\": S}[S]",
+		"struct{A: I, B: S}.B", "[1, 2][I]", "[I, U8]", "{1: 2, I: 3}",
+		"U8 == I", "I == true", "!I", "-B", "I << I", "I / 0", "Missing", "U8 + 1.5",
+	}
+
+	for _, e := range exprs {
+		resolver := NewStructResolver(reflect.ValueOf(s))
+		program, err := ParseString(e)
+		if !assert.Nil(t, err, e) {
+			continue
+		}
+		expected, experr := evaltree(resolver, program.root)
+		actual, err := EvalProgram(resolver, program)
+		if experr != nil {
+			assert.EqualError(t, err, experr.Error(), e)
+			continue
+		}
+		if assert.Nil(t, err, e) {
+			assert.Equal(t, expected, actual, e)
+		}
+	}
+}
+
+func TestVMFunctionCall(t *testing.T) {
+	resolver := NewMapResolver(map[string]Value{
+		"double": ValueOf(func(x int) int { return x * 2 }),
+		"zero":   ValueOf(func() int { return 0 }),
+		"x":      ValueOf(21),
+	})
+
+	v, err := Eval(resolver, "double(x) + zero()")
+	assert.Nil(t, err)
+	assert.Equal(t, 42, v)
+}
+
+func TestVMBindsFieldsPerType(t *testing.T) {
+	type A struct{ X, Y int }
+	type B struct{ Y, X int }
+
+	program, err := ParseString("X - Y")
+	assert.Nil(t, err)
+
+	v, err := EvalProgram(NewStructResolver(reflect.ValueOf(A{X: 5, Y: 2})), program)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, v)
+
+	v, err = EvalProgram(NewStructResolver(reflect.ValueOf(B{X: 5, Y: 2})), program)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, v)
+
+	assert.Len(t, program.code.fields, 2)
+}
+
+// countingResolver is a FieldResolver that counts its lookups.
+type countingResolver struct {
+	*StructResolver
+	builtins []string
+	globals  *countingGlobals
+}
+
+func (r *countingResolver) ResolveBuiltin(ident string) Value {
+	r.builtins = append(r.builtins, ident)
+	if ident == "_two" {
+		return ValueOf(2)
+	}
+	return nil
+}
+
+func (r *countingResolver) Globals() Resolver {
+	return r.globals
+}
+
+type countingGlobals struct {
+	lookups int
+}
+
+func (g *countingGlobals) Resolve(ident string) Value {
+	g.lookups++
+	switch ident {
+	case "X":
+		return ValueOf(100)
+	case "ten":
+		return ValueOf(10)
+	}
+	return nil
+}
+
+func TestVMResolveOrder(t *testing.T) {
+	type A struct{ X, Y int }
+
+	program, err := ParseString("X*_two + ten + Y")
+	assert.Nil(t, err)
+
+	globals := &countingGlobals{}
+	resolver := &countingResolver{StructResolver: NewStructResolver(reflect.ValueOf(A{X: 5, Y: 2})), globals: globals}
+	for i := 0; i < 3; i++ {
+		v, err := EvalProgram(resolver, program)
+		assert.Nil(t, err)
+		assert.Equal(t, 22, v)
+	}
+
+	// Only names beginning with an underscore are resolved as builtins, and
+	// globals are resolved once, for each name of the program.
+	assert.Equal(t, []string{"_two", "_two", "_two"}, resolver.builtins)
+	assert.Equal(t, 4, globals.lookups)
+}
+
+// convertingResolver is a FieldResolver that doubles the values of field X,
+// and counts the converters it returns.
+type convertingResolver struct {
+	*StructResolver
+	converters int
+}
+
+func (r *convertingResolver) FieldConverter(typ reflect.Type, index []int) func(reflect.Value) Value {
+	if typ.FieldByIndex(index).Name != "X" {
+		return nil
+	}
+	r.converters++
+	return func(v reflect.Value) Value { return ValueOf(v.Int() * 2) }
+}
+
+func TestVMFieldConverter(t *testing.T) {
+	type A struct{ X, Y int64 }
+
+	program, err := ParseString("X + Y")
+	assert.Nil(t, err)
+
+	// Converters are bound once, with the field indices.
+	resolver := &convertingResolver{StructResolver: NewStructResolver(reflect.ValueOf(A{X: 5, Y: 2}))}
+	for i := 0; i < 3; i++ {
+		v, err := EvalProgram(resolver, program)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(12), v)
+	}
+	assert.Equal(t, 1, resolver.converters)
+
+	// Bindings are not shared with resolvers of other types.
+	v, err := EvalProgram(NewStructResolver(reflect.ValueOf(A{X: 5, Y: 2})), program)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), v)
+}
+
+func TestVMGlobalsBounded(t *testing.T) {
+	type A struct{ X, Y int }
+
+	program, err := ParseString("X*_two + ten + Y")
+	assert.Nil(t, err)
+
+	// Values are cached for a bounded number of resolvers of globals.
+	for i := 0; i < maxGlobalResolvers*2; i++ {
+		resolver := &countingResolver{StructResolver: NewStructResolver(reflect.ValueOf(A{X: 5, Y: 2})), globals: &countingGlobals{}}
+		v, err := EvalProgram(resolver, program)
+		assert.Nil(t, err)
+		assert.Equal(t, 22, v)
+		assert.True(t, len(program.code.globalvals) <= maxGlobalResolvers)
+	}
+}
+
+func BenchmarkEvalProgram(b *testing.B) {
+	s := TestVMStruct{I: 10, U8: 3}
+	resolver := NewStructResolver(reflect.ValueOf(s))
+	program, err := ParseString("I*2+I > 20 && U8 != 0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EvalProgram(resolver, program); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvalTree(b *testing.B) {
+	s := TestVMStruct{I: 10, U8: 3}
+	resolver := NewStructResolver(reflect.ValueOf(s))
+	program, _ := ParseString("I*2+I > 20 && U8 != 0")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		evaltree(resolver, program.root)
+	}
+}
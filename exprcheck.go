@@ -0,0 +1,433 @@
+package restruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-restruct/restruct/expr"
+)
+
+// ExprTypeError is returned when an expression in a struct tag does not type
+// check against its struct.
+type ExprTypeError struct {
+	Struct reflect.Type
+	Field  string
+	Tag    string
+	Err    error
+}
+
+func (e ExprTypeError) Error() string {
+	return fmt.Sprintf("%s.%s: %s: %v", e.Struct, e.Field, e.Tag, e.Err)
+}
+
+// ExprTypeErrors is a list of all of the expression type errors in a struct.
+type ExprTypeErrors []ExprTypeError
+
+func (e ExprTypeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// exprTypeResolver resolves the types of identifiers in the expressions of a
+// struct, mirroring Resolve on structstack.
+type exprTypeResolver struct {
+	env    *ExprEnv
+	typ    reflect.Type
+	fields []field
+
+	// parent and root are the types of _parent and _root, and are nil if
+	// the struct has no parent.
+	parent reflect.Type
+	root   reflect.Type
+
+	// elem is the type of _elem, for until expressions.
+	elem reflect.Type
+
+	// switchType is the type of _switch, for case expressions.
+	switchType expr.Type
+
+	// packing is set for expressions that decide the size of a value when
+	// it is packed, which can not depend on the size of the packed data.
+	packing bool
+}
+
+func (r exprTypeResolver) TypeResolve(ident string) expr.Type {
+	if r.packing {
+		switch ident {
+		case "_eof", "_size":
+			return nil
+		case "_io":
+			return expr.NewPackageType(map[string]expr.Type{
+				"pos":    expr.NewPrimitiveType(expr.Int),
+				"bitpos": expr.NewPrimitiveType(expr.Int),
+			})
+		}
+	}
+
+	switch ident {
+	case "_eof":
+		return expr.NewPrimitiveType(expr.Bool)
+	case "_elem":
+		if r.elem != nil {
+			return exprTypeOf(r.elem)
+		}
+		return nil
+	case "_index", "_pos", "_bitpos", "_size":
+		return expr.NewPrimitiveType(expr.Int)
+	case "_io":
+		return expr.ValueOf(exprIOPackage(0, 0, 0, 0, false)).Type()
+	case "_switch":
+		return r.switchType
+	case "_parent":
+		if r.parent != nil {
+			return exprTypeOf(r.parent)
+		}
+		return nil
+	case "_root":
+		if r.root != nil {
+			return exprTypeOf(r.root)
+		}
+		return nil
+	default:
+		if sf, ok := r.typ.FieldByName(ident); ok && sf.PkgPath == "" {
+			for _, f := range r.fields {
+				if len(sf.Index) == 1 && f.Index == sf.Index[0] && f.NativeType != nil {
+					return exprFieldType(f)
+				}
+			}
+			return exprTypeOf(sf.Type)
+		}
+		if v := resolveExprGlobal(r.env, ident); v != nil {
+			return v.Type()
+		}
+		return nil
+	}
+}
+
+// isBuiltin returns true if an identifier is resolved before the fields of
+// the struct.
+func (r exprTypeResolver) isBuiltin(ident string) bool {
+	return exprBuiltins[ident]
+}
+
+// exprTypeOf returns the expression type of a field type. Big integers are
+// converted to integers when evaluated, as in exprValueOf.
+func exprTypeOf(typ reflect.Type) expr.Type {
+	if typ == bigIntType || typ == bigIntPtrType {
+		return expr.NewPrimitiveType(expr.Int64)
+	}
+	return expr.FromReflectType(typ)
+}
+
+func isIntegerType(t expr.Type) bool {
+	switch t.Kind() {
+	case expr.Int, expr.Int8, expr.Int16, expr.Int32, expr.Int64,
+		expr.Uint, expr.Uint8, expr.Uint16, expr.Uint32, expr.Uint64, expr.Uintptr,
+		expr.UntypedInt:
+		return true
+	}
+	return false
+}
+
+func isBoolType(t expr.Type) bool {
+	return t.Kind() == expr.Bool || t.Kind() == expr.UntypedBool
+}
+
+// errPackedSize is the error for expressions that decide the size of a value
+// when it is packed, but refer to the size of the packed data.
+var errPackedSize = errors.New("expression refers to _size, _eof, _io.size, _io.remaining or _io.eof, which are not known until the value is packed")
+
+// checkScopeExprs type checks the expressions of the fields of a scope. It
+// also returns the errors for expressions that can not be evaluated when
+// packing.
+func checkScopeExprs(env *ExprEnv, scope *exprScope, root reflect.Type) (errs, packErrs ExprTypeErrors) {
+	resolver := exprTypeResolver{env: env, typ: scope.typ, fields: cachedFieldsFromStruct(scope.typ), root: root}
+	if scope.parent != nil {
+		resolver.parent = scope.parent.typ
+	}
+
+	// switchTypes holds the type of each switch in the scope, by position.
+	switchTypes := make([]expr.Type, len(scope.fields))
+
+	for i, f := range scope.fields {
+		check := func(tag string, program *expr.Program, resolver expr.TypeResolver, want string, ok func(expr.Type) bool) expr.Type {
+			if program == nil {
+				return nil
+			}
+			t, err := expr.Check(resolver, program)
+			if cerrs, isCheck := err.(expr.CheckErrors); isCheck {
+				for _, cerr := range cerrs {
+					errs = append(errs, ExprTypeError{Struct: scope.typ, Field: f.Name, Tag: tag, Err: cerr})
+				}
+				return nil
+			}
+			if ok != nil && !ok(t) {
+				errs = append(errs, ExprTypeError{
+					Struct: scope.typ,
+					Field:  f.Name,
+					Tag:    tag,
+					Err:    expr.CheckError{Msg: fmt.Sprintf("expression has type %s, expected %s", t, want)},
+				})
+				return nil
+			}
+			return t
+		}
+
+		// The expressions that decide which fields are packed, and their
+		// sizes, are evaluated before the size of the packed data is known.
+		packed := func(tag string, program *expr.Program, match expr.Type) {
+			resolver := resolver
+			resolver.packing = true
+			resolver.switchType = match
+			var err error
+			if match != nil {
+				err = expr.CheckMatch(resolver, program, match)
+			} else {
+				_, err = expr.Check(resolver, program)
+			}
+			if err != nil {
+				packErrs = append(packErrs, ExprTypeError{Struct: scope.typ, Field: f.Name, Tag: tag, Err: errPackedSize})
+			}
+		}
+
+		if check("if", f.IfExpr, resolver, "bool", isBoolType) != nil {
+			packed("if", f.IfExpr, nil)
+		}
+		if check("size", f.SizeExpr, resolver, "integer", isIntegerType) != nil && f.BinaryType.Kind() == reflect.Struct {
+			packed("size", f.SizeExpr, nil)
+		}
+		if check("bits", f.BitsExpr, resolver, "integer", isIntegerType) != nil {
+			packed("bits", f.BitsExpr, nil)
+		}
+		check("while", f.WhileExpr, resolver, "bool", isBoolType)
+		if switchTypes[i] = check("switch", f.SwitchExpr, resolver, "", nil); switchTypes[i] != nil {
+			packed("switch", f.SwitchExpr, nil)
+		}
+		check("out", f.OutExpr, resolver, "", nil)
+
+		if f.InExpr != nil {
+			native := exprTypeOf(f.NativeType)
+			check("in", f.InExpr, resolver, native.String(), func(t expr.Type) bool {
+				return expr.TypeEqual(t, native) || expr.Assignable(t, native)
+			})
+		}
+
+		if f.UntilExpr != nil {
+			elemResolver := resolver
+			elemResolver.elem = f.NativeType.Elem()
+			check("until", f.UntilExpr, elemResolver, "bool", isBoolType)
+		}
+
+		// Cases are matched against the value of their switch, which is
+		// only known if the switch type checks.
+		if f.CaseExpr != nil && f.sw >= 0 && switchTypes[f.sw] != nil {
+			caseResolver := resolver
+			caseResolver.switchType = switchTypes[f.sw]
+			err := expr.CheckMatch(caseResolver, f.CaseExpr, caseResolver.switchType)
+			if cerrs, isCheck := err.(expr.CheckErrors); isCheck {
+				for _, cerr := range cerrs {
+					errs = append(errs, ExprTypeError{Struct: scope.typ, Field: f.Name, Tag: "case", Err: cerr})
+				}
+			} else {
+				packed("case", f.CaseExpr, caseResolver.switchType)
+			}
+		}
+	}
+	return errs, packErrs
+}
+
+// exprField is a field whose expressions are evaluated against the struct of
+// a scope. Index is the position of the field in the struct, which for the
+// cases of a switch is that of the switch, and sw is the position of the
+// switch of a case in the scope, or -1.
+type exprField struct {
+	field
+	index int
+	sw    int
+}
+
+// exprScope is a struct type, along with the fields whose expressions are
+// evaluated while it is on top of the struct stack: its own fields, and the
+// cases of its switches.
+type exprScope struct {
+	typ    reflect.Type
+	fields []exprField
+	parent *exprScope
+}
+
+// walkExprScopes calls fn for each scope reachable from a root type, once for
+// each struct type and parent type.
+func walkExprScopes(root reflect.Type, fn func(scope *exprScope)) {
+	seen := map[[2]reflect.Type]bool{}
+
+	var walk func(typ reflect.Type, parent *exprScope)
+	walk = func(typ reflect.Type, parent *exprScope) {
+		key := [2]reflect.Type{typ, nil}
+		if parent != nil {
+			key[1] = parent.typ
+		}
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		scope := &exprScope{typ: typ, parent: parent}
+		var nested []reflect.Type
+		var add func(f field, index int, sw int)
+		add = func(f field, index int, sw int) {
+			scope.fields = append(scope.fields, exprField{f, index, sw})
+			if f.SwitchExpr != nil && f.BinaryType.Kind() == reflect.Struct {
+				sw := len(scope.fields) - 1
+				for _, cf := range cachedFieldsFromStruct(f.BinaryType) {
+					add(cf, index, sw)
+				}
+				return
+			}
+			nested = appendStructTypes(nested, f.BinaryType)
+		}
+		for _, f := range cachedFieldsFromStruct(typ) {
+			add(f, f.Index, -1)
+		}
+
+		fn(scope)
+		for _, t := range nested {
+			walk(t, scope)
+		}
+	}
+
+	if root.Kind() == reflect.Struct {
+		walk(root, nil)
+	}
+}
+
+// appendStructTypes appends the struct types that values of typ hold directly,
+// or as the elements of pointers, arrays and slices.
+func appendStructTypes(types []reflect.Type, typ reflect.Type) []reflect.Type {
+	if typ == nil {
+		return types
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		return append(types, typ)
+	case reflect.Ptr, reflect.Array, reflect.Slice:
+		return appendStructTypes(types, typ.Elem())
+	}
+	return types
+}
+
+// checkExprOrder checks that the expressions evaluated when decoding a scope
+// only refer to fields that are already decoded.
+func checkExprOrder(scope *exprScope) (errs ExprTypeErrors) {
+	for _, f := range scope.fields {
+		// Fields are decoded in order, so expressions may only refer to the
+		// fields before them. Expressions evaluated after a field is decoded
+		// may also refer to the field itself.
+		order := func(tag string, program *expr.Program, self bool) {
+			if program == nil {
+				return
+			}
+			for _, dep := range program.Deps() {
+				if exprBuiltins[dep] {
+					continue
+				}
+				sf, ok := scope.typ.FieldByName(dep)
+				if !ok || sf.PkgPath != "" {
+					continue
+				}
+				if sf.Index[0] < f.index || (sf.Index[0] == f.index && self) {
+					continue
+				}
+				errs = append(errs, ExprTypeError{
+					Struct: scope.typ,
+					Field:  f.Name,
+					Tag:    tag,
+					Err:    fmt.Errorf("%s is referenced before it is decoded", dep),
+				})
+			}
+		}
+
+		order("if", f.IfExpr, false)
+		order("size", f.SizeExpr, false)
+		order("bits", f.BitsExpr, false)
+		order("switch", f.SwitchExpr, false)
+		order("case", f.CaseExpr, false)
+		order("while", f.WhileExpr, true)
+		order("until", f.UntilExpr, true)
+		order("in", f.InExpr, true)
+	}
+	return errs
+}
+
+// exprCheck holds the errors found in the expressions reachable from a root
+// type: type errors, references to the packed size in expressions evaluated
+// before it is known, and references to fields that are not decoded yet.
+type exprCheck struct {
+	types ExprTypeErrors
+	pack  ExprTypeErrors
+	order ExprTypeErrors
+}
+
+// cachedExprCheck checks the expressions reachable from a root type in an
+// environment, which is nil outside of one. The types of _parent and _root,
+// and of the switch matched by a case, depend on the structs enclosing a
+// field, so expressions are checked from the root of each call rather than
+// when fields are cached.
+//
+// Results are cached by the environment, or by the global registry outside
+// of one, until a name is registered in it. Results with errors are not
+// cached, as registering a name may fix them.
+func cachedExprCheck(env *ExprEnv, typ reflect.Type) exprCheck {
+	cache := env
+	if cache == nil {
+		cache = exprRegistry
+	}
+
+	cache.mu.RLock()
+	c, ok := cache.checks[typ]
+	cache.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	walkExprScopes(typ, func(scope *exprScope) {
+		types, pack := checkScopeExprs(env, scope, typ)
+		c.types = append(c.types, types...)
+		c.pack = append(c.pack, pack...)
+		c.order = append(c.order, checkExprOrder(scope)...)
+	})
+
+	if len(c.types) == 0 && len(c.pack) == 0 && len(c.order) == 0 {
+		cache.mu.Lock()
+		cache.checks[typ] = c
+		cache.mu.Unlock()
+	}
+	return c
+}
+
+// checkEncodeExprs returns ExprTypeErrors if the expressions reachable from
+// a type do not type check in an environment, or decide the size of the
+// packed data from that size.
+func checkEncodeExprs(env *ExprEnv, typ reflect.Type) error {
+	c := cachedExprCheck(env, typ)
+	if errs := append(append(ExprTypeErrors{}, c.types...), c.pack...); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkDecodeExprs returns ExprTypeErrors if the expressions reachable from
+// a type do not type check, or refer to fields that are not decoded yet.
+// Such references are only a problem when decoding, so they are not checked
+// by checkEncodeExprs.
+func checkDecodeExprs(env *ExprEnv, typ reflect.Type) error {
+	c := cachedExprCheck(env, typ)
+	if errs := append(append(ExprTypeErrors{}, c.types...), c.order...); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package restruct
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/go-restruct/restruct/expr"
+)
+
+var (
+	// ErrInvalidExprName is returned when registering a name that is not a
+	// valid identifier.
+	ErrInvalidExprName = errors.New("invalid expression identifier")
+
+	// ErrExprNameInUse is returned when registering a name that is already
+	// defined in expressions.
+	ErrExprNameInUse = errors.New("expression identifier already defined")
+
+	// ErrInvalidExprFunc is returned when registering a function that can not
+	// be called from expressions. Functions must return exactly one value,
+	// optionally followed by an error, must not be variadic, and must only use
+	// types that expressions support.
+	ErrInvalidExprFunc = errors.New("invalid expression function")
+
+	// ErrInvalidExprConst is returned when registering a constant whose type
+	// expressions do not support.
+	ErrInvalidExprConst = errors.New("invalid expression constant")
+)
+
+// exprBuiltins are the identifiers resolved by structstack itself.
+var exprBuiltins = map[string]bool{
+	"_eof":    true,
+	"_elem":   true,
+	"_index":  true,
+	"_pos":    true,
+	"_bitpos": true,
+	"_size":   true,
+	"_io":     true,
+	"_parent": true,
+	"_root":   true,
+	"_switch": true,
+}
+
+// ExprEnv is a set of names available to expressions, scoped to the values
+// packed and unpacked with its methods. Names in an environment take
+// precedence over the standard library and the names registered globally
+// with RegisterExprFunc, RegisterExprConst and RegisterExprPackage, but
+// fields of the struct being evaluated still shadow them.
+//
+// Names should be registered before the environment is used, as programs
+// cache the names they resolve in it.
+type ExprEnv struct {
+	mu     sync.RWMutex
+	values map[string]expr.Value
+
+	// checks caches the results of checking the expressions of types in
+	// the environment.
+	checks map[reflect.Type]exprCheck
+}
+
+// NewExprEnv returns a new, empty environment.
+func NewExprEnv() *ExprEnv {
+	return &ExprEnv{values: map[string]expr.Value{}, checks: map[reflect.Type]exprCheck{}}
+}
+
+// RegisterFunc makes a function available to expressions evaluated in the
+// environment under name, as in RegisterExprFunc.
+func (e *ExprEnv) RegisterFunc(name string, fn interface{}) error {
+	v, err := exprFuncValue(fn)
+	if err != nil {
+		return err
+	}
+	return e.register(name, v)
+}
+
+// RegisterConst makes a constant value available to expressions evaluated
+// in the environment under name, as in RegisterExprConst.
+func (e *ExprEnv) RegisterConst(name string, value interface{}) error {
+	v, err := exprConstValue(value)
+	if err != nil {
+		return err
+	}
+	return e.register(name, v)
+}
+
+// RegisterPackage makes a package of functions and constants available to
+// expressions evaluated in the environment under name, as in
+// RegisterExprPackage.
+func (e *ExprEnv) RegisterPackage(name string, members map[string]interface{}) error {
+	v, err := exprPackageValue(members)
+	if err != nil {
+		return err
+	}
+	return e.register(name, v)
+}
+
+// Unpack reads data from a byteslice into a value, as in Unpack, resolving
+// names in the environment.
+func (e *ExprEnv) Unpack(data []byte, order binary.ByteOrder, v interface{}) error {
+	return unpack(e, data, order, v)
+}
+
+// Pack writes a value to a byteslice, as in Pack, resolving names in the
+// environment.
+func (e *ExprEnv) Pack(order binary.ByteOrder, v interface{}) ([]byte, error) {
+	return pack(e, order, v)
+}
+
+// SizeOf returns the binary encoded size of a value in bytes, as in SizeOf,
+// resolving names in the environment.
+func (e *ExprEnv) SizeOf(v interface{}) (int, error) {
+	return sizeOf(e, v)
+}
+
+// BitSize returns the binary encoded size of a value in bits, as in BitSize,
+// resolving names in the environment.
+func (e *ExprEnv) BitSize(v interface{}) (int, error) {
+	return bitSize(e, v)
+}
+
+// register adds a value to the environment.
+func (e *ExprEnv) register(name string, v expr.Value) error {
+	if !isExprIdent(name) {
+		return ErrInvalidExprName
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if exprBuiltins[name] || expr.Builtin(name) != nil || e.values[name] != nil {
+		return ErrExprNameInUse
+	}
+	e.values[name] = v
+	e.checks = map[reflect.Type]exprCheck{}
+	return nil
+}
+
+// resolve returns the value registered in the environment under ident, or
+// nil.
+func (e *ExprEnv) resolve(ident string) expr.Value {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.values[ident]
+}
+
+// exprRegistry holds the user-registered values available to all
+// expressions.
+var exprRegistry = NewExprEnv()
+
+// RegisterExprFunc makes a function available to expressions under name. The
+// function must return exactly one value and must not be variadic. It may
+// also return an error after the value, which fails the evaluation when it is
+// not nil. Fields of the struct being evaluated shadow registered names, as
+// do the names in an ExprEnv.
+func RegisterExprFunc(name string, fn interface{}) error {
+	v, err := exprFuncValue(fn)
+	if err != nil {
+		return err
+	}
+	return registerExprValue(name, v)
+}
+
+// RegisterExprConst makes a constant value available to expressions under
+// name.
+func RegisterExprConst(name string, value interface{}) error {
+	v, err := exprConstValue(value)
+	if err != nil {
+		return err
+	}
+	return registerExprValue(name, v)
+}
+
+// RegisterExprPackage makes a package of functions and constants available
+// to expressions under name. Members are accessed as name.Member; function
+// members are validated as in RegisterExprFunc.
+func RegisterExprPackage(name string, members map[string]interface{}) error {
+	v, err := exprPackageValue(members)
+	if err != nil {
+		return err
+	}
+	return registerExprValue(name, v)
+}
+
+// exprPackageValue returns the expression value of a package, validating its
+// members.
+func exprPackageValue(members map[string]interface{}) (expr.Value, error) {
+	symbols := make(map[string]expr.Value, len(members))
+	for member, value := range members {
+		if !isExprIdent(member) {
+			return nil, ErrInvalidExprName
+		}
+		var v expr.Value
+		var err error
+		if reflect.TypeOf(value) != nil && reflect.TypeOf(value).Kind() == reflect.Func {
+			v, err = exprFuncValue(value)
+		} else {
+			v, err = exprConstValue(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		symbols[member] = v
+	}
+	return expr.ValueOf(expr.NewPackage(symbols)), nil
+}
+
+// exprFuncValue returns the expression value of a function, validating its
+// signature.
+func exprFuncValue(fn interface{}) (v expr.Value, err error) {
+	if reflect.TypeOf(fn) == nil || reflect.TypeOf(fn).Kind() != reflect.Func || reflect.ValueOf(fn).IsNil() {
+		return nil, ErrInvalidExprFunc
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			v, err = nil, ErrInvalidExprFunc
+		}
+	}()
+	v = expr.ValueOf(fn)
+	ft, ok := v.Type().(*expr.FuncType)
+	if !ok || ft.NumOut() != 1 || ft.IsVariadic() {
+		return nil, ErrInvalidExprFunc
+	}
+	return v, nil
+}
+
+// exprConstValue returns the expression value of a constant, validating its
+// type.
+func exprConstValue(value interface{}) (v expr.Value, err error) {
+	if reflect.TypeOf(value) == nil || reflect.TypeOf(value).Kind() == reflect.Func {
+		return nil, ErrInvalidExprConst
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			v, err = nil, ErrInvalidExprConst
+		}
+	}()
+	return expr.ValueOf(value), nil
+}
+
+// registerExprValue adds a value to the global registry, which unlike other
+// environments may not shadow the standard library.
+func registerExprValue(name string, v expr.Value) error {
+	if isExprIdent(name) && stdLibResolver.Resolve(name) != nil {
+		return ErrExprNameInUse
+	}
+	return exprRegistry.register(name, v)
+}
+
+// isExprIdent returns true if name is a valid expression identifier, and not
+// a keyword.
+func isExprIdent(name string) bool {
+	program, err := expr.ParseString(name)
+	if err != nil {
+		return false
+	}
+	deps := program.Deps()
+	return len(deps) == 1 && deps[0] == name
+}
+
+// exprGlobals resolves the identifiers available to all expressions
+// evaluated in an environment, which is nil outside of one.
+type exprGlobals struct {
+	env *ExprEnv
+}
+
+func (g exprGlobals) Resolve(ident string) expr.Value {
+	return resolveExprGlobal(g.env, ident)
+}
+
+// resolveExprGlobal resolves the identifiers available to all expressions:
+// the names in env, if any, followed by the standard library and registered
+// values.
+func resolveExprGlobal(env *ExprEnv, ident string) expr.Value {
+	if env != nil {
+		if v := env.resolve(ident); v != nil {
+			return v
+		}
+	}
+	if v := stdLibResolver.Resolve(ident); v != nil {
+		return v
+	}
+	return exprRegistry.resolve(ident)
+}
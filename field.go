@@ -22,6 +22,15 @@ var ErrInvalidSizeFrom = errors.New("sizefrom specified on fixed size type")
 // ErrInvalidBits is returned when bits is used on an invalid type.
 var ErrInvalidBits = errors.New("bits specified on non-bitwise type")
 
+// ErrInvalidUntil is returned when until is used on an invalid type.
+var ErrInvalidUntil = errors.New("until specified on non-slice type")
+
+// ErrInvalidTerminator is returned when terminator is used on an invalid type.
+var ErrInvalidTerminator = errors.New("terminator specified on non-slice type")
+
+// ErrInvalidRest is returned when rest is used on an invalid type.
+var ErrInvalidRest = errors.New("rest specified on non-slice type")
+
 // FieldFlags is a type for flags that can be applied to fields individually.
 type FieldFlags uint64
 
@@ -42,6 +51,26 @@ const (
 
 	// DefaultFlag is set when the field is designated as a switch case default.
 	DefaultFlag
+
+	// ExcludeFlag causes the element that terminates an until loop to be
+	// consumed without being stored. When packing, a zero element is written
+	// in its place.
+	ExcludeFlag
+
+	// IncludeFlag causes the terminator of a terminated field to be stored as
+	// part of the value.
+	IncludeFlag
+
+	// NoConsumeFlag causes the terminator of a terminated field to be left in
+	// the stream for the next field to read.
+	NoConsumeFlag
+
+	// RestFlag causes a slice or string to consume the remainder of the input.
+	RestFlag
+
+	// StrictFlag causes values to be validated against registered enumerations
+	// when packing and unpacking. It applies to elements of arrays and slices.
+	StrictFlag
 )
 
 // Sizer is a type which has a defined size in binary. The SizeOf function
@@ -72,6 +101,15 @@ type field struct {
 	Trivial    bool
 	BitSize    uint8
 	Flags      FieldFlags
+	Terminator []byte
+	Fixed      *fixedFormat
+	Decimal    decimalFormat
+	Time       timeFormat
+	Encoding   Encoding
+	Pad        []byte
+	Trim       trimMode
+	ASCII      asciiFormat
+	UUID       uuidFormat
 	IsRoot     bool
 	IsParent   bool
 
@@ -81,8 +119,14 @@ type field struct {
 	InExpr     *expr.Program
 	OutExpr    *expr.Program
 	WhileExpr  *expr.Program
+	UntilExpr  *expr.Program
 	SwitchExpr *expr.Program
 	CaseExpr   *expr.Program
+
+	// ConstSize and ConstBits hold the values of SizeExpr and BitsExpr when
+	// they are constant, so that they need not be evaluated.
+	ConstSize *int
+	ConstBits *int
 }
 
 // fields represents a structure.
@@ -115,6 +159,9 @@ func (f *field) Elem() field {
 		SIndex:     -1,
 		Skip:       0,
 		Trivial:    isTypeTrivial(t.Elem()),
+		Flags:      f.Flags & StrictFlag,
+		Fixed:      f.Fixed,
+		Time:       f.Time,
 	}
 }
 
@@ -153,13 +200,106 @@ func validSizeType(typ reflect.Type) bool {
 	}
 }
 
-func parseExpr(sources ...string) *expr.Program {
-	for _, s := range sources {
-		if s != "" {
-			return expr.ParseString(s)
-		}
+// validStreamType returns true if a size expression may give the length of a
+// field in bytes, which limits the stream that its fields are read from.
+func validStreamType(native, binary reflect.Type) bool {
+	return native.Kind() == reflect.Struct && binary.Kind() == reflect.Struct
+}
+
+func validTerminatorType(native, binary reflect.Type) bool {
+	if !validSizeType(native) || !validSizeType(binary) {
+		return false
+	}
+	if binary.Kind() == reflect.String {
+		return true
+	}
+	// Terminators are matched on element boundaries, so elements must take
+	// a whole, non-zero number of bytes.
+	if !isTypeTrivial(binary.Elem()) {
+		return false
+	}
+	bits := trivialBits(binary.Elem())
+	return bits > 0 && bits%8 == 0
+}
+
+// trivialBits returns the encoded size of a trivial type in bits.
+func trivialBits(typ reflect.Type) int {
+	ss := structstack{allowexpr: true}
+	return ss.fieldbits(fieldFromType(typ), reflect.New(typ).Elem())
+}
+
+// parseExpr parses the expression given by the named tag option, or else by
+// the struct-<name> tag of the field, if either is set.
+func parseExpr(typ reflect.Type, val reflect.StructField, name string, source string) *expr.Program {
+	tag := name
+	if source == "" {
+		tag = "struct-" + name
+		source = val.Tag.Get(tag)
+	}
+	if source == "" {
+		return nil
+	}
+	program, err := expr.ParseString(source)
+	if err != nil {
+		panic(TagError{Struct: typ, Field: val.Name, Tag: tag, Err: err})
+	}
+	return program
+}
+
+// constInt returns the value of a constant, non-negative integer expression,
+// or nil if the expression is not one.
+func constInt(program *expr.Program) *int {
+	if program == nil {
+		return nil
 	}
-	return nil
+	v, ok := program.Constant()
+	if !ok {
+		return nil
+	}
+	var n int
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = int(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = int(rv.Uint())
+	default:
+		return nil
+	}
+	if n < 0 {
+		return nil
+	}
+	return &n
+}
+
+// isConst returns true if an expression is absent or constant.
+func isConst(program *expr.Program) bool {
+	if program == nil {
+		return true
+	}
+	_, ok := program.Constant()
+	return ok
+}
+
+// isTrivial returns true if the size of a field is constant. This is the case
+// for fields of constant size types, and slices of them with a constant size
+// expression, as long as no other expression affects the size.
+func (f *field) isTrivial() bool {
+	if f.WhileExpr != nil || f.UntilExpr != nil || f.SwitchExpr != nil || f.CaseExpr != nil {
+		return false
+	}
+	if !isConst(f.IfExpr) || !isConst(f.BitsExpr) {
+		return false
+	}
+	if f.SizeExpr == nil {
+		return isTypeTrivial(f.BinaryType)
+	}
+	if f.ConstSize == nil || f.BinaryType.Kind() != reflect.Slice || f.NativeType.Kind() != reflect.Slice {
+		return false
+	}
+	if f.Terminator != nil || f.Flags&RestFlag != 0 || f.SIndex != -1 {
+		return false
+	}
+	return isTypeTrivial(f.BinaryType.Elem())
 }
 
 // fieldsFromStruct returns a slice of fields for binary packing and unpacking.
@@ -181,7 +321,7 @@ func fieldsFromStruct(typ reflect.Type) (result fields) {
 		}
 
 		// Parse struct tag
-		opts := mustParseTag(val.Tag.Get("struct"))
+		opts := mustParseTag(typ, val)
 		if opts.Ignore {
 			continue
 		}
@@ -210,6 +350,46 @@ func fieldsFromStruct(typ reflect.Type) (result fields) {
 			ftyp = opts.Type
 		}
 
+		// Fixed point
+		var fixed *fixedFormat
+		if opts.Fixed != nil {
+			fixed = opts.Fixed
+			if opts.Type == nil {
+				if typ, ok := defaultFixedType(fixed, val.Type); ok {
+					ftyp = typ
+				}
+			}
+			bits, signed, ok := fixedBits(fixedIntType(ftyp), opts.BitSize)
+			if !ok {
+				panic(ErrInvalidFixed)
+			}
+			if fixed.width(signed) != bits {
+				panic(fmt.Errorf("fixed %s does not fit %d-bit type of %s", fixed, bits, val.Name))
+			}
+			fixed.Bits, fixed.Signed = bits, signed
+			fixed.Round = roundModes[opts.Round]
+			fixed.Saturate = opts.SaturateFlag
+		} else if opts.Round != "" || opts.SaturateFlag {
+			panic(ErrInvalidFixed)
+		} else if fixedIntType(val.Type) == fixedType {
+			panic(fmt.Errorf("%s: Fixed requires a fixed point format", val.Name))
+		}
+
+		// Time
+		if opts.Time != timeNone {
+			if opts.Type == nil {
+				ftyp = likeType(val.Type, opts.Time.defaultType())
+			}
+			if !validTimeType(opts.Time, val.Type, ftyp, opts.BitSize) {
+				panic(ErrInvalidTime)
+			}
+		}
+
+		// UUID
+		if opts.UUID != uuidNone && opts.Type == nil && val.Type.Kind() == reflect.String {
+			ftyp = reflect.TypeOf([16]byte{})
+		}
+
 		// SizeOf
 		sindex := -1
 		tindex := -1
@@ -242,20 +422,54 @@ func fieldsFromStruct(typ reflect.Type) (result fields) {
 		}
 
 		// Expr
-		ifExpr := parseExpr(opts.IfExpr, val.Tag.Get("struct-if"))
-		sizeExpr := parseExpr(opts.SizeExpr, val.Tag.Get("struct-size"))
-		bitsExpr := parseExpr(opts.BitsExpr, val.Tag.Get("struct-bits"))
-		inExpr := parseExpr(opts.InExpr, val.Tag.Get("struct-in"))
-		outExpr := parseExpr(opts.OutExpr, val.Tag.Get("struct-out"))
-		whileExpr := parseExpr(opts.WhileExpr, val.Tag.Get("struct-while"))
-		switchExpr := parseExpr(opts.SwitchExpr, val.Tag.Get("struct-switch"))
-		caseExpr := parseExpr(opts.CaseExpr, val.Tag.Get("struct-case"))
-		if sizeExpr != nil && !validSizeType(val.Type) {
+		ifExpr := parseExpr(typ, val, "if", opts.IfExpr)
+		sizeExpr := parseExpr(typ, val, "size", opts.SizeExpr)
+		bitsExpr := parseExpr(typ, val, "bits", opts.BitsExpr)
+		inExpr := parseExpr(typ, val, "in", opts.InExpr)
+		outExpr := parseExpr(typ, val, "out", opts.OutExpr)
+		whileExpr := parseExpr(typ, val, "while", opts.WhileExpr)
+		untilExpr := parseExpr(typ, val, "until", opts.UntilExpr)
+		switchExpr := parseExpr(typ, val, "switch", opts.SwitchExpr)
+		caseExpr := parseExpr(typ, val, "case", opts.CaseExpr)
+		if sizeExpr != nil && !validSizeType(val.Type) && !validStreamType(val.Type, ftyp) {
 			panic(ErrInvalidSize)
 		}
 		if bitsExpr != nil && !validBitType(ftyp) {
 			panic(ErrInvalidBits)
 		}
+		if untilExpr != nil && val.Type.Kind() != reflect.Slice {
+			panic(ErrInvalidUntil)
+		}
+		if opts.Terminator != nil && opts.ASCII == asciiNone && !validTerminatorType(val.Type, ftyp) {
+			panic(ErrInvalidTerminator)
+		}
+		if opts.RestFlag && (!validSizeType(val.Type) || !validSizeType(ftyp)) {
+			panic(ErrInvalidRest)
+		}
+		if opts.Decimal != decimalNone && !validDecimalType(val.Type, ftyp) {
+			panic(ErrInvalidDecimal)
+		}
+		if opts.Encoding != nil && !validEncodingType(opts.Encoding, val.Type, ftyp) {
+			panic(ErrInvalidEncoding)
+		}
+		if (opts.Pad != nil || opts.Trim != trimDefault) && opts.ASCII == asciiNone && !validPaddingType(val.Type, ftyp) {
+			panic(ErrInvalidPadding)
+		}
+		if opts.ASCII != asciiNone && !validASCIIType(val.Type, ftyp) {
+			panic(ErrInvalidASCII)
+		}
+		if opts.UUID != uuidNone && !validUUIDType(val.Type, ftyp) {
+			panic(ErrInvalidUUID)
+		}
+		if isFlagSet(val.Type, ftyp) && opts.Time == timeNone {
+			width := ftyp.Bits()
+			if opts.BitSize != 0 {
+				width = int(opts.BitSize)
+			}
+			if cachedFlagLayout(val.Type).Width > width {
+				panic(fmt.Errorf("flags of %s do not fit in %s", val.Name, ftyp))
+			}
+		}
 
 		// Flags
 		flags := FieldFlags(0)
@@ -268,8 +482,23 @@ func fieldsFromStruct(typ reflect.Type) (result fields) {
 		if opts.DefaultFlag {
 			flags |= DefaultFlag
 		}
+		if opts.ExcludeFlag {
+			flags |= ExcludeFlag
+		}
+		if opts.IncludeFlag {
+			flags |= IncludeFlag
+		}
+		if opts.NoConsumeFlag {
+			flags |= NoConsumeFlag
+		}
+		if opts.RestFlag {
+			flags |= RestFlag
+		}
+		if opts.StrictFlag {
+			flags |= StrictFlag
+		}
 
-		result = append(result, field{
+		f := field{
 			Name:       val.Name,
 			Index:      i,
 			BinaryType: ftyp,
@@ -278,24 +507,39 @@ func fieldsFromStruct(typ reflect.Type) (result fields) {
 			SIndex:     sindex,
 			TIndex:     tindex,
 			Skip:       opts.Skip,
-			Trivial:    isTypeTrivial(ftyp),
 			BitSize:    opts.BitSize,
 			Flags:      flags,
+			Terminator: opts.Terminator,
+			Fixed:      fixed,
+			Decimal:    opts.Decimal,
+			Time:       opts.Time,
+			Encoding:   opts.Encoding,
+			Pad:        opts.Pad,
+			Trim:       opts.Trim,
+			ASCII:      opts.ASCII,
+			UUID:       opts.UUID,
 			IfExpr:     ifExpr,
 			SizeExpr:   sizeExpr,
 			BitsExpr:   bitsExpr,
 			InExpr:     inExpr,
 			OutExpr:    outExpr,
 			WhileExpr:  whileExpr,
+			UntilExpr:  untilExpr,
 			SwitchExpr: switchExpr,
 			CaseExpr:   caseExpr,
-		})
+			ConstSize:  constInt(sizeExpr),
+			ConstBits:  constInt(bitsExpr),
+		}
+		f.Trivial = f.isTrivial()
+		result = append(result, f)
 	}
 
 	for fieldName := range sizeOfMap {
 		panic(fmt.Errorf("couldn't find SizeOf field %s", fieldName))
 	}
 
+	checkRestLast(typ, result)
+
 	return
 }
 
@@ -317,6 +561,64 @@ func cachedFieldsFromStruct(typ reflect.Type) (result fields) {
 	return
 }
 
+// consumesRest returns true if a field reads all of the remaining input of
+// its stream, either itself or through the last field of a struct, pointer
+// or element type.
+func consumesRest(f field) bool {
+	if f.Flags&RestFlag != 0 {
+		return true
+	}
+	if f.SizeExpr != nil || f.BinaryType == nil {
+		return false
+	}
+	switch f.BinaryType.Kind() {
+	case reflect.Struct:
+		for _, sf := range cachedFieldsFromStruct(f.BinaryType) {
+			if consumesRest(sf) {
+				return true
+			}
+		}
+	case reflect.Ptr, reflect.Array, reflect.Slice:
+		switch f.NativeType.Kind() {
+		case reflect.Ptr, reflect.Array, reflect.Slice:
+			return consumesRest(f.Elem())
+		}
+	}
+	return false
+}
+
+// isCase returns true if a field is a case of a switch.
+func isCase(f field) bool {
+	return f.CaseExpr != nil || f.Flags&DefaultFlag != 0
+}
+
+// checkRestLast panics with a TagError if a field that consumes the rest of
+// its stream is followed by another field, which could then never be read.
+// Cases of a switch do not follow one another, while the elements of arrays
+// and slices do.
+func checkRestLast(typ reflect.Type, fields fields) {
+	for i, f := range fields {
+		if f.BinaryType != nil && (f.BinaryType.Kind() == reflect.Array || f.BinaryType.Kind() == reflect.Slice) {
+			if k := f.NativeType.Kind(); (k == reflect.Array || k == reflect.Slice) && consumesRest(f.Elem()) {
+				panic(TagError{Struct: typ, Field: f.Name, Err: errors.New("elements consume the rest of the stream")})
+			}
+		}
+		if !consumesRest(f) {
+			continue
+		}
+		for _, next := range fields[i+1:] {
+			if next.Flags&(RootFlag|ParentFlag) != 0 || isCase(f) && isCase(next) {
+				continue
+			}
+			tag := ""
+			if f.Flags&RestFlag != 0 {
+				tag = "rest"
+			}
+			panic(TagError{Struct: typ, Field: f.Name, Tag: tag, Err: fmt.Errorf("consumes the rest of the stream, but is followed by %s", next.Name)})
+		}
+	}
+}
+
 // isTypeTrivial determines if a given type is constant-size.
 func isTypeTrivial(typ reflect.Type) bool {
 	if typ == nil {
@@ -344,7 +646,7 @@ func isTypeTrivial(typ reflect.Type) bool {
 		return isTypeTrivial(typ.Elem())
 	case reflect.Struct:
 		for _, field := range cachedFieldsFromStruct(typ) {
-			if !isTypeTrivial(field.BinaryType) {
+			if !field.Trivial {
 				return false
 			}
 		}
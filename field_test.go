@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/go-restruct/restruct/expr"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -273,6 +274,19 @@ func TestIsTypeTrivial(t *testing.T) {
 		{struct{ A []int8 }{[]int8{}}, false},
 		{struct{ A [0]int8 }{[0]int8{}}, true},
 		{(*interface{})(nil), false},
+		{struct {
+			A []int8 `struct:"size=2*2"`
+		}{}, true},
+		{struct {
+			N int8
+			A []int8 `struct:"size=N"`
+		}{}, false},
+		{struct {
+			A []int8 `struct:"size=4,while=true"`
+		}{}, false},
+		{struct {
+			A int8 `struct:"if=1 < 2"`
+		}{}, true},
 	}
 
 	for _, test := range tests {
@@ -285,3 +299,118 @@ func BenchmarkFieldsFromStruct(b *testing.B) {
 		fieldsFromStruct(reflect.TypeOf(TestStruct{}))
 	}
 }
+
+func TestFieldsFromBrokenExprSyntax(t *testing.T) {
+	type badSyntax struct {
+		Len  uint8
+		Data []byte `struct-size:"Len +"`
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Error("Broken struct did not panic.")
+		}
+		err, ok := r.(TagError)
+		if !assert.True(t, ok) {
+			return
+		}
+		assert.Equal(t, "restruct.badSyntax.Data: struct-size: line 1, col 6: unexpected EOF, expected expression", err.Error())
+		assert.IsType(t, &expr.SyntaxError{}, err.Err)
+	}()
+
+	fieldsFromStruct(reflect.TypeOf(badSyntax{}))
+}
+
+func TestFieldsFromBrokenExprs(t *testing.T) {
+	type badExprs struct {
+		Len   uint8
+		Name  string
+		Data  []byte  `struct:"size=Name"`
+		Extra []byte  `struct:"size=Missing,if=Len"`
+		Items []uint8 `struct:"until=_elem == \"\""`
+		Count int32   `struct:"in=Name"`
+	}
+
+	_, err := Pack(binary.LittleEndian, &badExprs{})
+	assert.Equal(t, []string{
+		"restruct.badExprs.Data: size: col 1: expression has type string, expected integer",
+		"restruct.badExprs.Extra: if: col 1: expression has type uint8, expected bool",
+		"restruct.badExprs.Extra: size: col 1: undefined: Missing",
+		"restruct.badExprs.Items: until: col 1: invalid operation: _elem == \"\" (mismatched types uint8 and string)",
+		"restruct.badExprs.Count: in: col 1: expression has type string, expected int32",
+	}, exprErrorMessages(t, err))
+	assert.Equal(t, err, Unpack([]byte{}, binary.LittleEndian, &badExprs{}))
+}
+
+func TestFieldsFromScopedExprs(t *testing.T) {
+	type scopedCases struct {
+		Small uint8  `struct:"case=1, 2"`
+		Named uint16 `struct:"case=Name"`
+		Large uint32 `struct:"case=_switch > 0x80 && Flag, 0x10..Len"`
+		Range uint32 `struct:"case=0x10..Name"`
+		Rest  uint64 `struct:"default"`
+	}
+	type scopedChild struct {
+		Data []byte `struct:"size=_parent.Len"`
+		Set  bool   `struct:"if=_root.Flag"`
+		Name uint8  `struct:"if=_parent.Name"`
+		Miss uint8  `struct:"if=_root.Missing"`
+	}
+	type scopedExprs struct {
+		Kind  uint8
+		Len   uint8
+		Flag  bool
+		Name  string
+		Value scopedCases `struct:"switch=Kind"`
+		Child scopedChild
+		Top   uint8 `struct:"if=_parent.Flag"`
+	}
+
+	_, err := Pack(binary.LittleEndian, &scopedExprs{})
+	assert.Equal(t, []string{
+		"restruct.scopedExprs.Named: case: col 1: invalid case Name (mismatched types string and uint8)",
+		"restruct.scopedExprs.Range: case: col 1: invalid range 16..Name (mismatched types untyped int constant, string and uint8)",
+		"restruct.scopedExprs.Top: if: col 1: undefined: _parent",
+		"restruct.scopedChild.Name: if: col 1: expression has type string, expected bool",
+		"restruct.scopedChild.Miss: if: col 7: _root.Missing undefined (type struct has no field Missing)",
+	}, exprErrorMessages(t, err))
+}
+
+// exprErrorMessages returns the messages of the ExprTypeErrors in err.
+func exprErrorMessages(t *testing.T, err error) []string {
+	errs, ok := err.(ExprTypeErrors)
+	if !assert.True(t, ok, "%v is not ExprTypeErrors", err) {
+		return nil
+	}
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return msgs
+}
+
+func TestFieldsFromForwardReference(t *testing.T) {
+	type forwardRef struct {
+		Data  []byte  `struct:"size=Len"`
+		Len   uint8   `struct:"if=Flag"`
+		Flag  bool    `struct:"in=Flag || Count > 0"`
+		Items []uint8 `struct:"until=_elem == Count"`
+		Count uint8
+	}
+
+	EnableExprBeta()
+
+	// Forward references only matter when decoding, so packing still works.
+	data, err := Pack(binary.LittleEndian, &forwardRef{Data: []byte{1}, Len: 1, Flag: true, Items: []uint8{1, 2}, Count: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 1, 1, 1, 2, 2}, data)
+
+	err = Unpack(data, binary.LittleEndian, &forwardRef{})
+	assert.Equal(t, []string{
+		"restruct.forwardRef.Data: size: Len is referenced before it is decoded",
+		"restruct.forwardRef.Len: if: Flag is referenced before it is decoded",
+		"restruct.forwardRef.Flag: in: Count is referenced before it is decoded",
+		"restruct.forwardRef.Items: until: Count is referenced before it is decoded",
+	}, exprErrorMessages(t, err))
+}
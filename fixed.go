@@ -0,0 +1,321 @@
+package restruct
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidFixed is returned when fixed is used on an invalid type.
+var ErrInvalidFixed = errors.New("fixed specified on non-integer type")
+
+// Fixed is a fixed point number whose value is Raw / 2^Frac. It can be used
+// instead of a float for fields with the fixed tag, to hold values exactly.
+// Unpacking sets Frac to the fraction bits of the format, and packing rescales
+// values with other fraction bits using the rounding mode of the field.
+type Fixed struct {
+	Raw  int64
+	Frac int
+}
+
+var fixedType = reflect.TypeOf(Fixed{})
+
+// NewFixed returns the fixed point number with frac fraction bits that is
+// nearest to x, with ties to even.
+func NewFixed(x float64, frac int) Fixed {
+	return Fixed{Raw: int64(roundEven.round(math.Ldexp(x, frac))), Frac: frac}
+}
+
+// Float64 returns the value of x as a float.
+func (x Fixed) Float64() float64 {
+	return math.Ldexp(float64(x.Raw), -x.Frac)
+}
+
+func (x Fixed) String() string {
+	return strconv.FormatFloat(x.Float64(), 'g', -1, 64)
+}
+
+// roundMode specifies how fixed point values are rounded when packing.
+type roundMode int
+
+const (
+	// roundEven rounds to the nearest value, with ties to even.
+	roundEven roundMode = iota
+
+	// roundNearest rounds to the nearest value, with ties away from zero.
+	roundNearest
+
+	// roundFloor rounds towards negative infinity.
+	roundFloor
+
+	// roundCeil rounds towards positive infinity.
+	roundCeil
+
+	// roundTrunc rounds towards zero.
+	roundTrunc
+)
+
+var roundModes = map[string]roundMode{
+	"even":    roundEven,
+	"nearest": roundNearest,
+	"floor":   roundFloor,
+	"ceil":    roundCeil,
+	"trunc":   roundTrunc,
+}
+
+func (r roundMode) round(x float64) float64 {
+	switch r {
+	case roundNearest:
+		return math.Round(x)
+	case roundFloor:
+		return math.Floor(x)
+	case roundCeil:
+		return math.Ceil(x)
+	case roundTrunc:
+		return math.Trunc(x)
+	default:
+		return math.RoundToEven(x)
+	}
+}
+
+// roundShift divides x by 2^n, rounding the quotient.
+func (r roundMode) roundShift(x *big.Int, n uint) *big.Int {
+	q, m := new(big.Int).DivMod(x, new(big.Int).Lsh(big.NewInt(1), n), new(big.Int))
+	if m.Sign() == 0 {
+		return q
+	}
+
+	// q is rounded towards negative infinity, and m is the positive remainder.
+	half := new(big.Int).Lsh(big.NewInt(1), n-1)
+	up := false
+	switch r {
+	case roundNearest:
+		c := m.Cmp(half)
+		up = c > 0 || c == 0 && x.Sign() > 0
+	case roundFloor:
+	case roundCeil:
+		up = true
+	case roundTrunc:
+		up = x.Sign() < 0
+	default:
+		c := m.Cmp(half)
+		up = c > 0 || c == 0 && q.Bit(0) == 1
+	}
+	if up {
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
+
+// fixedFormat describes a fixed point number format. Int and Frac are the
+// number of integer and fraction bits. In Q notation, Int does not include
+// the sign bit of signed types.
+type fixedFormat struct {
+	Int      int
+	Frac     int
+	Q        bool
+	Round    roundMode
+	Saturate bool
+
+	// Bits and Signed describe the underlying integer type. They are filled
+	// in when the field is created.
+	Bits   int
+	Signed bool
+}
+
+// parseFixed parses a fixed point format of the form m.n, Qm.n or Qn.
+func parseFixed(s string) (*fixedFormat, error) {
+	ff := &fixedFormat{}
+	if strings.HasPrefix(s, "Q") || strings.HasPrefix(s, "q") {
+		ff.Q = true
+		s = s[1:]
+	}
+	parts := strings.SplitN(s, ".", 2)
+	if ff.Q && len(parts) == 1 {
+		// Qn is short for Q0.n.
+		parts = []string{"0", parts[0]}
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid format %q, expected m.n", s)
+	}
+	var err error
+	if ff.Int, err = strconv.Atoi(parts[0]); err != nil || ff.Int < 0 {
+		return nil, fmt.Errorf("invalid integer bits %q", parts[0])
+	}
+	if ff.Frac, err = strconv.Atoi(parts[1]); err != nil || ff.Frac < 0 {
+		return nil, fmt.Errorf("invalid fraction bits %q", parts[1])
+	}
+	return ff, nil
+}
+
+// width returns the number of bits of the integer type for the format.
+func (ff *fixedFormat) width(signed bool) int {
+	if ff.Q && signed {
+		return ff.Int + ff.Frac + 1
+	}
+	return ff.Int + ff.Frac
+}
+
+func (ff *fixedFormat) String() string {
+	if ff.Q {
+		return fmt.Sprintf("Q%d.%d", ff.Int, ff.Frac)
+	}
+	return fmt.Sprintf("%d.%d", ff.Int, ff.Frac)
+}
+
+// fixedIntType returns the integer type that holds a fixed point value of
+// typ, which may be an integer, or an array or slice of integers.
+func fixedIntType(typ reflect.Type) reflect.Type {
+	for {
+		if _, _, ok := wideInt(typ); ok {
+			return typ
+		}
+		switch typ.Kind() {
+		case reflect.Array, reflect.Slice:
+			typ = typ.Elem()
+		default:
+			return typ
+		}
+	}
+}
+
+// fixedBits returns the width and signedness of an integer binary type.
+func fixedBits(typ reflect.Type, bitSize uint8) (bits int, signed bool, ok bool) {
+	if size, signed, ok := wideInt(typ); ok {
+		if size > 8 {
+			return 0, false, false
+		}
+		return size * 8, signed, true
+	}
+	switch typ.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits, signed = typ.Bits(), true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits, signed = typ.Bits(), false
+	default:
+		return 0, false, false
+	}
+	if bitSize != 0 {
+		bits = int(bitSize)
+	}
+	return bits, signed, true
+}
+
+// defaultFixedType returns the signed binary type for a fixed point format
+// on a native type, used when no binary type is given.
+func defaultFixedType(ff *fixedFormat, native reflect.Type) (reflect.Type, bool) {
+	switch native.Kind() {
+	case reflect.Array:
+		if elem, ok := defaultFixedType(ff, native.Elem()); ok {
+			return reflect.ArrayOf(native.Len(), elem), true
+		}
+	case reflect.Slice:
+		if elem, ok := defaultFixedType(ff, native.Elem()); ok {
+			return reflect.SliceOf(elem), true
+		}
+	case reflect.Float32, reflect.Float64:
+		typ, ok := typeMap[fmt.Sprintf("int%d", ff.width(true))]
+		return typ, ok
+	case reflect.Struct:
+		if native == fixedType {
+			typ, ok := typeMap[fmt.Sprintf("int%d", ff.width(true))]
+			return typ, ok
+		}
+	}
+	return nil, false
+}
+
+// unpack converts a raw integer to a float.
+func (ff *fixedFormat) unpack(x int64) float64 {
+	return math.Ldexp(float64(x), -ff.Frac)
+}
+
+// unpackUint converts a raw unsigned integer to a float.
+func (ff *fixedFormat) unpackUint(x uint64) float64 {
+	return math.Ldexp(float64(x), -ff.Frac)
+}
+
+// unpackFixed converts a raw integer to a Fixed.
+func (ff *fixedFormat) unpackFixed(x int64) Fixed {
+	return Fixed{Raw: x, Frac: ff.Frac}
+}
+
+// scale converts a float to a raw value, rounding and checking the range of
+// the underlying integer type.
+func (ff *fixedFormat) scale(f field, x float64) float64 {
+	if math.IsNaN(x) {
+		panic(fmt.Errorf("%s: cannot store NaN as fixed %s", f.Name, ff))
+	}
+
+	r := ff.Round.round(math.Ldexp(x, ff.Frac))
+
+	min, max := 0.0, math.Ldexp(1, ff.Bits)
+	if ff.Signed {
+		min, max = -math.Ldexp(1, ff.Bits-1), math.Ldexp(1, ff.Bits-1)
+	}
+
+	// max is exclusive, since it is exactly representable where the largest
+	// integer value may not be.
+	if r < min || r >= max {
+		if !ff.Saturate {
+			panic(fmt.Errorf("%s: value %v out of range for fixed %s", f.Name, x, ff))
+		}
+		if r < min {
+			return min
+		}
+		return max - 1
+	}
+	return r
+}
+
+// pack converts a float to a raw signed integer.
+func (ff *fixedFormat) pack(f field, x float64) int64 {
+	r := ff.scale(f, x)
+	if r >= math.Ldexp(1, 63) {
+		// Saturated int64; max - 1 rounds back up to max as a float.
+		return math.MaxInt64
+	}
+	return int64(r)
+}
+
+// packUint converts a float to a raw unsigned integer.
+func (ff *fixedFormat) packUint(f field, x float64) uint64 {
+	r := ff.scale(f, x)
+	if r >= math.Ldexp(1, 64) {
+		return math.MaxUint64
+	}
+	return uint64(r)
+}
+
+// packFixed converts a Fixed to a raw integer, rescaling it to the fraction
+// bits of the format and checking the range of the underlying integer type.
+func (ff *fixedFormat) packFixed(f field, x Fixed) *big.Int {
+	r := big.NewInt(x.Raw)
+	if shift := ff.Frac - x.Frac; shift >= 0 {
+		r.Lsh(r, uint(shift))
+	} else {
+		r = ff.Round.roundShift(r, uint(-shift))
+	}
+
+	min, max := big.NewInt(0), new(big.Int).Lsh(big.NewInt(1), uint(ff.Bits))
+	if ff.Signed {
+		max.Rsh(max, 1)
+		min.Neg(max)
+	}
+	max.Sub(max, big.NewInt(1))
+
+	if r.Cmp(min) < 0 || r.Cmp(max) > 0 {
+		if !ff.Saturate {
+			panic(fmt.Errorf("%s: value %v out of range for fixed %s", f.Name, x, ff))
+		}
+		if r.Cmp(min) < 0 {
+			return min
+		}
+		return max
+	}
+	return r
+}
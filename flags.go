@@ -0,0 +1,280 @@
+package restruct
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FlagsError is returned when a strict flag set has reserved bits set.
+type FlagsError struct {
+	Type     reflect.Type
+	Reserved uint64
+}
+
+func (e FlagsError) Error() string {
+	return fmt.Sprintf("reserved bits %#x set in flags type %s", e.Reserved, e.Type)
+}
+
+// flagBit is a single named bit (or group of bits) in a flag set.
+type flagBit struct {
+	Name   string
+	Index  int
+	Mask   uint64
+	Invert bool
+}
+
+// flagLayout describes the bits of a flag set. For bool structs, Index holds
+// the struct field of each bit; for registered integer types it is unused.
+type flagLayout struct {
+	Bits  []flagBit
+	Mask  uint64
+	Width int
+}
+
+var flagCache = map[reflect.Type]*flagLayout{}
+var flagMutex = sync.RWMutex{}
+
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isFlagSet returns true if a field of the native type should be packed as a
+// flag set into the binary type.
+func isFlagSet(native, binary reflect.Type) bool {
+	return native.Kind() == reflect.Struct && native != fixedType && isIntKind(binary.Kind())
+}
+
+// flagLayoutFromStruct computes the bit layout of a bool struct. Each field
+// takes the bit following the previous field unless it specifies bit=N. Blank
+// (_) fields occupy a bit without declaring it, marking it as reserved.
+func flagLayoutFromStruct(typ reflect.Type) *flagLayout {
+	layout := &flagLayout{}
+	used := uint64(0)
+	next := 0
+
+	for i := 0; i < typ.NumField(); i++ {
+		val := typ.Field(i)
+
+		// Skip unexported names (except _)
+		if val.PkgPath != "" && val.Name != "_" {
+			continue
+		}
+
+		opts := mustParseTag(typ, val)
+		if opts.Ignore {
+			continue
+		}
+
+		if val.Type.Kind() != reflect.Bool {
+			panic(fmt.Errorf("flag %s in %s must be a bool", val.Name, typ))
+		}
+
+		bit := next
+		if opts.HasBit {
+			bit = opts.Bit
+		}
+		if bit > 63 {
+			panic(fmt.Errorf("flag %s in %s is out of range", val.Name, typ))
+		}
+		next = bit + 1
+
+		mask := uint64(1) << uint(bit)
+		if used&mask != 0 {
+			panic(fmt.Errorf("flag %s in %s reuses bit %d", val.Name, typ, bit))
+		}
+		used |= mask
+		if next > layout.Width {
+			layout.Width = next
+		}
+
+		if val.Name == "_" {
+			continue
+		}
+
+		layout.Mask |= mask
+		layout.Bits = append(layout.Bits, flagBit{
+			Name:   val.Name,
+			Index:  i,
+			Mask:   mask,
+			Invert: opts.InvertedBoolFlag,
+		})
+	}
+
+	return layout
+}
+
+// cachedFlagLayout returns the layout of a registered flag type or bool
+// struct, computing and caching the latter as needed.
+func cachedFlagLayout(typ reflect.Type) *flagLayout {
+	flagMutex.RLock()
+	layout, ok := flagCache[typ]
+	flagMutex.RUnlock()
+	if ok {
+		return layout
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+
+	layout = flagLayoutFromStruct(typ)
+
+	flagMutex.Lock()
+	flagCache[typ] = layout
+	flagMutex.Unlock()
+
+	return layout
+}
+
+/*
+RegisterFlags registers the named bits of an integer flag type. The typ
+parameter is any value of the flag type, and names must be a map from that
+type to string, like so:
+
+	restruct.RegisterFlags(Attr(0), map[Attr]string{
+		AttrReadOnly: "ReadOnly",
+		AttrHidden:   "Hidden",
+	})
+
+Fields tagged with strict will fail to pack or unpack with a FlagsError if
+they have any unregistered bits set. Bool structs do not need registration.
+*/
+func RegisterFlags(typ interface{}, names interface{}) {
+	t := reflect.TypeOf(typ)
+	nv := reflect.ValueOf(names)
+	if !isIntKind(t.Kind()) {
+		panic(fmt.Errorf("flag type %s must be an integer type", t))
+	}
+	if nv.Kind() != reflect.Map || nv.Type().Key() != t || nv.Type().Elem().Kind() != reflect.String {
+		panic(fmt.Errorf("flag names for %s must be a map[%s]string", t, t))
+	}
+
+	layout := &flagLayout{Width: t.Bits()}
+	for _, key := range nv.MapKeys() {
+		mask := uintFromValue(key) & widthMask(t.Bits())
+		layout.Mask |= mask
+		layout.Bits = append(layout.Bits, flagBit{
+			Name:  nv.MapIndex(key).String(),
+			Index: -1,
+			Mask:  mask,
+		})
+	}
+	sort.Slice(layout.Bits, func(i, j int) bool {
+		return layout.Bits[i].Mask < layout.Bits[j].Mask
+	})
+
+	flagMutex.Lock()
+	flagCache[t] = layout
+	flagMutex.Unlock()
+}
+
+func uintFromValue(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	default:
+		return v.Uint()
+	}
+}
+
+func widthMask(width int) uint64 {
+	if width >= 64 {
+		return ^uint64(0)
+	}
+	return uint64(1)<<uint(width) - 1
+}
+
+// FlagsString returns the names of the flags set in a flag value, separated by
+// "|". Bits without names are printed in hexadecimal. The value may be a
+// registered integer flag type or a bool struct.
+func FlagsString(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	layout := cachedFlagLayout(rv.Type())
+	if layout == nil {
+		return fmt.Sprintf("%#x", uintFromValue(rv))
+	}
+
+	names := []string{}
+	if rv.Kind() == reflect.Struct {
+		for _, bit := range layout.Bits {
+			if rv.Field(bit.Index).Bool() {
+				names = append(names, bit.Name)
+			}
+		}
+		if len(names) == 0 {
+			return "0"
+		}
+		return strings.Join(names, "|")
+	}
+
+	x := uintFromValue(rv) & widthMask(layout.Width)
+	rest := x
+	for _, bit := range layout.Bits {
+		if bit.Mask != 0 && x&bit.Mask == bit.Mask {
+			names = append(names, bit.Name)
+			rest &^= bit.Mask
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", rest))
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// packFlags returns the integer value of a bool struct flag set.
+func packFlags(v reflect.Value) uint64 {
+	layout := cachedFlagLayout(v.Type())
+	x := uint64(0)
+	for _, bit := range layout.Bits {
+		if v.Field(bit.Index).Bool() != bit.Invert {
+			x |= bit.Mask
+		}
+	}
+	return x
+}
+
+// unpackFlags sets the fields of a bool struct flag set from an integer. With
+// strict, undeclared bits must be clear.
+func unpackFlags(f field, v reflect.Value, x uint64) {
+	layout := cachedFlagLayout(v.Type())
+	width := f.BinaryType.Bits()
+	if f.BitSize != 0 {
+		width = int(f.BitSize)
+	}
+	x &= widthMask(width)
+	if f.Flags&StrictFlag != 0 && x&^layout.Mask != 0 {
+		panic(FlagsError{Type: v.Type(), Reserved: x &^ layout.Mask})
+	}
+	for _, bit := range layout.Bits {
+		v.Field(bit.Index).SetBool((x&bit.Mask != 0) != bit.Invert)
+	}
+}
+
+// checkFlags panics with a FlagsError if v is of a registered integer flag
+// type and has unregistered bits set.
+func checkFlags(v reflect.Value) {
+	if !isIntKind(v.Kind()) {
+		return
+	}
+	flagMutex.RLock()
+	layout, ok := flagCache[v.Type()]
+	flagMutex.RUnlock()
+	if !ok {
+		return
+	}
+	x := uintFromValue(v) & widthMask(layout.Width)
+	if x&^layout.Mask != 0 {
+		panic(FlagsError{Type: v.Type(), Reserved: x &^ layout.Mask})
+	}
+}
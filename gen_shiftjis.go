@@ -0,0 +1,99 @@
+//go:build ignore
+// +build ignore
+
+// This program generates shiftjis_table.go from the WHATWG JIS X 0208 index.
+// Run it with go generate, or with the path of a local copy of the index:
+//
+//	go run gen_shiftjis.go [index-jis0208.txt]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const indexURL = "https://encoding.spec.whatwg.org/index-jis0208.txt"
+
+func main() {
+	var r io.Reader
+	if len(os.Args) > 1 {
+		f, err := os.Open(os.Args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	} else {
+		resp, err := http.Get(indexURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("%s: %s", indexURL, resp.Status)
+		}
+		r = resp.Body
+	}
+
+	var table []uint16
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			log.Fatalf("invalid line %q", line)
+		}
+		pointer, err := strconv.Atoi(fields[0])
+		if err != nil {
+			log.Fatalf("invalid pointer in %q", line)
+		}
+		code, err := strconv.ParseUint(fields[1], 0, 16)
+		if err != nil {
+			log.Fatalf("invalid code point in %q", line)
+		}
+		for len(table) <= pointer {
+			table = append(table, 0)
+		}
+		table[pointer] = uint16(code)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "// Code generated by go run gen_shiftjis.go; DO NOT EDIT.")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "package restruct")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "// jis0208 maps the pointers of the WHATWG JIS X 0208 index to Unicode")
+	fmt.Fprintln(buf, "// code points, with zero for unassigned pointers. It is generated from")
+	fmt.Fprintf(buf, "// %s.\n", indexURL)
+	fmt.Fprintf(buf, "var jis0208 = [...]uint16{\n")
+	for i := 0; i < len(table); i += 8 {
+		for j := i; j < i+8 && j < len(table); j++ {
+			fmt.Fprintf(buf, "%#04x, ", table[j])
+		}
+		fmt.Fprintln(buf)
+	}
+	fmt.Fprintln(buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("shiftjis_table.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
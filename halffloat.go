@@ -0,0 +1,171 @@
+package restruct
+
+import (
+	"math"
+	"reflect"
+)
+
+// float16 and bfloat16 are the binary types of IEEE 754 half precision and
+// bfloat16 floating point numbers. Both are read and written as 16-bit
+// integers and converted to and from float32 or float64 native fields.
+type float16 uint16
+type bfloat16 uint16
+
+var float16Type = reflect.TypeOf(float16(0))
+var bfloat16Type = reflect.TypeOf(bfloat16(0))
+
+func init() {
+	typeMap["float16"] = float16Type
+	typeMap["bfloat16"] = bfloat16Type
+}
+
+// floatFormat describes a small binary floating point format.
+type floatFormat struct {
+	expBits  uint
+	mantBits uint
+}
+
+var float16Format = floatFormat{expBits: 5, mantBits: 10}
+var bfloat16Format = floatFormat{expBits: 8, mantBits: 7}
+
+// smallFloat returns the floating point format of a binary type.
+func smallFloat(typ reflect.Type) (floatFormat, bool) {
+	switch typ {
+	case float16Type:
+		return float16Format, true
+	case bfloat16Type:
+		return bfloat16Format, true
+	}
+	return floatFormat{}, false
+}
+
+// shiftRound shifts x right by s bits, rounding to nearest even.
+func shiftRound(x uint64, s uint) uint64 {
+	if s >= 64 {
+		return 0
+	}
+	q := x >> s
+	rem := x & (1<<s - 1)
+	half := uint64(1) << (s - 1)
+	if rem > half || rem == half && q&1 == 1 {
+		q++
+	}
+	return q
+}
+
+// pack converts a float64 to the bits of the format, rounding to nearest
+// even. Values too large for the format become infinity, and NaNs stay NaNs.
+func (ff floatFormat) pack(f float64) uint64 {
+	b := math.Float64bits(f)
+	sign := b >> 63 << (ff.expBits + ff.mantBits)
+	exp := int(b>>52) & 0x7ff
+	mant := b & (1<<52 - 1)
+	maxExp := uint64(1)<<ff.expBits - 1
+	inf := maxExp << ff.mantBits
+
+	switch exp {
+	case 0x7ff:
+		if mant == 0 {
+			return sign | inf
+		}
+		// Keep the top of the payload, but make sure it stays a quiet NaN.
+		return sign | inf | 1<<(ff.mantBits-1) | mant>>(52-ff.mantBits)
+	case 0:
+		// Float64 subnormals are far too small for any smaller format.
+		return sign
+	}
+
+	bias := 1<<(ff.expBits-1) - 1
+	e := exp - 1023 + bias
+	shift := 52 - ff.mantBits
+
+	var r uint64
+	if e > 0 {
+		// A carry out of the mantissa correctly increments the exponent.
+		r = uint64(e)<<ff.mantBits + shiftRound(mant, shift)
+	} else {
+		r = shiftRound(mant|1<<52, shift+uint(1-e))
+	}
+	if r >= inf {
+		r = inf
+	}
+	return sign | r
+}
+
+// unpack converts bits of the format to a float64. This is always exact.
+func (ff floatFormat) unpack(x uint64) float64 {
+	neg := x>>(ff.expBits+ff.mantBits)&1 != 0
+	exp := int(x>>ff.mantBits) & (1<<ff.expBits - 1)
+	mant := x & (1<<ff.mantBits - 1)
+	bias := 1<<(ff.expBits-1) - 1
+
+	var f float64
+	switch exp {
+	case 1<<ff.expBits - 1:
+		if mant != 0 {
+			b := uint64(0x7ff)<<52 | mant<<(52-ff.mantBits)
+			if neg {
+				b |= 1 << 63
+			}
+			return math.Float64frombits(b)
+		}
+		f = math.Inf(1)
+	case 0:
+		f = math.Ldexp(float64(mant), 1-bias-int(ff.mantBits))
+	default:
+		f = math.Ldexp(float64(mant|1<<ff.mantBits), exp-bias-int(ff.mantBits))
+	}
+	if neg {
+		f = math.Copysign(f, -1)
+	}
+	return f
+}
+
+// readSmallFloats reads n small floats into the elements of v in one pass,
+// returning false if the fast path does not apply. Like readElem, it sets the
+// element index for the duration of each element.
+func (d *decoder) readSmallFloats(ef field, v reflect.Value, n int) bool {
+	ff, ok := smallFloat(ef.BinaryType)
+	if !ok || d.bitCounter != 0 || d.bitSize != 0 {
+		return false
+	}
+	switch ef.NativeType.Kind() {
+	case reflect.Float32, reflect.Float64:
+	default:
+		return false
+	}
+
+	index, inelem := d.enterElem(0)
+	b := d.readBytes(n * 2)
+	for i := 0; i < n; i++ {
+		d.index = i
+		v.Index(i).SetFloat(ff.unpack(uint64(d.order.Uint16(b[i*2:]))))
+	}
+	d.leaveElem(index, inelem)
+	return true
+}
+
+// writeSmallFloats writes n elements of v as small floats in one pass,
+// returning false if the fast path does not apply. Like writeElem, it sets the
+// element index for the duration of each element.
+func (e *encoder) writeSmallFloats(ef field, v reflect.Value, n int) bool {
+	ff, ok := smallFloat(ef.BinaryType)
+	if !ok || e.bitCounter != 0 || e.bitSize != 0 {
+		return false
+	}
+	switch ef.NativeType.Kind() {
+	case reflect.Float32, reflect.Float64:
+	default:
+		return false
+	}
+
+	index, inelem := e.enterElem(0)
+	b := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		e.index = i
+		e.order.PutUint16(b[i*2:], uint16(ff.pack(v.Index(i).Float())))
+	}
+	e.writeBits(ef, b)
+	e.leaveElem(index, inelem)
+	return true
+}
@@ -62,6 +62,18 @@ Flags are comma-separated keys. The following are available:
 	                  allowing the definition of bitfields, by appending a
 	                  colon followed by the number of bits. For example,
 	                  uint32:20 would specify a field that is 20 bits long.
+	                  The wide integer types int24, int40, int48, int56 and
+	                  int128 (and their unsigned counterparts) are also
+	                  available. Integers wider than 64 bits can be stored in
+	                  big.Int, *big.Int, [2]uint64 (high, low) or a byte
+	                  array holding the big endian bits.
+
+	                  The float16 and bfloat16 types store half precision
+	                  floats, and can be used with float32 or float64 fields.
+
+	bigint:[N]        Specifies an N-bit signed integer, where N is a
+	biguint:[N]       multiple of 8. It is treated like the wide integer
+	                  types above.
 
 	sizeof=[Field]    Specifies that the field should be treated as a count of
 	                  the number of elements in Field.
@@ -83,8 +95,135 @@ Flags are comma-separated keys. The following are available:
 
 	invertedbool      Specifies that the `true` and `false` encodings for
 	                  boolean should be swapped.
+
+	terminator=[Seq]  Specifies that a string or slice ends at the first
+	                  occurrence of the byte sequence Seq, which is either a
+	                  string literal such as $'\r\n' or a single byte value
+	                  such as 0. The terminator is consumed but not stored,
+	                  and is written back out when packing.
+
+	include           Specifies that the terminator should be stored as part
+	                  of the value instead of being discarded.
+
+	noconsume         Specifies that the terminator should be left for the
+	                  next field to read, and not written when packing.
+
+	size=[Expr]       Specifies the number of elements of a slice or string,
+	                  or the length in bytes of a struct. A sized struct is
+	                  read from a stream limited to its length, so _eof, _io
+	                  and rest fields within it stop at its end, and any
+	                  bytes its fields do not use are skipped, or written as
+	                  zeros when packing.
+
+	rest,greedy       Specifies that a string or slice should consume all of
+	                  the remaining input of its stream: the whole input, or
+	                  the sized struct it is in. It must be the last field
+	                  of its stream, as must a struct that ends with one.
+
+	strict            Specifies that values of types registered with
+	                  RegisterEnum must be one of the registered values, and
+	                  that flag sets must not have reserved bits set. This
+	                  applies to each element of arrays and slices.
+
+	fixed=[M.N]       Specifies that an integer holds a fixed point number
+	                  with M integer bits (including the sign bit) and N
+	                  fraction bits, read into a float32, float64 or Fixed
+	                  field. In Q notation, e.g. fixed=Q1.14, M excludes the
+	                  sign bit.
+	                  Without a type, a signed integer of the right width is
+	                  used. Values that do not fit fail to pack.
+
+	round=[Mode]      Specifies how fixed point values are rounded when
+	                  packing: even (the default), nearest, floor, ceil or
+	                  trunc.
+
+	saturate          Specifies that fixed point values that do not fit are
+	                  clamped to the nearest representable value.
+
+	bcd               Specifies that a byte array holds binary-coded decimal
+	bcd=swapped       digits, read into an integer or string field. Digits
+	                  are stored high nibble first, or low nibble first when
+	                  swapped. Strings are padded with 0xF filler nibbles.
+
+	packed-decimal    Specifies that a byte array holds a COBOL packed
+	comp-3            decimal (COMP-3) number, with a trailing sign nibble.
+
+	time=[Encoding]   Specifies that an integer holds a timestamp, read into a
+	                  time.Time or time.Duration field. Encodings are unix,
+	                  unixms, unixus and unixns (seconds, milliseconds,
+	                  microseconds or nanoseconds since 1970), dos (MS-DOS
+	                  date in the high 16 bits and time in the low 16 bits),
+	                  filetime (100ns intervals since 1601), ntp (32.32 fixed
+	                  point seconds since 1900; whole seconds for types
+	                  narrower than 64 bits), hfs (seconds since 1904), gps
+	                  (seconds since 1980-01-06) and gpsweek (week number in
+	                  the upper bits and seconds of week in the low 32 bits).
+	                  Without a type, int64 is used for unix encodings,
+	                  uint32 for dos, hfs and gps, uint64 for filetime and ntp
+	                  and uint48 for gpsweek. Times are read as UTC, and leap
+	                  seconds are not applied to GPS times. Durations use the
+	                  unit of the encoding, and dos cannot hold a duration.
+	                  Times that do not fit fail to pack.
+
+	encoding=[Name]   Specifies the character encoding of a string field:
+	                  utf8, ascii, latin1, utf16le, utf16be or shift-jis, or
+	                  any name given to RegisterEncoding. Lengths from
+	                  sizeof, sizefrom and size expressions count code units
+	                  (two bytes each for UTF-16), while fixed byte arrays
+	                  count bytes. Terminators only match on code unit
+	                  boundaries.
+
+	pad=[Bytes]       Specifies the padding of a string stored in a fixed
+	                  size byte array, e.g. pad=' '. Values are padded out to
+	                  the size of the array, and trailing padding is removed
+	                  when unpacking. Without pad or trim, strings are padded
+	                  with NULs and cut at the first NUL.
+
+	trim=[Mode]       Specifies which padding is removed from a fixed size
+	                  string: left, right, both or none. Left trimmed values
+	                  are right aligned, and padded on the left when packing.
+	                  With none, the whole field is kept verbatim.
+
+	ascii=[Format]    Specifies that a byte array holds an integer as ASCII
+	                  text: octal, decimal or hex. Digits are zero filled when
+	                  packing, or aligned according to pad and trim when a pad
+	                  is given. A terminator, e.g. terminator=0 for tar, is
+	                  stored after the digits within the array. Spaces and
+	                  NULs around the digits are ignored when unpacking.
+
+	uuid              Specifies that a 16 byte array holds a UUID, read into a
+	guid              [16]byte or string field. With uuid, the RFC 4122 layout
+	                  is used; with guid, the Microsoft layout, where the first
+	                  three groups are little endian. Byte array fields always
+	                  hold the RFC 4122 byte order, and string fields hold the
+	                  canonical lowercase form. The expression function
+	                  uuid("...") returns the [16]byte value of a UUID, e.g.
+	                  for case= comparisons.
+
+	switch=[Expr]     Specifies that a struct holds alternative fields, of
+	                  which the first whose case matches the value of Expr
+	                  is read, or else the field marked default.
+
+	case=[Exprs]      Specifies the values of a switch for which a field is
+	                  read, as a comma separated list of values, ranges such
+	                  as 0x10..0x1F and bool expressions of _switch. The list
+	                  ends before the first element that reads as another
+	                  option: a flag such as big, a type name, or a name
+	                  followed by = or :. Such values must be given in a
+	                  separate struct-case tag, e.g. struct-case:"1, big".
+
+	bit=[N]           Specifies the bit number of a field in a flag set,
+	                  counting from the least significant bit. Fields without
+	                  it take the bit after the previous field.
+
+A struct of bools given an integer type, e.g. `struct:"uint16"`, is packed as a
+flag set, with one bit per field. Fields named _ mark reserved bits.
 */
 func Unpack(data []byte, order binary.ByteOrder, v interface{}) (err error) {
+	return unpack(nil, data, order, v)
+}
+
+func unpack(env *ExprEnv, data []byte, order binary.ByteOrder, v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			var ok bool
@@ -95,7 +234,11 @@ func Unpack(data []byte, order binary.ByteOrder, v interface{}) (err error) {
 	}()
 
 	f, val := fieldFromIntf(v)
-	ss := structstack{allowexpr: expressionsEnabled, buf: data}
+	if err := checkDecodeExprs(env, f.BinaryType); err != nil {
+		return err
+	}
+
+	ss := structstack{env: env, allowexpr: expressionsEnabled, buf: data, size: len(data)}
 	d := decoder{structstack: ss, order: order}
 	d.read(f, val)
 
@@ -106,14 +249,22 @@ func Unpack(data []byte, order binary.ByteOrder, v interface{}) (err error) {
 SizeOf returns the binary encoded size of the given value, in bytes.
 */
 func SizeOf(v interface{}) (size int, err error) {
+	return sizeOf(nil, v)
+}
+
+func sizeOf(env *ExprEnv, v interface{}) (size int, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = r.(error)
 		}
 	}()
 
-	ss := structstack{allowexpr: expressionsEnabled}
 	f, val := fieldFromIntf(v)
+	if err := checkEncodeExprs(env, f.BinaryType); err != nil {
+		return 0, err
+	}
+
+	ss := structstack{env: env, allowexpr: expressionsEnabled}
 	return ss.fieldbytes(f, val), nil
 }
 
@@ -121,14 +272,22 @@ func SizeOf(v interface{}) (size int, err error) {
 BitSize returns the binary encoded size of the given value, in bits.
 */
 func BitSize(v interface{}) (size int, err error) {
+	return bitSize(nil, v)
+}
+
+func bitSize(env *ExprEnv, v interface{}) (size int, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = r.(error)
 		}
 	}()
 
-	ss := structstack{allowexpr: expressionsEnabled}
 	f, val := fieldFromIntf(v)
+	if err := checkEncodeExprs(env, f.BinaryType); err != nil {
+		return 0, err
+	}
+
+	ss := structstack{env: env, allowexpr: expressionsEnabled}
 	return ss.fieldbits(f, val), nil
 }
 
@@ -142,6 +301,10 @@ Each structure is serialized in the same way it would be deserialized with
 Unpack. See Unpack documentation for the struct tag format.
 */
 func Pack(order binary.ByteOrder, v interface{}) (data []byte, err error) {
+	return pack(nil, order, v)
+}
+
+func pack(env *ExprEnv, order binary.ByteOrder, v interface{}) (data []byte, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			data = nil
@@ -149,12 +312,16 @@ func Pack(order binary.ByteOrder, v interface{}) (data []byte, err error) {
 		}
 	}()
 
-	ss := structstack{allowexpr: expressionsEnabled, buf: []byte{}}
-
 	f, val := fieldFromIntf(v)
+	if err := checkEncodeExprs(env, f.BinaryType); err != nil {
+		return nil, err
+	}
+
+	ss := structstack{env: env, allowexpr: expressionsEnabled, buf: []byte{}}
 	data = make([]byte, ss.fieldbytes(f, val))
 
 	ss.buf = data
+	ss.size = len(data)
 	e := encoder{structstack: ss, order: order}
 	e.write(f, val)
 
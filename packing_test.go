@@ -3,8 +3,12 @@ package restruct
 import (
 	"encoding/binary"
 	"errors"
+	"math"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -904,3 +908,2092 @@ func TestInOutExpr(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectData, actualData)
 }
+
+func TestSwitchExpr(t *testing.T) {
+	EnableExprBeta()
+
+	type record struct {
+		Tag  string `struct:"[2]byte"`
+		Pad  uint8
+		Body struct {
+			Byte  *uint8  `struct:"case=\"ab\""`
+			Short *uint16 `struct:"default"`
+		} `struct:"switch=Tag"`
+	}
+
+	var r record
+	err := Unpack([]byte{'a', 'b', 0x00, 0x07}, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	if assert.NotNil(t, r.Body.Byte) {
+		assert.Equal(t, uint8(7), *r.Body.Byte)
+	}
+	assert.Nil(t, r.Body.Short)
+
+	err = Unpack([]byte{'c', 'd', 0x00, 0x07, 0x01}, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Body.Byte)
+	if assert.NotNil(t, r.Body.Short) {
+		assert.Equal(t, uint16(0x0107), *r.Body.Short)
+	}
+
+	data, err := Pack(binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'c', 'd', 0x00, 0x07, 0x01}, data)
+}
+
+func TestUntilExpr(t *testing.T) {
+	EnableExprBeta()
+
+	type tlv struct {
+		Type byte
+		Len  byte `struct:"sizeof=Data"`
+		Data []byte
+	}
+
+	type untilStruct struct {
+		Records []tlv `struct:"until=_elem.Type==0"`
+		Trailer byte
+	}
+
+	type untilExcludeStruct struct {
+		Values  []uint16 `struct:"until=_elem==0,exclude"`
+		Trailer byte
+	}
+
+	{
+		expectStruct := untilStruct{
+			Records: []tlv{
+				{Type: 1, Len: 2, Data: []byte{0xAA, 0xBB}},
+				{Type: 2, Len: 1, Data: []byte{0xCC}},
+				{Type: 0, Len: 0, Data: []byte{}},
+			},
+			Trailer: 0xFF,
+		}
+		expectData := []byte{1, 2, 0xAA, 0xBB, 2, 1, 0xCC, 0, 0, 0xFF}
+
+		var actualStruct untilStruct
+		err := Unpack(expectData, binary.BigEndian, &actualStruct)
+		assert.Nil(t, err)
+		assert.Equal(t, expectStruct, actualStruct)
+
+		actualData, err := Pack(binary.BigEndian, &expectStruct)
+		assert.Nil(t, err)
+		assert.Equal(t, expectData, actualData)
+	}
+
+	{
+		expectStruct := untilExcludeStruct{
+			Values:  []uint16{1, 2, 3},
+			Trailer: 0xFF,
+		}
+		expectData := []byte{0, 1, 0, 2, 0, 3, 0, 0, 0xFF}
+
+		var actualStruct untilExcludeStruct
+		err := Unpack(expectData, binary.BigEndian, &actualStruct)
+		assert.Nil(t, err)
+		assert.Equal(t, expectStruct, actualStruct)
+
+		actualData, err := Pack(binary.BigEndian, &expectStruct)
+		assert.Nil(t, err)
+		assert.Equal(t, expectData, actualData)
+
+		size, err := SizeOf(&expectStruct)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expectData), size)
+	}
+
+	{
+		var actualStruct untilExcludeStruct
+		err := Unpack([]byte{0, 1, 0, 2}, binary.BigEndian, &actualStruct)
+		assert.EqualError(t, err, "Values: unexpected eof before until condition was met")
+
+		_, err = Pack(binary.BigEndian, &untilStruct{Records: []tlv{{Type: 1}}})
+		assert.EqualError(t, err, "Records: last element does not satisfy until condition")
+
+		_, err = Pack(binary.BigEndian, &untilExcludeStruct{Values: []uint16{1, 0, 2}})
+		assert.EqualError(t, err, "Values: element 1 satisfies until condition before end of slice")
+	}
+}
+
+func TestTerminator(t *testing.T) {
+	type terminatorStruct struct {
+		Line    string   `struct:"terminator=$'\\r\\n'"`
+		Image   []byte   `struct:"terminator=$'\\xff\\xd9',include"`
+		Words   []uint16 `struct:"terminator=$'\\x00\\x00'"`
+		Name    []byte   `struct:"terminator=';',noconsume"`
+		Trailer byte
+	}
+
+	expectStruct := terminatorStruct{
+		Line:    "Hello",
+		Image:   []byte{0xFF, 0xD8, 0x00, 0xFF, 0xD9},
+		Words:   []uint16{0x0100, 0x0001},
+		Name:    []byte("abc"),
+		Trailer: ';',
+	}
+	expectData := []byte{
+		'H', 'e', 'l', 'l', 'o', '\r', '\n',
+		0xFF, 0xD8, 0x00, 0xFF, 0xD9,
+		0x01, 0x00, 0x00, 0x01, 0x00, 0x00,
+		'a', 'b', 'c', ';',
+	}
+
+	var actualStruct terminatorStruct
+	err := Unpack(expectData, binary.BigEndian, &actualStruct)
+	assert.Nil(t, err)
+	assert.Equal(t, expectStruct, actualStruct)
+
+	actualData, err := Pack(binary.BigEndian, &expectStruct)
+	assert.Nil(t, err)
+	assert.Equal(t, expectData, actualData)
+
+	size, err := SizeOf(&expectStruct)
+	assert.Nil(t, err)
+	assert.Equal(t, len(expectData), size)
+
+	err = Unpack([]byte("Hello"), binary.BigEndian, &actualStruct)
+	assert.EqualError(t, err, "Line: terminator not found")
+
+	_, err = Pack(binary.BigEndian, &terminatorStruct{Line: "a\r\nb"})
+	assert.EqualError(t, err, "Line: value contains terminator")
+
+	_, err = Pack(binary.BigEndian, &terminatorStruct{Image: []byte{0xFF}})
+	assert.EqualError(t, err, "Image: value does not end with terminator")
+
+	// Elements other than bytes are checked as they are encoded, on element
+	// boundaries.
+	_, err = Pack(binary.LittleEndian, &terminatorStruct{Image: []byte{0xFF, 0xD9}, Words: []uint16{1, 0, 2}})
+	assert.EqualError(t, err, "Words: value contains terminator")
+
+	type includedElems struct {
+		Items []uint16 `struct:"terminator=$'\\xff\\xff',include"`
+		Odd   []uint16 `struct:"terminator=$'\\xff',include"`
+	}
+
+	elemData := []byte{0x00, 0xff, 0xff, 0x01, 0xff, 0xff, 0xff, 0xff}
+	var elems includedElems
+	assert.EqualError(t, Unpack(elemData, binary.LittleEndian, &elems), "Odd: terminator is not a whole number of elements")
+
+	elemData = elemData[:6]
+	elems = includedElems{}
+	assert.EqualError(t, Unpack(elemData, binary.LittleEndian, &elems), "Odd: terminator not found")
+
+	type elemsOnly struct {
+		Items []uint16 `struct:"terminator=$'\\xff\\xff',include"`
+	}
+	var only elemsOnly
+	assert.Nil(t, Unpack(elemData, binary.LittleEndian, &only))
+	assert.Equal(t, elemsOnly{Items: []uint16{0xff00, 0x01ff, 0xffff}}, only)
+
+	actualData, err = Pack(binary.LittleEndian, &only)
+	assert.Nil(t, err)
+	assert.Equal(t, elemData, actualData)
+
+	_, err = Pack(binary.LittleEndian, &elemsOnly{Items: []uint16{0xffff, 1, 0xffff}})
+	assert.EqualError(t, err, "Items: value contains terminator")
+
+	_, err = Pack(binary.LittleEndian, &elemsOnly{Items: []uint16{1}})
+	assert.EqualError(t, err, "Items: value does not end with terminator")
+
+	_, err = Pack(binary.LittleEndian, &includedElems{Items: []uint16{0xffff}, Odd: []uint16{0xff01}})
+	assert.EqualError(t, err, "Odd: terminator is not a whole number of elements")
+
+	// Terminators can not be matched between elements of no size.
+	type emptyElems struct {
+		Items []struct{} `struct:"terminator=$'\\x00'"`
+	}
+	_, err = Pack(binary.LittleEndian, &emptyElems{})
+	assert.Equal(t, ErrInvalidTerminator, err)
+}
+
+func TestRest(t *testing.T) {
+	type restBytes struct {
+		Header byte
+		Data   []byte `struct:"rest"`
+	}
+
+	type restString struct {
+		Header byte
+		Data   string `struct:"greedy"`
+	}
+
+	type restElems struct {
+		Header byte
+		Data   []uint16 `struct:"rest"`
+	}
+
+	type restStructs struct {
+		Header  byte
+		Records []struct {
+			Len  byte `struct:"sizeof=Data"`
+			Data []byte
+		} `struct:"rest"`
+	}
+
+	tests := []struct {
+		data  []byte
+		value interface{}
+	}{
+		{
+			data:  []byte{1, 2, 3, 4},
+			value: restBytes{Header: 1, Data: []byte{2, 3, 4}},
+		},
+		{
+			data:  []byte{1, 'a', 'b'},
+			value: restString{Header: 1, Data: "ab"},
+		},
+		{
+			data:  []byte{1, 0, 2, 0, 3},
+			value: restElems{Header: 1, Data: []uint16{2, 3}},
+		},
+		{
+			data: []byte{1, 1, 'a', 2, 'b', 'c'},
+			value: restStructs{
+				Header: 1,
+				Records: []struct {
+					Len  byte `struct:"sizeof=Data"`
+					Data []byte
+				}{
+					{Len: 1, Data: []byte("a")},
+					{Len: 2, Data: []byte("bc")},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		v := reflect.New(reflect.TypeOf(test.value))
+
+		err := Unpack(test.data, binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.value, v.Elem().Interface())
+
+		data, err := Pack(binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.data, data)
+	}
+
+	err := Unpack([]byte{1, 0, 2, 0}, binary.BigEndian, &restElems{})
+	assert.EqualError(t, err, "Data: remaining input is not a whole number of elements")
+
+	// Fields that consume the rest of their stream must be its last field,
+	// except among the cases of a switch.
+	type restFollowed struct {
+		Data    []byte `struct:"rest"`
+		Trailer byte
+	}
+
+	type restNested struct {
+		Inner struct {
+			Data []byte `struct:"rest"`
+		}
+		Trailer byte
+	}
+
+	type restElemsNested struct {
+		Items []struct {
+			Data []byte `struct:"rest"`
+		} `struct:"rest"`
+	}
+
+	err = Unpack([]byte{1, 2}, binary.BigEndian, &restFollowed{})
+	assert.EqualError(t, err, "restruct.restFollowed.Data: rest: consumes the rest of the stream, but is followed by Trailer")
+	err = Unpack([]byte{1, 2}, binary.BigEndian, &restNested{})
+	assert.EqualError(t, err, "restruct.restNested.Inner: consumes the rest of the stream, but is followed by Trailer")
+	_, err = Pack(binary.BigEndian, &restElemsNested{})
+	assert.EqualError(t, err, "restruct.restElemsNested.Items: elements consume the rest of the stream")
+}
+
+func TestRestSized(t *testing.T) {
+	EnableExprBeta()
+
+	// A sized struct limits the stream of its fields.
+	type sized struct {
+		Len  byte
+		Body struct {
+			Kind byte
+			Data []byte `struct:"rest"`
+		} `struct:"size=Len"`
+		Trailer byte
+	}
+
+	type padded struct {
+		Body struct {
+			A byte
+		} `struct:"size=2"`
+		B byte
+	}
+
+	type eof struct {
+		Body struct {
+			Items []byte `struct:"while=!_eof"`
+		} `struct:"size=2"`
+		Trailer byte
+	}
+
+	type cases struct {
+		Kind byte
+		Body struct {
+			Bytes []byte   `struct:"case=1,rest"`
+			Words []uint16 `struct:"case=2,rest"`
+		} `struct:"switch=Kind"`
+	}
+
+	s := sized{Len: 3, Trailer: 9}
+	s.Body.Kind = 7
+	s.Body.Data = []byte("ab")
+
+	p := padded{B: 2}
+	p.Body.A = 1
+
+	e := eof{Trailer: 3}
+	e.Body.Items = []byte{1, 2}
+
+	c := cases{Kind: 2}
+	c.Body.Words = []uint16{5, 6}
+
+	tests := []struct {
+		data  []byte
+		value interface{}
+	}{
+		{[]byte{3, 7, 'a', 'b', 9}, s},
+		{[]byte{1, 0, 2}, p},
+		{[]byte{1, 2, 3}, e},
+		{[]byte{2, 0, 5, 0, 6}, c},
+	}
+
+	for _, test := range tests {
+		v := reflect.New(reflect.TypeOf(test.value))
+
+		err := Unpack(test.data, binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.value, v.Elem().Interface())
+
+		size, err := SizeOf(v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, len(test.data), size)
+
+		data, err := Pack(binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.data, data)
+	}
+
+	err := Unpack([]byte{5, 7, 'a'}, binary.BigEndian, &sized{})
+	assert.EqualError(t, err, "Body: size 5 exceeds the remaining 2 bytes")
+
+	s.Len = 2
+	_, err = Pack(binary.BigEndian, &s)
+	assert.EqualError(t, err, "Body: length 3 exceeds size expression 2")
+
+	// The size of a struct decides its packed size.
+	type remaining struct {
+		Body struct {
+			Data []byte `struct:"rest"`
+		} `struct:"size=_io.remaining"`
+	}
+
+	err = Unpack([]byte{1, 2}, binary.BigEndian, &remaining{})
+	assert.Nil(t, err)
+	_, err = Pack(binary.BigEndian, &remaining{})
+	assert.EqualError(t, err, "restruct.remaining.Body: size: "+errPackedSize.Error())
+}
+
+type testEnum uint8
+
+const (
+	testEnumA testEnum = 1
+	testEnumB testEnum = 2
+)
+
+func (e testEnum) String() string {
+	return EnumString(e)
+}
+
+func TestEnum(t *testing.T) {
+	RegisterEnum(testEnum(0), map[testEnum]string{
+		testEnumA: "A",
+		testEnumB: "B",
+	})
+
+	assert.Equal(t, "A", testEnumA.String())
+	assert.Equal(t, "testEnum(3)", testEnum(3).String())
+
+	name, ok := EnumName(testEnumB)
+	assert.True(t, ok)
+	assert.Equal(t, "B", name)
+
+	_, ok = EnumName(testEnum(0))
+	assert.False(t, ok)
+
+	_, ok = EnumName(uint8(1))
+	assert.False(t, ok)
+
+	type strict struct {
+		Value testEnum   `struct:"strict"`
+		List  []testEnum `struct:"strict,size=2"`
+	}
+
+	type loose struct {
+		Value testEnum
+	}
+
+	s := strict{}
+	err := Unpack([]byte{1, 1, 2}, binary.BigEndian, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, strict{Value: testEnumA, List: []testEnum{testEnumA, testEnumB}}, s)
+
+	err = Unpack([]byte{3, 1, 2}, binary.BigEndian, &s)
+	assert.Equal(t, EnumError{Type: reflect.TypeOf(testEnum(0)), Value: testEnum(3)}, err)
+	assert.EqualError(t, err, "invalid value testEnum(3) for enum type restruct.testEnum")
+
+	err = Unpack([]byte{1, 1, 0}, binary.BigEndian, &s)
+	assert.EqualError(t, err, "invalid value testEnum(0) for enum type restruct.testEnum")
+
+	_, err = Pack(binary.BigEndian, &strict{Value: testEnumB, List: []testEnum{4, testEnumA}})
+	assert.EqualError(t, err, "invalid value testEnum(4) for enum type restruct.testEnum")
+
+	l := loose{}
+	err = Unpack([]byte{3}, binary.BigEndian, &l)
+	assert.Nil(t, err)
+	assert.Equal(t, testEnum(3), l.Value)
+}
+
+type testFlags uint8
+
+const (
+	testFlagA testFlags = 1 << 0
+	testFlagB testFlags = 1 << 2
+)
+
+func TestFlags(t *testing.T) {
+	type headerFlags struct {
+		Compressed bool
+		Encrypted  bool
+		_          bool
+		Signed     bool `struct:"bit=7"`
+		Invalid    bool `struct:"bit=4,invertedbool"`
+	}
+
+	type header struct {
+		Flags  headerFlags `struct:"uint8"`
+		Strict headerFlags `struct:"uint16,little,strict"`
+		Attr   testFlags   `struct:"strict"`
+	}
+
+	RegisterFlags(testFlags(0), map[testFlags]string{
+		testFlagA: "A",
+		testFlagB: "B",
+	})
+
+	data := []byte{0x9D, 0x91, 0x00, 0x05}
+	value := header{
+		Flags:  headerFlags{Compressed: true, Signed: true},
+		Strict: headerFlags{Compressed: true, Signed: true},
+		Attr:   testFlagA | testFlagB,
+	}
+
+	h := header{}
+	err := Unpack(data, binary.BigEndian, &h)
+	assert.Nil(t, err)
+	assert.Equal(t, value, h)
+
+	packed, err := Pack(binary.BigEndian, &h)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x91, 0x91, 0x00, 0x05}, packed)
+
+	size, err := SizeOf(&h)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, size)
+
+	err = Unpack([]byte{0x00, 0x14, 0x01, 0x00}, binary.BigEndian, &h)
+	assert.Equal(t, FlagsError{Type: reflect.TypeOf(headerFlags{}), Reserved: 0x104}, err)
+	assert.EqualError(t, err, "reserved bits 0x104 set in flags type restruct.headerFlags")
+
+	err = Unpack([]byte{0x00, 0x10, 0x00, 0x03}, binary.BigEndian, &h)
+	assert.EqualError(t, err, "reserved bits 0x2 set in flags type restruct.testFlags")
+
+	_, err = Pack(binary.BigEndian, &header{Attr: 0x10})
+	assert.EqualError(t, err, "reserved bits 0x10 set in flags type restruct.testFlags")
+
+	assert.Equal(t, "A|B", FlagsString(testFlagA|testFlagB))
+	assert.Equal(t, "B|0x80", FlagsString(testFlagB|0x80))
+	assert.Equal(t, "0", FlagsString(testFlags(0)))
+	assert.Equal(t, "Compressed|Signed|Invalid", FlagsString(headerFlags{Compressed: true, Signed: true, Invalid: true}))
+
+	type tooWide struct {
+		Flags struct {
+			High bool `struct:"bit=8"`
+		} `struct:"uint8"`
+	}
+	_, err = Pack(binary.BigEndian, &tooWide{})
+	assert.EqualError(t, err, "flags of Flags do not fit in uint8")
+}
+
+func TestWideInt(t *testing.T) {
+	type wide struct {
+		Count   uint8   `struct:"uint8,sizeof=Samples"`
+		Samples []int32 `struct:"[]int24"`
+		Little  int32   `struct:"int24,little"`
+		Unsign  uint64  `struct:"uint48"`
+		Long    int64   `struct:"int56,little"`
+		Offset  uint32  `struct:"uint40"`
+	}
+
+	type counted struct {
+		Count uint32 `struct:"uint24,sizeof=Data"`
+		Data  []byte
+	}
+
+	type unaligned struct {
+		Nibble uint8  `struct:"uint8:4"`
+		Value  uint32 `struct:"uint24"`
+		Rest   uint8  `struct:"uint8:4"`
+	}
+
+	tests := []struct {
+		data  []byte
+		value interface{}
+	}{
+		{
+			data: []byte{
+				0x02,
+				0x7F, 0xFF, 0xFF,
+				0xFF, 0xFF, 0xFE,
+				0xFE, 0xFF, 0xFF,
+				0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80,
+				0x00, 0x00, 0x00, 0x01, 0x00,
+			},
+			value: wide{
+				Count:   2,
+				Samples: []int32{8388607, -2},
+				Little:  -2,
+				Unsign:  0x010203040506,
+				Long:    -36028797018963968,
+				Offset:  256,
+			},
+		},
+		{
+			data:  []byte{0x00, 0x00, 0x02, 'h', 'i'},
+			value: counted{Count: 2, Data: []byte("hi")},
+		},
+		{
+			data:  []byte{0x1A, 0xBC, 0xDE, 0xF2},
+			value: unaligned{Nibble: 1, Value: 0xABCDEF, Rest: 2},
+		},
+	}
+
+	for _, test := range tests {
+		v := reflect.New(reflect.TypeOf(test.value))
+
+		err := Unpack(test.data, binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.value, v.Elem().Interface())
+
+		data, err := Pack(binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.data, data)
+
+		size, err := SizeOf(v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, len(test.data), size)
+	}
+
+	_, err := Pack(binary.BigEndian, &wide{Samples: []int32{1 << 23}})
+	assert.EqualError(t, err, "*Samples: value 8388608 out of range for int24")
+
+	_, err = Pack(binary.BigEndian, &unaligned{Value: 1 << 24})
+	assert.EqualError(t, err, "Value: value 16777216 out of range for uint24")
+}
+
+func TestBigInt(t *testing.T) {
+	type addrs struct {
+		Words  [2]uint64 `struct:"uint128"`
+		Bytes  [16]byte  `struct:"uint128"`
+		Signed *big.Int  `struct:"int128,little"`
+		Value  big.Int   `struct:"biguint:72"`
+		Small  int64     `struct:"int128"`
+	}
+
+	type counted struct {
+		Count *big.Int `struct:"biguint:64,sizeof=Data"`
+		Data  []byte
+	}
+
+	type sized struct {
+		Len  *big.Int `struct:"uint128"`
+		Data []byte   `struct-size:"Len"`
+	}
+
+	type sizedWords struct {
+		Len  [2]uint64 `struct:"uint128"`
+		Data []byte    `struct:"size=Len*2"`
+	}
+
+	type sizedBytes struct {
+		Len  [16]byte `struct:"int128"`
+		Data []byte   `struct:"if=Len < 0,size=-Len"`
+	}
+
+	max72, _ := new(big.Int).SetString("ffffffffffffffffff", 16)
+
+	tests := []struct {
+		data  []byte
+		value interface{}
+	}{
+		{
+			data: []byte{
+				0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+				0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+				0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfd,
+			},
+			value: addrs{
+				Words:  [2]uint64{0x20010db800000000, 1},
+				Bytes:  [16]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+				Signed: big.NewInt(-2),
+				Value:  *max72,
+				Small:  -3,
+			},
+		},
+		{
+			data:  []byte{0, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'},
+			value: counted{Count: big.NewInt(2), Data: []byte("hi")},
+		},
+		{
+			data: []byte{
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3,
+				'a', 'b', 'c',
+			},
+			value: sized{Len: big.NewInt(3), Data: []byte("abc")},
+		},
+		{
+			data: []byte{
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+				'a', 'b', 'c', 'd',
+			},
+			value: sizedWords{Len: [2]uint64{0, 2}, Data: []byte("abcd")},
+		},
+		{
+			data: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe,
+				'a', 'b',
+			},
+			value: sizedBytes{
+				Len: [16]byte{
+					0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+					0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe,
+				},
+				Data: []byte("ab"),
+			},
+		},
+	}
+
+	EnableExprBeta()
+
+	for _, test := range tests {
+		v := reflect.New(reflect.TypeOf(test.value))
+
+		err := Unpack(test.data, binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.value, v.Elem().Interface())
+
+		data, err := Pack(binary.BigEndian, v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, test.data, data)
+
+		size, err := SizeOf(v.Interface())
+		assert.Nil(t, err)
+		assert.Equal(t, len(test.data), size)
+	}
+
+	type small struct {
+		Value int8 `struct:"int128"`
+	}
+	err := Unpack(append(make([]byte, 15), 0xff), binary.BigEndian, &small{})
+	assert.EqualError(t, err, "Value: value 255 overflows int8")
+
+	_, err = Pack(binary.BigEndian, &addrs{Signed: new(big.Int).Lsh(big.NewInt(1), 127)})
+	assert.EqualError(t, err, "Signed: value 170141183460469231731687303715884105728 out of range for int128")
+}
+
+func TestHalfFloat(t *testing.T) {
+	type halves struct {
+		Half   float32 `struct:"float16"`
+		Brain  float64 `struct:"bfloat16"`
+		Little float32 `struct:"float16,little"`
+	}
+
+	tests := []struct {
+		value float64
+		half  uint16
+		brain uint16
+	}{
+		{0, 0x0000, 0x0000},
+		{math.Copysign(0, -1), 0x8000, 0x8000},
+		{1, 0x3C00, 0x3F80},
+		{-2, 0xC000, 0xC000},
+		{256, 0x5C00, 0x4380},
+		{math.Inf(1), 0x7C00, 0x7F80},
+		{math.Inf(-1), 0xFC00, 0xFF80},
+		{math.Ldexp(1, -24), 0x0001, 0x3380},
+		{math.Ldexp(1, -14), 0x0400, 0x3880},
+		{math.Ldexp(1, -126), 0x0000, 0x0080},
+	}
+
+	for _, test := range tests {
+		data := []byte{
+			byte(test.half >> 8), byte(test.half),
+			byte(test.brain >> 8), byte(test.brain),
+			byte(test.half), byte(test.half >> 8),
+		}
+		h := halves{}
+		err := Unpack(data, binary.BigEndian, &h)
+		assert.Nil(t, err)
+
+		if test.half != 0 || test.value == 0 {
+			assert.Equal(t, float32(test.value), h.Half)
+			assert.Equal(t, math.Signbit(test.value), math.Signbit(float64(h.Half)))
+		}
+		assert.Equal(t, test.value, h.Brain)
+
+		packed, err := Pack(binary.BigEndian, &halves{float32(test.value), test.value, float32(test.value)})
+		assert.Nil(t, err)
+		assert.Equal(t, data, packed)
+	}
+
+	rounding := []struct {
+		value float64
+		half  uint16
+	}{
+		{1 + math.Ldexp(1, -11), 0x3C00},
+		{1 + math.Ldexp(3, -11), 0x3C02},
+		{1 + math.Ldexp(1, -11) + math.Ldexp(1, -20), 0x3C01},
+		{65504, 0x7BFF},
+		{65519, 0x7BFF},
+		{65520, 0x7C00},
+		{1e10, 0x7C00},
+		{math.Ldexp(1, -25), 0x0000},
+		{math.Ldexp(3, -26), 0x0001},
+		{math.Ldexp(1023, -24) + math.Ldexp(1, -25), 0x0400},
+		{math.NaN(), 0x7E00},
+	}
+
+	for _, test := range rounding {
+		packed, err := Pack(binary.BigEndian, &struct {
+			Value float64 `struct:"float16"`
+		}{test.value})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(test.half >> 8), byte(test.half)}, packed)
+	}
+
+	nan := struct {
+		Value float32 `struct:"float16"`
+	}{}
+	err := Unpack([]byte{0x7E, 0x00}, binary.BigEndian, &nan)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(float64(nan.Value)))
+
+	type weights struct {
+		Count  uint8     `struct:"sizeof=Values"`
+		Values []float32 `struct:"[]float16,little"`
+		Fixed  []float64 `struct:"[2]bfloat16"`
+	}
+
+	data := []byte{0x02, 0x00, 0x3C, 0x00, 0xC0, 0x3F, 0x80, 0x40, 0x49}
+	w := weights{}
+	err = Unpack(data, binary.BigEndian, &w)
+	assert.Nil(t, err)
+	assert.Equal(t, weights{Count: 2, Values: []float32{1, -2}, Fixed: []float64{1, 3.140625}}, w)
+
+	packed, err := Pack(binary.BigEndian, &w)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	size, err := SizeOf(&w)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), size)
+}
+
+func TestFixedPoint(t *testing.T) {
+	type glyph struct {
+		Version float64   `struct:"fixed=16.16"`
+		Scale   float32   `struct:"int16,fixed=2.14"`
+		Gain    float64   `struct:"uint8,fixed=Q4.4"`
+		Sample  float64   `struct:"int16,fixed=Q15,little"`
+		Coeffs  []float64 `struct:"[2]int8,fixed=Q1.6"`
+		Offset  float64   `struct:"int24,fixed=12.12"`
+	}
+
+	data := []byte{
+		0x00, 0x01, 0x80, 0x00,
+		0xC0, 0x00,
+		0x28,
+		0x00, 0xC0,
+		0x20, 0xE0,
+		0xFF, 0xF8, 0x00,
+	}
+	value := glyph{
+		Version: 1.5,
+		Scale:   -1,
+		Gain:    2.5,
+		Sample:  -0.5,
+		Coeffs:  []float64{0.5, -0.5},
+		Offset:  -0.5,
+	}
+
+	g := glyph{}
+	err := Unpack(data, binary.BigEndian, &g)
+	assert.Nil(t, err)
+	assert.Equal(t, value, g)
+
+	packed, err := Pack(binary.BigEndian, &g)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	rounding := []struct {
+		tag   string
+		value float64
+		raw   int8
+		err   string
+	}{
+		{"even", 0.5 / 64, 0, ""},
+		{"even", 1.5 / 64, 2, ""},
+		{"even", -2.5 / 64, -2, ""},
+		{"nearest", 0.5 / 64, 1, ""},
+		{"nearest", -0.5 / 64, -1, ""},
+		{"nearest", 0.49999999999999994 / 64, 0, ""},
+		{"even", -0.49999999999999994 / 64, 0, ""},
+		{"floor", -0.01, -1, ""},
+		{"ceil", 0.01, 1, ""},
+		{"trunc", -0.07, -4, ""},
+		{"even", 2, 0, "Value: value 2 out of range for fixed 2.6"},
+		{"even", -2.01, 0, "Value: value -2.01 out of range for fixed 2.6"},
+		{"even", math.NaN(), 0, "Value: cannot store NaN as fixed 2.6"},
+	}
+
+	for _, test := range rounding {
+		typ := reflect.StructOf([]reflect.StructField{{
+			Name: "Value",
+			Type: reflect.TypeOf(float64(0)),
+			Tag:  reflect.StructTag(`struct:"int8,fixed=2.6,round=` + test.tag + `"`),
+		}})
+		v := reflect.New(typ)
+		v.Elem().Field(0).SetFloat(test.value)
+		packed, err := Pack(binary.BigEndian, v.Interface())
+		if test.err != "" {
+			assert.EqualError(t, err, test.err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(test.raw)}, packed)
+	}
+
+	type saturated struct {
+		Low  float64 `struct:"int8,fixed=4.4,saturate"`
+		High float64 `struct:"uint8,fixed=4.4,saturate"`
+	}
+
+	packed, err = Pack(binary.BigEndian, &saturated{Low: -100, High: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x80, 0xFF}, packed)
+
+	type mismatched struct {
+		Value float64 `struct:"int16,fixed=8.4"`
+	}
+	_, err = Pack(binary.BigEndian, &mismatched{})
+	assert.EqualError(t, err, "fixed 8.4 does not fit 16-bit type of Value")
+
+	type nonInteger struct {
+		Value float64 `struct:"float32,fixed=8.8"`
+	}
+	_, err = Pack(binary.BigEndian, &nonInteger{})
+	assert.Equal(t, ErrInvalidFixed, err)
+}
+
+func TestFixedType(t *testing.T) {
+	type glyph struct {
+		Version Fixed   `struct:"fixed=16.16"`
+		Scale   Fixed   `struct:"int16,fixed=2.14"`
+		Gain    Fixed   `struct:"uint8,fixed=Q4.4"`
+		Coeffs  []Fixed `struct:"[2]int8,fixed=Q1.6"`
+		Offset  Fixed   `struct:"int24,fixed=12.12"`
+		Time    Fixed   `struct:"uint64,fixed=32.32"`
+	}
+
+	data := []byte{
+		0x00, 0x01, 0x80, 0x00,
+		0xC0, 0x00,
+		0x28,
+		0x20, 0xE0,
+		0xFF, 0xF8, 0x00,
+		0x7F, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x01,
+	}
+	value := glyph{
+		Version: Fixed{Raw: 0x18000, Frac: 16},
+		Scale:   Fixed{Raw: -0x4000, Frac: 14},
+		Gain:    Fixed{Raw: 0x28, Frac: 4},
+		Coeffs:  []Fixed{{Raw: 32, Frac: 6}, {Raw: -32, Frac: 6}},
+		Offset:  Fixed{Raw: -0x800, Frac: 12},
+		Time:    Fixed{Raw: 0x7FFFFFFF00000001, Frac: 32},
+	}
+
+	g := glyph{}
+	err := Unpack(data, binary.BigEndian, &g)
+	assert.Nil(t, err)
+	assert.Equal(t, value, g)
+	assert.Equal(t, 1.5, g.Version.Float64())
+	assert.Equal(t, "-0.5", g.Offset.String())
+
+	packed, err := Pack(binary.BigEndian, &g)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	rescaling := []struct {
+		tag   string
+		value Fixed
+		raw   int8
+		err   string
+	}{
+		{"even", Fixed{Raw: 3, Frac: 1}, 96, ""},
+		{"even", NewFixed(-0.25, 6), -16, ""},
+		{"even", Fixed{Raw: 5, Frac: 8}, 1, ""},
+		{"even", Fixed{Raw: 6, Frac: 8}, 2, ""},
+		{"even", Fixed{Raw: -10, Frac: 8}, -2, ""},
+		{"nearest", Fixed{Raw: 2, Frac: 8}, 1, ""},
+		{"nearest", Fixed{Raw: -2, Frac: 8}, -1, ""},
+		{"floor", Fixed{Raw: -1, Frac: 8}, -1, ""},
+		{"ceil", Fixed{Raw: 1, Frac: 8}, 1, ""},
+		{"trunc", Fixed{Raw: -7, Frac: 8}, -1, ""},
+		{"even", Fixed{Raw: 2, Frac: 0}, 0, "Value: value 2 out of range for fixed 2.6"},
+		{"even", Fixed{Raw: -129, Frac: 6}, 0, "Value: value -2.015625 out of range for fixed 2.6"},
+	}
+
+	for _, test := range rescaling {
+		typ := reflect.StructOf([]reflect.StructField{{
+			Name: "Value",
+			Type: reflect.TypeOf(Fixed{}),
+			Tag:  reflect.StructTag(`struct:"int8,fixed=2.6,round=` + test.tag + `"`),
+		}})
+		v := reflect.New(typ)
+		v.Elem().Field(0).Set(reflect.ValueOf(test.value))
+		packed, err := Pack(binary.BigEndian, v.Interface())
+		if test.err != "" {
+			assert.EqualError(t, err, test.err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(test.raw)}, packed)
+	}
+
+	type saturated struct {
+		Low  Fixed `struct:"int8,fixed=4.4,saturate"`
+		High Fixed `struct:"uint8,fixed=4.4,saturate"`
+	}
+
+	packed, err = Pack(binary.BigEndian, &saturated{Low: NewFixed(-100, 0), High: NewFixed(100, 0)})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x80, 0xFF}, packed)
+
+	type overflow struct {
+		Value Fixed `struct:"uint64,fixed=0.64"`
+	}
+	err = Unpack([]byte{0x80, 0, 0, 0, 0, 0, 0, 0}, binary.BigEndian, &overflow{})
+	assert.EqualError(t, err, "Value: value 9223372036854775808 overflows restruct.Fixed")
+
+	type untagged struct {
+		Value Fixed
+	}
+	_, err = Pack(binary.BigEndian, &untagged{})
+	assert.EqualError(t, err, "Value: Fixed requires a fixed point format")
+}
+
+func TestDecimal(t *testing.T) {
+	type record struct {
+		Amount  int64  `struct:"[4]byte,packed-decimal"`
+		Count   uint16 `struct:"[2]byte,comp-3"`
+		Date    uint32 `struct:"[3]byte,bcd"`
+		ICCID   string `struct:"[4]byte,bcd=swapped"`
+		Account string `struct:"[3]byte,packed-decimal"`
+	}
+
+	data := []byte{
+		0x00, 0x12, 0x34, 0x5D,
+		0x04, 0x2F,
+		0x24, 0x10, 0x18,
+		0x98, 0x10, 0x32, 0xF4,
+		0x00, 0x12, 0x3C,
+	}
+	value := record{
+		Amount:  -12345,
+		Count:   42,
+		Date:    241018,
+		ICCID:   "8901234",
+		Account: "00123",
+	}
+
+	r := record{}
+	err := Unpack(data, binary.BigEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, r)
+
+	packed, err := Pack(binary.BigEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	size, err := SizeOf(&r)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), size)
+
+	type single struct {
+		Value int8 `struct:"[2]byte,bcd"`
+	}
+
+	failures := []struct {
+		data []byte
+		err  string
+	}{
+		{[]byte{0x1A, 0x00}, "Value: invalid decimal digit nibble 0xa"},
+		{[]byte{0x01, 0x28}, "Value: value 128 overflows int8"},
+	}
+
+	for _, test := range failures {
+		err := Unpack(test.data, binary.BigEndian, &single{})
+		assert.EqualError(t, err, test.err)
+	}
+
+	_, err = Pack(binary.BigEndian, &single{Value: -1})
+	assert.EqualError(t, err, "Value: negative value -1 cannot be stored as BCD")
+
+	_, err = Pack(binary.BigEndian, &record{Amount: 12345678})
+	assert.EqualError(t, err, "Amount: value has 8 digits, but only 7 fit")
+
+	_, err = Pack(binary.BigEndian, &record{ICCID: "12a"})
+	assert.EqualError(t, err, "ICCID: invalid decimal digit 'a'")
+
+	err = Unpack([]byte{0x00, 0x12, 0x34, 0x56}, binary.BigEndian, &r)
+	assert.EqualError(t, err, "Amount: invalid packed decimal sign nibble 0x6")
+
+	type invalid struct {
+		Value float64 `struct:"[2]byte,bcd"`
+	}
+	_, err = Pack(binary.BigEndian, &invalid{})
+	assert.Equal(t, ErrInvalidDecimal, err)
+}
+
+func TestTime(t *testing.T) {
+	type header struct {
+		Unix     time.Time     `struct:"uint32,time=unix"`
+		UnixMs   time.Time     `struct:"time=unixms"`
+		DOS      time.Time     `struct:"time=dos"`
+		Filetime time.Time     `struct:"time=filetime"`
+		NTP      time.Time     `struct:"time=ntp"`
+		HFS      time.Time     `struct:"time=hfs"`
+		GPS      time.Time     `struct:"time=gpsweek"`
+		Timeout  time.Duration `struct:"uint16,time=unixms"`
+	}
+
+	noon := time.Date(2024, 10, 18, 12, 0, 0, 0, time.UTC)
+	data := []byte{
+		0xc0, 0x4d, 0x12, 0x67,
+		0x7b, 0xb6, 0x7f, 0x9f, 0x92, 0x01, 0x00, 0x00,
+		0x5c, 0x64, 0x52, 0x59,
+		0x40, 0x2b, 0x40, 0x42, 0x55, 0x21, 0xdb, 0x01,
+		0x00, 0x00, 0x00, 0x80, 0x40, 0xcc, 0xbc, 0xea,
+		0x40, 0xfe, 0x37, 0xe3,
+		0x40, 0x40, 0x07, 0x00, 0x20, 0x09,
+		0xdc, 0x05,
+	}
+	value := header{
+		Unix:     noon,
+		UnixMs:   noon.Add(123 * time.Millisecond),
+		DOS:      time.Date(2024, 10, 18, 12, 34, 56, 0, time.UTC),
+		Filetime: noon.Add(500 * time.Millisecond),
+		NTP:      noon.Add(500 * time.Millisecond),
+		HFS:      noon,
+		GPS:      noon,
+		Timeout:  1500 * time.Millisecond,
+	}
+
+	h := header{}
+	err := Unpack(data, binary.LittleEndian, &h)
+	assert.Nil(t, err)
+	assert.Equal(t, value, h)
+
+	packed, err := Pack(binary.LittleEndian, &h)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	size, err := SizeOf(&h)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), size)
+
+	type stamps struct {
+		Count  uint8         `struct:"sizeof=Stamps"`
+		Stamps []time.Time   `struct:"[]int32,time=unix"`
+		Zero   time.Time     `struct:"time=dos"`
+		Delta  time.Duration `struct:"int16,time=unix"`
+	}
+
+	s := stamps{
+		Count:  2,
+		Stamps: []time.Time{time.Unix(0, 0).UTC(), time.Unix(-1, 0).UTC()},
+		Delta:  -30 * time.Second,
+	}
+	data = []byte{
+		0x02,
+		0x00, 0x00, 0x00, 0x00,
+		0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00,
+		0xe2, 0xff,
+	}
+
+	packed, err = Pack(binary.LittleEndian, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	r := stamps{}
+	err = Unpack(data, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, s, r)
+
+	_, err = Pack(binary.LittleEndian, &header{})
+	assert.EqualError(t, err, "Unix: 0001-01-01 00:00:00 +0000 UTC out of range for time encoding")
+
+	_, err = Pack(binary.LittleEndian, &header{Unix: noon, UnixMs: noon, DOS: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.EqualError(t, err, "DOS: 1970-01-01 00:00:00 +0000 UTC out of range for time encoding")
+
+	type invalid struct {
+		Value int64 `struct:"time=unix"`
+	}
+	_, err = Pack(binary.LittleEndian, &invalid{})
+	assert.Equal(t, ErrInvalidTime, err)
+
+	type invalidDuration struct {
+		Value time.Duration `struct:"time=dos"`
+	}
+	_, err = Pack(binary.LittleEndian, &invalidDuration{})
+	assert.Equal(t, ErrInvalidTime, err)
+}
+
+type upperEncoding struct{}
+
+func (upperEncoding) Encode(s string) ([]byte, error) { return []byte(strings.ToUpper(s)), nil }
+func (upperEncoding) Decode(b []byte) (string, error) { return strings.ToLower(string(b)), nil }
+func (upperEncoding) UnitSize() int                   { return 1 }
+
+func TestEncoding(t *testing.T) {
+	type resource struct {
+		Name   string `struct:"[8]byte,encoding=utf16le"`
+		Length uint8  `struct:"sizeof=Title"`
+		Title  string `struct:"encoding=utf16be"`
+		Label  string `struct:"encoding=utf16le,terminator=$'\\x00\\x00'"`
+		Tail   string `struct:"encoding=latin1,rest"`
+	}
+
+	data := []byte{
+		0x48, 0x00, 0x69, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x03,
+		0x00, 0xe9, 0xd8, 0x3d, 0xde, 0x00,
+		0x41, 0x00, 0x00, 0x01, 0x00, 0x00,
+		0x63, 0x61, 0x66, 0xe9,
+	}
+	value := resource{
+		Name:   "Hi",
+		Length: 3,
+		Title:  "é😀",
+		Label:  "AĀ",
+		Tail:   "café",
+	}
+
+	r := resource{}
+	err := Unpack(data, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, r)
+
+	packed, err := Pack(binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	size, err := SizeOf(&r)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), size)
+
+	type ascii struct {
+		Value string `struct:"[4]byte,encoding=ascii"`
+	}
+
+	err = Unpack([]byte{0x61, 0x80, 0x00, 0x00}, binary.LittleEndian, &ascii{})
+	assert.EqualError(t, err, "Value: invalid ASCII byte 0x80")
+
+	_, err = Pack(binary.LittleEndian, &ascii{Value: "é"})
+	assert.EqualError(t, err, "Value: character 'é' cannot be encoded in ASCII")
+
+	// Fixed strings end at the first NUL code unit, and the bytes after it
+	// are not decoded.
+	type fixed struct {
+		UTF8  string `struct:"[8]byte,encoding=utf8"`
+		UTF16 string `struct:"[6]byte,encoding=utf16le"`
+	}
+
+	f := fixed{}
+	err = Unpack([]byte{
+		'a', 'b', 0x00, 0xff, 0xfe, 0xfd, 0xfc, 0xfb,
+		'c', 0x00, 0x00, 0x00, 0x00, 0xd8,
+	}, binary.LittleEndian, &f)
+	assert.Nil(t, err)
+	assert.Equal(t, fixed{UTF8: "ab", UTF16: "c"}, f)
+
+	err = Unpack([]byte{
+		'a', 'b', 0xff, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}, binary.LittleEndian, &f)
+	assert.EqualError(t, err, "UTF8: invalid UTF-8")
+
+	type shiftJIS struct {
+		Length uint8  `struct:"sizeof=Title"`
+		Title  string `struct:"encoding=shift-jis"`
+		Code   string `struct:"[4]byte,encoding=sjis"`
+	}
+
+	sjis := []byte{
+		0x0a,
+		0x93, 0xfa, 0x96, 0x7b, 0x8c, 0xea, 0xb1, 0x5c, 0x87, 0x40,
+		0xfa, 0x5c, 0xf0, 0x40,
+	}
+	sjisValue := shiftJIS{Length: 10, Title: "日本語ｱ\\①", Code: "纊\ue000"}
+
+	j := shiftJIS{}
+	err = Unpack(sjis, binary.LittleEndian, &j)
+	assert.Nil(t, err)
+	assert.Equal(t, sjisValue, j)
+
+	packed, err = Pack(binary.LittleEndian, &j)
+	assert.Nil(t, err)
+	assert.Equal(t, sjis, packed)
+
+	packed, err = Pack(binary.LittleEndian, &shiftJIS{Title: "¥−"})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x03, 0x5c, 0x81, 0x7c, 0, 0, 0, 0}, packed)
+
+	_, err = Pack(binary.LittleEndian, &shiftJIS{Title: "한"})
+	assert.EqualError(t, err, "Title: character '한' cannot be encoded in Shift-JIS")
+
+	err = Unpack([]byte{0x02, 0x85, 0x40, 0, 0, 0, 0}, binary.LittleEndian, &shiftJIS{})
+	assert.EqualError(t, err, "Title: invalid Shift-JIS character 0x85 0x40")
+
+	err = Unpack([]byte{0x01, 0x93, 0, 0, 0, 0}, binary.LittleEndian, &shiftJIS{})
+	assert.EqualError(t, err, "Title: truncated Shift-JIS character 0x93")
+
+	err = Unpack([]byte{0x01, 0xfd, 0, 0, 0, 0}, binary.LittleEndian, &shiftJIS{})
+	assert.EqualError(t, err, "Title: invalid Shift-JIS byte 0xfd")
+
+	RegisterEncoding("Upper", upperEncoding{})
+	type custom struct {
+		Value string `struct:"[4]byte,encoding=upper"`
+	}
+
+	packed, err = Pack(binary.LittleEndian, &custom{Value: "abc"})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ABC\x00"), packed)
+
+	c := custom{}
+	err = Unpack(packed, binary.LittleEndian, &c)
+	assert.Nil(t, err)
+	assert.Equal(t, custom{Value: "abc"}, c)
+
+	type odd struct {
+		Value string `struct:"[3]byte,encoding=utf16le"`
+	}
+	_, err = Pack(binary.LittleEndian, &odd{})
+	assert.Equal(t, ErrInvalidEncoding, err)
+
+	type invalid struct {
+		Value []byte `struct:"encoding=latin1"`
+	}
+	_, err = Pack(binary.LittleEndian, &invalid{})
+	assert.Equal(t, ErrInvalidEncoding, err)
+}
+
+func TestPadding(t *testing.T) {
+	type volume struct {
+		ID    string `struct:"[8]byte,pad=' '"`
+		Size  string `struct:"[6]byte,pad='0',trim=left"`
+		Raw   string `struct:"[4]byte,trim=none"`
+		Label string `struct:"[6]byte,encoding=utf16le,pad=$' \\x00'"`
+	}
+
+	data := []byte("CDROM   000042ab\x00\x00A\x00 \x00 \x00")
+	value := volume{
+		ID:    "CDROM",
+		Size:  "42",
+		Raw:   "ab\x00\x00",
+		Label: "A",
+	}
+
+	v := volume{}
+	err := Unpack(data, binary.LittleEndian, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, value, v)
+
+	packed, err := Pack(binary.LittleEndian, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	packed, err = Pack(binary.LittleEndian, &volume{Raw: "ab"})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("        000000ab\x00\x00 \x00 \x00 \x00"), packed)
+
+	type both struct {
+		Value string `struct:"[8]byte,pad=' ',trim=both"`
+	}
+	b := both{}
+	err = Unpack([]byte("  abc   "), binary.LittleEndian, &b)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", b.Value)
+
+	_, err = Pack(binary.LittleEndian, &volume{ID: "CDROM-XA1"})
+	assert.EqualError(t, err, "ID: length 9 exceeds fixed size 8")
+
+	type plain struct {
+		Value string `struct:"[2]byte"`
+	}
+	_, err = Pack(binary.LittleEndian, &plain{Value: "abc"})
+	assert.EqualError(t, err, "Value: length 3 exceeds fixed size 2")
+
+	type invalid struct {
+		Value []byte `struct:"pad=' '"`
+	}
+	_, err = Pack(binary.LittleEndian, &invalid{})
+	assert.Equal(t, ErrInvalidPadding, err)
+}
+
+func TestASCIINumber(t *testing.T) {
+	type header struct {
+		Mode  int64  `struct:"[8]byte,ascii=octal,terminator=0"`
+		Size  uint64 `struct:"[12]byte,ascii=octal,terminator=0"`
+		UID   int    `struct:"[8]byte,ascii=octal,pad=' ',trim=left,terminator=' '"`
+		Name  uint16 `struct:"[10]byte,ascii=decimal,pad=' '"`
+		Inode uint32 `struct:"[8]byte,ascii=hex"`
+		Delta int16  `struct:"[5]byte,ascii=decimal"`
+	}
+
+	data := []byte("0000644\x0000000002322\x00   1750 42        00000abc-0042")
+	value := header{
+		Mode:  0644,
+		Size:  1234,
+		UID:   1000,
+		Name:  42,
+		Inode: 0xabc,
+		Delta: -42,
+	}
+
+	h := header{}
+	err := Unpack(data, binary.BigEndian, &h)
+	assert.Nil(t, err)
+	assert.Equal(t, value, h)
+
+	packed, err := Pack(binary.BigEndian, &h)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	size, err := SizeOf(&h)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), size)
+
+	// Older tar implementations pad with spaces instead of zeros.
+	err = Unpack([]byte("   644 \x00      2322 \x00"+string(data[20:])), binary.BigEndian, &h)
+	assert.Nil(t, err)
+	assert.Equal(t, value, h)
+
+	_, err = Pack(binary.BigEndian, &header{Mode: 077777777})
+	assert.EqualError(t, err, "Mode: value 77777777 does not fit in 7 octal digits")
+
+	err = Unpack([]byte("0000009\x00"+string(data[8:])), binary.BigEndian, &h)
+	assert.EqualError(t, err, "Mode: invalid octal number \"0000009\"")
+
+	type invalid struct {
+		Value string `struct:"[8]byte,ascii=octal"`
+	}
+	_, err = Pack(binary.BigEndian, &invalid{})
+	assert.Equal(t, ErrInvalidASCII, err)
+}
+
+func TestUUID(t *testing.T) {
+	EnableExprBeta()
+
+	type record struct {
+		ID   [16]byte `struct:"uuid"`
+		Type [16]byte `struct:"guid"`
+		Name string   `struct:"guid"`
+		Body struct {
+			Short uint16 `struct:"case=uuid(\"00112233-4455-6677-8899-AABBCCDDEEFF\")"`
+			Long  uint32 `struct:"default"`
+		} `struct:"switch=Type"`
+	}
+
+	data := []byte{
+		0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8,
+		0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		0x2a, 0x00,
+	}
+	value := record{
+		ID:   [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8},
+		Type: [16]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		Name: "00112233-4455-6677-8899-aabbccddeeff",
+	}
+	value.Body.Short = 42
+
+	r := record{}
+	err := Unpack(data, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, r)
+
+	packed, err := Pack(binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	r.Name = "{00112233-4455-6677-8899-AABBCCDDEEFF}"
+	packed, err = Pack(binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	r.Name = "00112233-4455-6677-8899"
+	_, err = Pack(binary.LittleEndian, &r)
+	assert.EqualError(t, err, "Name: invalid UUID \"00112233-4455-6677-8899\"")
+
+	type invalid struct {
+		Value [8]byte `struct:"uuid"`
+	}
+	_, err = Pack(binary.LittleEndian, &invalid{})
+	assert.Equal(t, ErrInvalidUUID, err)
+}
+
+func TestConstantSize(t *testing.T) {
+	EnableExprBeta()
+
+	type entry struct {
+		Tag  [2]byte
+		Data []byte `struct:"size=2*2"`
+	}
+	type table struct {
+		Entries []entry `struct:"rest"`
+	}
+
+	data := []byte{
+		'a', 'b', 0x01, 0x02, 0x03, 0x04,
+		'c', 'd', 0x05, 0x06, 0x07, 0x08,
+	}
+	value := table{Entries: []entry{
+		{Tag: [2]byte{'a', 'b'}, Data: []byte{0x01, 0x02, 0x03, 0x04}},
+		{Tag: [2]byte{'c', 'd'}, Data: []byte{0x05, 0x06, 0x07, 0x08}},
+	}}
+
+	assert.True(t, isTypeTrivial(reflect.TypeOf(entry{})))
+
+	size, err := SizeOf(&value)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), size)
+
+	v := table{}
+	err = Unpack(data, binary.LittleEndian, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, value, v)
+
+	packed, err := Pack(binary.LittleEndian, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+}
+
+func TestRegisterExpr(t *testing.T) {
+	EnableExprBeta()
+
+	assert.Nil(t, RegisterExprFunc("testAlign", func(x, n int) int { return (x + n - 1) / n * n }))
+	assert.Nil(t, RegisterExprConst("testVersion", uint8(2)))
+	assert.Nil(t, RegisterExprPackage("testKinds", map[string]interface{}{
+		"Short": uint8(1),
+		"Long":  uint8(2),
+		"Size": func(kind uint8) int {
+			return int(kind) * 2
+		},
+	}))
+
+	type record struct {
+		Version uint8
+		Kind    uint8
+		Name    []byte `struct:"size=testAlign(3, 4)"`
+		Value   []byte `struct:"size=testKinds.Size(Kind)"`
+		Extra   uint16 `struct:"if=Version >= testVersion && Kind == testKinds.Long"`
+	}
+
+	data := []byte{0x02, 0x02, 'a', 'b', 'c', 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	value := record{
+		Version: 2,
+		Kind:    2,
+		Name:    []byte{'a', 'b', 'c', 0x00},
+		Value:   []byte{0x01, 0x02, 0x03, 0x04},
+		Extra:   0x0605,
+	}
+
+	r := record{}
+	err := Unpack(data, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, r)
+
+	packed, err := Pack(binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	// Exported fields shadow registered names, while unexported ones are
+	// not visible to expressions.
+	assert.Nil(t, RegisterExprConst("TestShadowed", 4))
+	type shadowed struct {
+		TestShadowed uint8
+		testVersion  uint8
+		Data         []byte `struct:"size=TestShadowed"`
+		Extra        []byte `struct:"size=testVersion"`
+	}
+
+	s := shadowed{}
+	err = Unpack([]byte{1, 'a', 'b', 'c'}, binary.LittleEndian, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, shadowed{TestShadowed: 1, Data: []byte("a"), Extra: []byte("bc")}, s)
+
+	tests := []struct {
+		err      error
+		expected error
+	}{
+		{RegisterExprConst("testVersion", 3), ErrExprNameInUse},
+		{RegisterExprConst("bits", 3), ErrExprNameInUse},
+		{RegisterExprConst("_eof", 3), ErrExprNameInUse},
+		{RegisterExprConst("int", 3), ErrExprNameInUse},
+		{RegisterExprConst("len", 3), ErrExprNameInUse},
+		{RegisterExprConst("strings", 3), ErrExprNameInUse},
+		{RegisterExprConst("nil", 3), ErrInvalidExprName},
+		{RegisterExprConst("a.b", 3), ErrInvalidExprName},
+		{RegisterExprConst("1a", 3), ErrInvalidExprName},
+		{RegisterExprConst("testChan", make(chan int)), ErrInvalidExprConst},
+		{RegisterExprConst("testNil", nil), ErrInvalidExprConst},
+		{RegisterExprFunc("testNotFunc", 1), ErrInvalidExprFunc},
+		{RegisterExprFunc("testNoResult", func() {}), ErrInvalidExprFunc},
+		{RegisterExprFunc("testTwoResults", func() (int, int) { return 0, 0 }), ErrInvalidExprFunc},
+		{RegisterExprFunc("testVariadic", func(x ...int) int { return 0 }), ErrInvalidExprFunc},
+		{RegisterExprFunc("testChanArg", func(c chan int) int { return 0 }), ErrInvalidExprFunc},
+		{RegisterExprPackage("testBadPkg", map[string]interface{}{"F": func() {}}), ErrInvalidExprFunc},
+		{RegisterExprPackage("testBadMember", map[string]interface{}{"a b": 1}), ErrInvalidExprName},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.err)
+	}
+}
+
+func TestExprEnv(t *testing.T) {
+	EnableExprBeta()
+
+	env := NewExprEnv()
+	assert.Nil(t, env.RegisterConst("testEnvLen", 2))
+	assert.Nil(t, env.RegisterFunc("testEnvDouble", func(x uint8) int { return int(x) * 2 }))
+	assert.Nil(t, env.RegisterPackage("testEnvKinds", map[string]interface{}{"Long": uint8(2)}))
+
+	type record struct {
+		Kind uint8
+		Name []byte `struct:"size=testEnvLen"`
+		Data []byte `struct:"size=Kind == testEnvKinds.Long ? testEnvDouble(Kind) : 0"`
+	}
+
+	data := []byte{0x02, 'a', 'b', 0x01, 0x02, 0x03, 0x04}
+	value := record{Kind: 2, Name: []byte("ab"), Data: []byte{1, 2, 3, 4}}
+
+	r := record{}
+	assert.Nil(t, env.Unpack(data, binary.LittleEndian, &r))
+	assert.Equal(t, value, r)
+
+	packed, err := env.Pack(binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+
+	size, err := env.SizeOf(&r)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, size)
+
+	size, err = env.BitSize(&r)
+	assert.Nil(t, err)
+	assert.Equal(t, 56, size)
+
+	// Names in an environment are not visible outside of it.
+	err = Unpack(data, binary.LittleEndian, &record{})
+	assert.EqualError(t, err, "restruct.record.Name: size: col 1: undefined: testEnvLen; "+
+		"restruct.record.Data: size: col 9: undefined: testEnvKinds; "+
+		"restruct.record.Data: size: col 29: undefined: testEnvDouble")
+
+	// Names in an environment take precedence over global ones, and each
+	// environment resolves its own names.
+	assert.Nil(t, RegisterExprConst("testEnvShadowed", 1))
+	assert.Nil(t, env.RegisterConst("testEnvShadowed", 3))
+	other := NewExprEnv()
+	assert.Nil(t, other.RegisterConst("testEnvLen", 1))
+
+	type shadowed struct {
+		Data []byte `struct:"size=testEnvShadowed"`
+	}
+
+	s := shadowed{}
+	assert.Nil(t, Unpack([]byte("abc"), binary.LittleEndian, &s))
+	assert.Equal(t, []byte("a"), s.Data)
+	assert.Nil(t, env.Unpack([]byte("abc"), binary.LittleEndian, &s))
+	assert.Equal(t, []byte("abc"), s.Data)
+	assert.Nil(t, other.Unpack([]byte("abc"), binary.LittleEndian, &s))
+	assert.Equal(t, []byte("a"), s.Data)
+
+	type sized struct {
+		Data []byte `struct:"size=testEnvLen"`
+	}
+
+	z := sized{}
+	assert.Nil(t, other.Unpack([]byte("abc"), binary.LittleEndian, &z))
+	assert.Equal(t, []byte("a"), z.Data)
+	assert.Nil(t, env.Unpack([]byte("abc"), binary.LittleEndian, &z))
+	assert.Equal(t, []byte("ab"), z.Data)
+
+	// Checks that fail are not cached, so names can be registered after a
+	// type is first used, and checks are cached by their environment.
+	type late struct {
+		N    uint8
+		Data []byte `struct:"size=testEnvLate(N)"`
+	}
+
+	err = Unpack([]byte{1, 'a', 'b'}, binary.LittleEndian, &late{})
+	assert.EqualError(t, err, "restruct.late.Data: size: col 1: undefined: testEnvLate")
+	assert.Nil(t, RegisterExprFunc("testEnvLate", func(n uint8) int { return int(n) * 2 }))
+
+	l := late{}
+	assert.Nil(t, Unpack([]byte{1, 'a', 'b'}, binary.LittleEndian, &l))
+	assert.Equal(t, late{N: 1, Data: []byte("ab")}, l)
+
+	scoped := NewExprEnv()
+	assert.Nil(t, scoped.Unpack([]byte{1, 'a', 'b'}, binary.LittleEndian, &l))
+	assert.Contains(t, scoped.checks, reflect.TypeOf(late{}))
+	assert.Nil(t, scoped.RegisterConst("testEnvScoped", 1))
+	assert.Empty(t, scoped.checks)
+
+	tests := []struct {
+		err      error
+		expected error
+	}{
+		{env.RegisterConst("testEnvLen", 3), ErrExprNameInUse},
+		{env.RegisterConst("_eof", 3), ErrExprNameInUse},
+		{env.RegisterConst("len", 3), ErrExprNameInUse},
+		{env.RegisterConst("a.b", 3), ErrInvalidExprName},
+		{env.RegisterConst("testEnvNil", nil), ErrInvalidExprConst},
+		{env.RegisterFunc("testEnvNotFunc", 1), ErrInvalidExprFunc},
+		{env.RegisterPackage("testEnvBadPkg", map[string]interface{}{"F": func() {}}), ErrInvalidExprFunc},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.err)
+	}
+}
+
+func TestExprBuiltins(t *testing.T) {
+	EnableExprBeta()
+
+	type record struct {
+		Count uint8
+		Items []uint16 `struct:"while=len(Items) < int(Count)"`
+		Name  string   `struct:"size=align(len(Items)*2, 4)"`
+		Flags uint8    `struct:"if=strings.HasPrefix(Name, \"ok\")"`
+		Sum   uint32   `struct:"in=Sum + uint32(len(Name))"`
+	}
+
+	data := []byte{
+		0x03,
+		0x01, 0x00, 0x02, 0x00, 0x03, 0x00,
+		'o', 'k', '!', 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x07,
+		0x01, 0x00, 0x00, 0x00,
+	}
+
+	r := record{}
+	err := Unpack(data, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, record{
+		Count: 3,
+		Items: []uint16{1, 2, 3},
+		Name:  "ok!\x00\x00\x00\x00\x00",
+		Flags: 7,
+		Sum:   9,
+	}, r)
+}
+
+func TestMagicBytes(t *testing.T) {
+	EnableExprBeta()
+
+	type chunk struct {
+		Type [4]byte
+		Data struct {
+			Header *struct {
+				Width, Height uint8
+			} `struct:"case=\"IHDR\""`
+			End *struct{} `struct:"case=\"IEND\""`
+			Raw *uint8    `struct:"default"`
+		} `struct:"switch=Type"`
+	}
+	type file struct {
+		Magic   [4]byte
+		Version uint8   `struct:"if=hasPrefix(Magic, \"\\x89P\")"`
+		Flags   uint8   `struct:"if=Magic[2:] == \"NG\""`
+		Extra   uint8   `struct:"if=Magic[1:3] != \"PN\""`
+		Chunks  []chunk `struct:"while=!_eof"`
+	}
+
+	data := []byte{
+		0x89, 'P', 'N', 'G',
+		0x01,
+		0x02,
+		'I', 'H', 'D', 'R', 0x10, 0x20,
+		'a', 'b', 'c', 'd', 0xff,
+		'I', 'E', 'N', 'D',
+	}
+
+	f := file{}
+	err := Unpack(data, binary.BigEndian, &f)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(1), f.Version)
+	assert.Equal(t, uint8(2), f.Flags)
+	if assert.Len(t, f.Chunks, 3) {
+		if assert.NotNil(t, f.Chunks[0].Data.Header) {
+			assert.Equal(t, uint8(0x10), f.Chunks[0].Data.Header.Width)
+			assert.Equal(t, uint8(0x20), f.Chunks[0].Data.Header.Height)
+		}
+		if assert.NotNil(t, f.Chunks[1].Data.Raw) {
+			assert.Equal(t, uint8(0xff), *f.Chunks[1].Data.Raw)
+		}
+		assert.NotNil(t, f.Chunks[2].Data.End)
+	}
+
+	packed, err := Pack(binary.BigEndian, &f)
+	assert.Nil(t, err)
+	assert.Equal(t, data, packed)
+}
+
+func TestExprStreamPosition(t *testing.T) {
+	EnableExprBeta()
+
+	type item struct {
+		First uint8  `struct:"if=_index == 0"`
+		Wide  uint16 `struct:"if=_parent.Wide"`
+		Root  uint8  `struct:"if=_root.Count > 1"`
+	}
+	type record struct {
+		Wide   bool
+		Count  uint8
+		Items  []item `struct:"size=Count"`
+		Start  uint8  `struct:"if=_pos == 9"`
+		Nibble uint8  `struct:"bits=4"`
+		Low    uint8  `struct:"bits=4,if=_bitpos % 8 == 4"`
+		Large  uint8  `struct:"if=_size > 100"`
+		Tail   []byte `struct:"size=_io.remaining"`
+	}
+
+	data := []byte{
+		0x01, 0x02,
+		0xaa, 0x02, 0x01, 0x10,
+		0x04, 0x03, 0x11,
+		0x33,
+		0xab,
+		0x01, 0x02, 0x03,
+	}
+
+	r := record{}
+	err := Unpack(data, binary.LittleEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, record{
+		Wide:  true,
+		Count: 2,
+		Items: []item{
+			{First: 0xaa, Wide: 0x0102, Root: 0x10},
+			{Wide: 0x0304, Root: 0x11},
+		},
+		Start:  0x33,
+		Nibble: 0xa,
+		Low:    0xb,
+		Tail:   []byte{0x01, 0x02, 0x03},
+	}, r)
+
+	type elem struct {
+		Index uint8 `struct:"out=uint8(_index)"`
+		Pos   uint8 `struct:"out=uint8(_pos)"`
+		Twice uint8 `struct:"out=_parent.Count*2"`
+	}
+	type packed struct {
+		Count  uint8
+		Elems  [3]elem
+		Bits   uint8 `struct:"bits=3"`
+		BitPos uint8 `struct:"bits=5,out=uint8(_bitpos % 32)"`
+	}
+
+	p := packed{Count: 3, Bits: 5}
+	size, err := SizeOf(p)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, size)
+
+	b, err := Pack(binary.LittleEndian, &p)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x03,
+		0x00, 0x02, 0x06,
+		0x01, 0x05, 0x06,
+		0x02, 0x08, 0x06,
+		0xb3,
+	}, b)
+}
+
+func TestExprStreamPositionRoundTrip(t *testing.T) {
+	EnableExprBeta()
+
+	type pos struct {
+		A uint8
+		X uint8 `struct:"if=_pos == 1 && _io.pos == 1"`
+		Y uint8 `struct:"bits=4,if=_bitpos == 16 && _io.bitpos == 16"`
+		Z uint8 `struct:"bits=4"`
+	}
+	type elem struct {
+		V uint8
+		W uint8 `struct:"if=_index == 1"`
+		R uint8 `struct:"if=_parent.N > 2 || _root.N > 1"`
+	}
+	type index struct {
+		N     uint8
+		Items []elem `struct:"size=N"`
+	}
+	type tail struct {
+		Count uint8
+		Data  []byte `struct:"size=Count"`
+		Tail  []byte `struct:"size=_io.remaining"`
+	}
+	type trailer struct {
+		A     uint8
+		Items []uint8 `struct:"while=!_eof && _io.remaining > 1"`
+		Size  uint8   `struct:"out=uint8(_size)"`
+	}
+
+	tests := []struct {
+		data  []byte
+		value interface{}
+	}{
+		{[]byte{0x01, 0x02, 0x3a}, &pos{}},
+		{[]byte{0x02, 0x01, 0x02, 0x03, 0x04, 0x05}, &index{}},
+		{[]byte{0x03, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}, &index{}},
+		{[]byte{0x01, 0xaa, 0x01, 0x02, 0x03}, &tail{}},
+		{[]byte{0x01, 0x02, 0x03, 0x04}, &trailer{}},
+	}
+
+	for _, test := range tests {
+		err := Unpack(test.data, binary.LittleEndian, test.value)
+		if !assert.Nil(t, err, "%T %x", test.value, test.data) {
+			continue
+		}
+
+		size, err := SizeOf(test.value)
+		assert.Nil(t, err)
+		assert.Equal(t, len(test.data), size, "%T %x", test.value, test.data)
+
+		data, err := Pack(binary.LittleEndian, test.value)
+		assert.Nil(t, err)
+		assert.Equal(t, test.data, data, "%T %+v", test.value, test.value)
+	}
+}
+
+func TestExprPackedSize(t *testing.T) {
+	EnableExprBeta()
+
+	// Expressions that decide which fields are packed, or their sizes, are
+	// evaluated before the size of the packed data is known, so they can not
+	// refer to it. They still can when unpacking.
+	type size struct {
+		A uint8
+		X uint8 `struct:"if=_size > 0"`
+	}
+	type remaining struct {
+		A uint8
+		X uint8 `struct:"if=_io.remaining == 1"`
+	}
+	type ioEOF struct {
+		A uint8
+		X uint8 `struct:"if=_io.eof"`
+	}
+	type eof struct {
+		A uint8
+		X uint8 `struct:"if=!_eof"`
+	}
+	type bits struct {
+		A uint8 `struct:"bits=_io.size"`
+	}
+	type body struct {
+		Short uint8  `struct:"case=1"`
+		Long  uint16 `struct:"case=uint8(_size)"`
+	}
+	type cases struct {
+		A uint8
+		B body `struct:"switch=A"`
+	}
+	type sizes struct {
+		Short uint8  `struct:"case=1"`
+		Long  uint16 `struct:"default"`
+	}
+	type switched struct {
+		A sizes `struct:"switch=_size"`
+	}
+
+	tests := []struct {
+		data  []byte
+		value interface{}
+		err   string
+	}{
+		{[]byte{0x01, 0x02}, &size{}, "restruct.size.X: if"},
+		{[]byte{0x01, 0x02}, &remaining{}, "restruct.remaining.X: if"},
+		{[]byte{0x01, 0x02}, &ioEOF{}, "restruct.ioEOF.X: if"},
+		{[]byte{0x01, 0x02}, &eof{}, "restruct.eof.X: if"},
+		{[]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}, &bits{}, "restruct.bits.A: bits"},
+		{[]byte{0x03, 0x01, 0x02}, &cases{}, "restruct.cases.Long: case"},
+		{[]byte{0x01}, &switched{}, "restruct.switched.A: switch"},
+	}
+
+	for _, test := range tests {
+		assert.Nil(t, Unpack(test.data, binary.LittleEndian, test.value), "%T", test.value)
+
+		msg := test.err + ": " + errPackedSize.Error()
+		_, err := Pack(binary.LittleEndian, test.value)
+		assert.EqualError(t, err, msg)
+		_, err = SizeOf(test.value)
+		assert.EqualError(t, err, msg)
+		_, err = BitSize(test.value)
+		assert.EqualError(t, err, msg)
+	}
+}
+
+func TestSwitchCaseMatching(t *testing.T) {
+	EnableExprBeta()
+
+	type record struct {
+		Type uint8
+		Body struct {
+			Small *uint8   `struct:"case=1, 2, 5"`
+			Range *uint16  `struct:"case=0x10..0x1F"`
+			High  *uint32  `struct:"case=_switch >= 0x80"`
+			Other *[2]byte `struct:"default"`
+		} `struct:"switch=Type"`
+	}
+
+	tests := []struct {
+		data []byte
+		size int
+	}{
+		{[]byte{0x05, 0x01}, 2},
+		{[]byte{0x1f, 0x01, 0x02}, 3},
+		{[]byte{0x90, 0x01, 0x02, 0x03, 0x04}, 5},
+		{[]byte{0x03, 0x01, 0x02}, 3},
+	}
+
+	for _, test := range tests {
+		r := record{}
+		err := Unpack(test.data, binary.LittleEndian, &r)
+		assert.Nil(t, err)
+
+		size, err := SizeOf(r)
+		assert.Nil(t, err)
+		assert.Equal(t, test.size, size)
+
+		data, err := Pack(binary.LittleEndian, &r)
+		assert.Nil(t, err)
+		assert.Equal(t, test.data, data)
+	}
+
+	r := record{}
+	assert.Nil(t, Unpack([]byte{0x12, 0x34, 0x12}, binary.LittleEndian, &r))
+	if assert.NotNil(t, r.Body.Range) {
+		assert.Equal(t, uint16(0x1234), *r.Body.Range)
+	}
+	assert.Nil(t, r.Body.Small)
+	assert.Nil(t, r.Body.High)
+	assert.Nil(t, r.Body.Other)
+}
+
+func TestSwitchCaseOptionNames(t *testing.T) {
+	EnableExprBeta()
+
+	env := NewExprEnv()
+	assert.Nil(t, env.RegisterConst("big", uint8(3)))
+
+	// Case values that read as options are given in a struct-case tag.
+	type record struct {
+		Type uint8
+		Body struct {
+			Small *uint8  `struct:"case=1"`
+			Wide  *uint16 `struct-case:"2, big" struct:"little"`
+		} `struct:"switch=Type"`
+	}
+
+	r := record{}
+	assert.Nil(t, env.Unpack([]byte{0x03, 0x34, 0x12}, binary.BigEndian, &r))
+	if assert.NotNil(t, r.Body.Wide) {
+		assert.Equal(t, uint16(0x1234), *r.Body.Wide)
+	}
+	assert.Nil(t, r.Body.Small)
+
+	data, err := env.Pack(binary.BigEndian, &r)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x03, 0x34, 0x12}, data)
+}
+
+func TestExprLiterals(t *testing.T) {
+	EnableExprBeta()
+
+	type record struct {
+		Type  uint8
+		Count uint8
+		Data  []byte `struct:"size=int(Count) * {1: 1, 2: 2, 3: 4}[Type]"`
+		Flags uint8  `struct:"if=[false, false, true, true][Type]"`
+	}
+
+	tests := []struct {
+		data   []byte
+		record record
+	}{
+		{
+			[]byte{0x01, 0x02, 0xaa, 0xbb},
+			record{Type: 1, Count: 2, Data: []byte{0xaa, 0xbb}},
+		},
+		{
+			[]byte{0x03, 0x01, 0x01, 0x02, 0x03, 0x04, 0x80},
+			record{Type: 3, Count: 1, Data: []byte{0x01, 0x02, 0x03, 0x04}, Flags: 0x80},
+		},
+		{
+			[]byte{0x00, 0x05},
+			record{Type: 0, Count: 5, Data: []byte{}},
+		},
+	}
+
+	for _, test := range tests {
+		r := record{}
+		err := Unpack(test.data, binary.LittleEndian, &r)
+		assert.Nil(t, err)
+		assert.Equal(t, test.record, r)
+
+		data, err := Pack(binary.LittleEndian, &r)
+		assert.Nil(t, err)
+		assert.Equal(t, test.data, data)
+	}
+
+	r := record{}
+	err := Unpack([]byte{0x04, 0x00}, binary.LittleEndian, &r)
+	assert.EqualError(t, err, "index out of range [4] with length 4")
+}
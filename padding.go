@@ -0,0 +1,113 @@
+package restruct
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrInvalidPadding is returned when pad or trim is used on an invalid type.
+var ErrInvalidPadding = errors.New("pad or trim specified on non-fixed string type")
+
+// trimMode specifies how padding is removed from fixed size strings, and by
+// extension, on which side it is added.
+type trimMode int
+
+const (
+	// trimDefault cuts strings at the first NUL, or trims trailing padding
+	// when a pad is given.
+	trimDefault trimMode = iota
+
+	// trimNone keeps the whole field verbatim.
+	trimNone
+
+	// trimLeft removes leading padding. Values are right aligned.
+	trimLeft
+
+	// trimRight removes trailing padding. Values are left aligned.
+	trimRight
+
+	// trimBoth removes padding on both sides. Values are left aligned.
+	trimBoth
+)
+
+var trimModes = map[string]trimMode{
+	"none":  trimNone,
+	"left":  trimLeft,
+	"right": trimRight,
+	"both":  trimBoth,
+}
+
+// validPaddingType returns true if pad and trim can be used with the given
+// native and binary types.
+func validPaddingType(native, binary reflect.Type) bool {
+	if native.Kind() != reflect.String {
+		return false
+	}
+	if binary.Kind() != reflect.Array || binary.Elem().Kind() != reflect.Uint8 {
+		return false
+	}
+	_, _, wide := wideInt(binary)
+	return !wide
+}
+
+// padding returns the pad sequence of a field. Without a pad, fields are
+// padded with a NUL code unit.
+func (f *field) padding() []byte {
+	if f.Pad != nil {
+		return f.Pad
+	}
+	return make([]byte, f.unitSize())
+}
+
+// isPadded returns true if the field has custom padding or trimming.
+func (f *field) isPadded() bool {
+	return f.Pad != nil || f.Trim != trimDefault
+}
+
+// fixedString converts the contents of a fixed size string field to a
+// string, removing padding.
+func fixedString(f field, b []byte) string {
+	if !f.isPadded() {
+		// Only the bytes before the first NUL code unit are decoded, as
+		// those after it need not be valid in the encoding.
+		unit := f.unitSize()
+		if nul := indexUnit(b, make([]byte, unit), unit); nul != -1 {
+			b = b[:nul]
+		}
+		return decodeString(f, b)
+	}
+
+	pad := f.padding()
+	if f.Trim == trimLeft || f.Trim == trimBoth {
+		for bytes.HasPrefix(b, pad) {
+			b = b[len(pad):]
+		}
+	}
+	if f.Trim != trimLeft && f.Trim != trimNone {
+		for bytes.HasSuffix(b, pad) {
+			b = b[:len(b)-len(pad)]
+		}
+	}
+	return decodeString(f, b)
+}
+
+// padFixed pads b out to size bytes by repeating the pad sequence of the
+// field. Right aligned fields are padded on the left.
+func padFixed(f field, b []byte, size int) []byte {
+	if len(b) > size {
+		panic(fmt.Errorf("%s: length %d exceeds fixed size %d", f.Name, len(b), size))
+	}
+
+	pad := f.padding()
+	fill := make([]byte, size-len(b))
+	for i := range fill {
+		fill[i] = pad[i%len(pad)]
+	}
+
+	if f.Trim == trimLeft {
+		return append(fill, b...)
+	}
+	return append(b, fill...)
+}
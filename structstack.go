@@ -16,31 +16,182 @@ type structstack struct {
 	buf       []byte
 	stack     []reflect.Value
 	allowexpr bool
+
+	// env holds the names available to expressions besides the global
+	// ones, if any.
+	env *ExprEnv
+
+	// size is the length of the whole buffer, and bitCounter the number of
+	// bits of buf[0] that have already been read or written.
+	size       int
+	bitCounter int
+
+	// measured is the number of bits measured by fieldbits before the field
+	// being measured, so that positions are known while sizing.
+	measured int
+
+	// elem holds the array element being tested by an until expression, if
+	// any, and index the index of the array element being processed, if
+	// inelem is set.
+	elem   reflect.Value
+	index  int
+	inelem bool
+
+	// switchval holds the value of the switch whose cases are being matched,
+	// if inswitch is set.
+	switchval interface{}
+	inswitch  bool
 }
 
 func (s *structstack) Resolve(ident string) expr.Value {
+	if v := s.ResolveBuiltin(ident); v != nil {
+		return v
+	}
+	if st := s.Struct(); st.IsValid() {
+		if sf, ok := st.Type().FieldByName(ident); ok && sf.PkgPath == "" {
+			v := st.FieldByIndex(sf.Index)
+			if conv := s.FieldConverter(st.Type(), sf.Index); conv != nil {
+				return conv(v)
+			}
+			return expr.ValueOf(v.Interface())
+		}
+	}
+	return s.Globals().Resolve(ident)
+}
+
+// ResolveBuiltin resolves the identifiers beginning with an underscore, which
+// take precedence over the fields of the current struct.
+func (s *structstack) ResolveBuiltin(ident string) expr.Value {
 	switch ident {
 	case "_eof":
-		return expr.ValueOf(len(s.buf) == 0)
-	default:
-		if t := stdLibResolver.Resolve(ident); t != nil {
-			return t
+		return expr.ValueOf(s.eof())
+	case "_elem":
+		if s.elem.IsValid() {
+			return expr.ValueOf(s.elem.Interface())
 		}
-		if len(s.stack) > 0 {
-			if sv := s.stack[len(s.stack)-1].FieldByName(ident); sv.IsValid() {
-				return expr.ValueOf(sv.Interface())
-			}
+		return nil
+	case "_index":
+		if s.inelem {
+			return expr.ValueOf(s.index)
 		}
 		return nil
+	case "_switch":
+		if s.inswitch {
+			return expr.ValueOf(s.switchval)
+		}
+		return nil
+	case "_pos":
+		return expr.ValueOf(s.bitpos() / 8)
+	case "_bitpos":
+		return expr.ValueOf(s.bitpos())
+	case "_size":
+		return expr.ValueOf(s.size)
+	case "_io":
+		return expr.ValueOf(s.io())
+	case "_parent":
+		return ancestorValue(s.ancestor(1))
+	case "_root":
+		return ancestorValue(s.root())
+	default:
+		return nil
 	}
 }
 
+// Globals returns the resolver for the environment, the standard library and
+// registered values.
+func (s *structstack) Globals() expr.Resolver {
+	return exprGlobals{s.env}
+}
+
+// bitpos returns the current position in the buffer, in bits.
+func (s *structstack) bitpos() int {
+	return (s.size-len(s.buf))*8 + s.bitCounter + s.measured
+}
+
+// eof returns the value of _eof, which is true when no bytes of the buffer
+// are left to read or write.
+func (s *structstack) eof() bool {
+	return s.bitpos()/8 >= s.size
+}
+
+// io returns the value of _io, which describes the buffer.
+func (s *structstack) io() expr.Package {
+	pos := s.bitpos()
+	remaining := s.size - (pos+7)/8
+	if remaining < 0 {
+		remaining = 0
+	}
+	return exprIOPackage(remaining, pos/8, s.size, pos, remaining == 0)
+}
+
+// exprIOPackage returns a package holding the members of _io.
+func exprIOPackage(remaining, pos, size, bitpos int, eof bool) expr.Package {
+	return expr.NewPackage(map[string]expr.Value{
+		"remaining": expr.ValueOf(remaining),
+		"pos":       expr.ValueOf(pos),
+		"size":      expr.ValueOf(size),
+		"bitpos":    expr.ValueOf(bitpos),
+		"eof":       expr.ValueOf(eof),
+	})
+}
+
+// ancestorValue returns the value of _parent or _root, or nil if there is no
+// such struct.
+func ancestorValue(v reflect.Value) expr.Value {
+	if !v.IsValid() {
+		return nil
+	}
+	return expr.ValueOf(v.Interface())
+}
+
+// enterElem marks the array element at index i as being processed, and
+// returns the previous state for leaveElem.
+func (s *structstack) enterElem(i int) (int, bool) {
+	index, inelem := s.index, s.inelem
+	s.index, s.inelem = i, true
+	return index, inelem
+}
+
+// leaveElem restores the state saved by enterElem.
+func (s *structstack) leaveElem(index int, inelem bool) {
+	s.index, s.inelem = index, inelem
+}
+
+// Struct returns the struct whose fields are resolved by expressions.
+func (s *structstack) Struct() reflect.Value {
+	if len(s.stack) > 0 {
+		return s.stack[len(s.stack)-1]
+	}
+	return reflect.Value{}
+}
+
+// FieldConverter returns the converter for the values of a field of a struct
+// type. Wide integers are converted by the signedness of their binary type,
+// which is looked up once here rather than on every evaluation.
+func (s *structstack) FieldConverter(typ reflect.Type, index []int) func(reflect.Value) expr.Value {
+	ft := typ.FieldByIndex(index).Type
+	if len(index) == 1 && isBigNative(ft) {
+		for _, f := range cachedFieldsFromStruct(typ) {
+			if f.Index == index[0] {
+				return func(v reflect.Value) expr.Value { return exprFieldValue(f, v) }
+			}
+		}
+	}
+	if ft == bigIntType || ft == bigIntPtrType {
+		return exprValueOf
+	}
+	return nil
+}
+
 func (s *structstack) evalBits(f field) int {
 	bits := 0
 	if f.BitSize != 0 {
 		bits = int(f.BitSize)
 	}
 	if f.BitsExpr != nil {
+		if f.ConstBits != nil && s.allowexpr {
+			return *f.ConstBits
+		}
 		bits = reflect.ValueOf(s.evalExpr(f.BitsExpr)).Convert(reflect.TypeOf(int(0))).Interface().(int)
 	}
 	return bits
@@ -49,6 +200,9 @@ func (s *structstack) evalBits(f field) int {
 func (s *structstack) evalSize(f field) int {
 	size := 0
 	if f.SizeExpr != nil {
+		if f.ConstSize != nil && s.allowexpr {
+			return *f.ConstSize
+		}
 		size = reflect.ValueOf(s.evalExpr(f.SizeExpr)).Convert(reflect.TypeOf(int(0))).Interface().(int)
 	}
 	return size
@@ -71,6 +225,41 @@ func (s *structstack) evalWhile(f field) bool {
 	panic("expected bool value for while expr")
 }
 
+// evalUntil evaluates the until expression of f with the element v at index
+// i in scope.
+func (s *structstack) evalUntil(f field, v reflect.Value, i int) bool {
+	elem := s.elem
+	s.elem = v
+	index, inelem := s.enterElem(i)
+	defer func() {
+		s.elem = elem
+		s.leaveElem(index, inelem)
+	}()
+
+	if b, ok := s.evalExpr(f.UntilExpr).(bool); ok {
+		return b
+	}
+	panic("expected bool value for until expr")
+}
+
+// matchCase returns true if the case expression of f matches the value of
+// its switch. Cases may list several values or ranges, or test the value with
+// a predicate that refers to it as _switch; the first matching case is used.
+func (s *structstack) matchCase(f field, on interface{}) bool {
+	if !s.allowexpr {
+		panic("call restruct.EnableExprBeta() to eanble expressions beta")
+	}
+	switchval, inswitch := s.switchval, s.inswitch
+	s.switchval, s.inswitch = on, true
+	defer func() { s.switchval, s.inswitch = switchval, inswitch }()
+
+	match, err := expr.EvalMatch(s, f.CaseExpr, on)
+	if err != nil {
+		panic(err)
+	}
+	return match
+}
+
 func (s *structstack) switcbits(f field, v reflect.Value, on interface{}) (size int) {
 	var def *switchcase
 
@@ -97,7 +286,7 @@ func (s *structstack) switcbits(f field, v reflect.Value, on interface{}) (size
 			panic(fmt.Errorf("%s: only cases are valid inside switches", f.Name))
 		}
 
-		if s.evalExpr(f.CaseExpr) == on {
+		if s.matchCase(f, on) {
 			return s.fieldbits(f, v)
 		}
 	}
@@ -152,6 +341,14 @@ func (s *structstack) fieldbits(f field, val reflect.Value) (size int) {
 		return b
 	}
 
+	if size, _, ok := wideInt(f.BinaryType); ok {
+		return size*8 + skipBits
+	}
+
+	if f.Decimal != decimalNone || f.ASCII != asciiNone || f.UUID != uuidNone {
+		return f.BinaryType.Len()*8 + skipBits
+	}
+
 	alen := 1
 	switch f.BinaryType.Kind() {
 	case reflect.Int8, reflect.Uint8, reflect.Bool:
@@ -169,11 +366,21 @@ func (s *structstack) fieldbits(f field, val reflect.Value) (size int) {
 		return 128 + skipBits
 	case reflect.Slice, reflect.String:
 		switch f.NativeType.Kind() {
-		case reflect.Slice, reflect.String, reflect.Array, reflect.Ptr:
+		case reflect.String:
+			alen = len(encodeString(f, val.String()))
+		case reflect.Slice:
+			alen = val.Len()
+			if f.ConstSize != nil {
+				alen = *f.ConstSize
+			}
+		case reflect.Array, reflect.Ptr:
 			alen = val.Len()
 		default:
 			return 0
 		}
+		if f.Terminator != nil && f.Flags&(IncludeFlag|NoConsumeFlag) == 0 {
+			size += len(f.Terminator) * 8
+		}
 		fallthrough
 	case reflect.Array, reflect.Ptr:
 		size += skipBits
@@ -183,6 +390,12 @@ func (s *structstack) fieldbits(f field, val reflect.Value) (size int) {
 			alen = f.BinaryType.Len()
 		}
 
+		// An excluded until terminator is not stored, but is still encoded.
+		if f.UntilExpr != nil && f.Flags&ExcludeFlag != 0 {
+			elem := f.Elem()
+			size += s.fieldbits(elem, reflect.New(elem.NativeType).Elem())
+		}
+
 		// Optimization: if the array/slice is empty, bail now.
 		if alen == 0 {
 			return size
@@ -198,29 +411,68 @@ func (s *structstack) fieldbits(f field, val reflect.Value) (size int) {
 			if elem.Trivial {
 				size += s.fieldbits(elem, reflect.Zero(elem.BinaryType)) * alen
 			} else {
+				measured := s.measured
 				for i := 0; i < alen; i++ {
+					s.measured = measured + size
+					index, inelem := s.enterElem(i)
 					size += s.fieldbits(elem, val.Index(i))
+					s.leaveElem(index, inelem)
 				}
+				s.measured = measured
 			}
 		}
 		return size
 	case reflect.Struct:
 		size += skipBits
+		limit := -1
+		if f.SizeExpr != nil {
+			limit = s.evalSize(f)
+		}
 		s.push(val)
+		measured := s.measured
 		for _, field := range cachedFieldsFromStruct(f.BinaryType) {
+			s.measured = measured + size
 			if field.BitSize != 0 {
 				size += int(field.BitSize)
 			} else {
 				size += s.fieldbits(field, val.Field(field.Index))
 			}
 		}
+		s.measured = measured
 		s.pop(val)
+		if limit >= 0 {
+			if n := (size - skipBits + 7) / 8; n > limit {
+				panic(fmt.Errorf("%s: length %d exceeds size expression %d", f.Name, n, limit))
+			}
+			return skipBits + limit*8
+		}
 		return size
 	default:
 		return 0
 	}
 }
 
+// limit limits the stream to the next n bytes, which hold a struct with a
+// size expression. Positions are unchanged, while _eof and _io refer to the
+// end of the struct. It returns the stream to restore with unlimit.
+func (s *structstack) limit(f field, n int) ([]byte, int) {
+	if s.bitCounter != 0 {
+		panic(fmt.Errorf("%s: sized struct is not byte-aligned", f.Name))
+	}
+	if n < 0 || n > len(s.buf) {
+		panic(fmt.Errorf("%s: size %d exceeds the remaining %d bytes", f.Name, n, len(s.buf)))
+	}
+	buf, size := s.buf, s.size
+	s.buf, s.size = buf[:n], size-len(buf)+n
+	return buf, size
+}
+
+// unlimit restores the stream returned by limit, after the n bytes of the
+// struct. Bytes of the struct that were not read or written are skipped.
+func (s *structstack) unlimit(buf []byte, size, n int) {
+	s.buf, s.size, s.bitCounter = buf[n:], size, 0
+}
+
 // fieldbytes returns the effective size in bytes, for the few cases where
 // byte sizes are needed.
 func (s *structstack) fieldbytes(f field, val reflect.Value) (size int) {
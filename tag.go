@@ -9,6 +9,8 @@ import (
 	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/go-restruct/restruct/expr"
 )
 
 func lower(ch rune) rune {
@@ -39,6 +41,41 @@ func isint(c rune) bool {
 	return isdigit(c) || ishex(c) || lower(c) == 'x'
 }
 
+// tagFlags are the options that take no value.
+var tagFlags = map[string]bool{
+	"lsb": true, "little": true, "msb": true, "big": true, "network": true,
+	"variantbool": true, "invertedbool": true, "root": true, "parent": true,
+	"default": true, "exclude": true, "include": true, "noconsume": true,
+	"rest": true, "greedy": true, "strict": true, "bcd": true,
+	"packed-decimal": true, "comp-3": true, "saturate": true, "uuid": true,
+	"guid": true,
+}
+
+// isTagOption returns true if s reads as a struct tag option rather than as
+// an expression.
+func isTagOption(s string) bool {
+	if s == "" || tagFlags[s] {
+		return true
+	}
+	if _, err := parseType(s); err == nil {
+		return true
+	}
+	i := 0
+	for i < len(s) && (isident(rune(s[i])) || s[i] == '-') {
+		i++
+	}
+	if i == 0 || i == len(s) || !isletter(rune(s[0])) {
+		return false
+	}
+	switch s[i] {
+	case ':':
+		return true
+	case '=':
+		return i+1 == len(s) || s[i+1] != '='
+	}
+	return false
+}
+
 // tagOptions represents a parsed struct tag.
 type tagOptions struct {
 	Ignore           bool
@@ -46,6 +83,8 @@ type tagOptions struct {
 	SizeOf           string
 	SizeFrom         string
 	Skip             int
+	Bit              int
+	HasBit           bool
 	Order            binary.ByteOrder
 	BitSize          uint8
 	VariantBoolFlag  bool
@@ -53,6 +92,22 @@ type tagOptions struct {
 	RootFlag         bool
 	ParentFlag       bool
 	DefaultFlag      bool
+	ExcludeFlag      bool
+	IncludeFlag      bool
+	NoConsumeFlag    bool
+	RestFlag         bool
+	StrictFlag       bool
+	SaturateFlag     bool
+	Terminator       []byte
+	Fixed            *fixedFormat
+	Round            string
+	Decimal          decimalFormat
+	Time             timeFormat
+	Encoding         Encoding
+	Pad              []byte
+	Trim             trimMode
+	ASCII            asciiFormat
+	UUID             uuidFormat
 
 	IfExpr     string
 	SizeExpr   string
@@ -60,6 +115,7 @@ type tagOptions struct {
 	InExpr     string
 	OutExpr    string
 	WhileExpr  string
+	UntilExpr  string
 	SwitchExpr string
 	CaseExpr   string
 }
@@ -173,9 +229,84 @@ func (opts *tagOptions) parse(tag string) error {
 		return result, nil
 	}
 
+	// acceptCaseExpr accepts a case expression, whose alternatives may be
+	// separated by commas. The expression ends before the first element that
+	// reads as another option, so alternatives that read as options must be
+	// given in a struct-case tag instead.
+	acceptCaseExpr := func() (string, error) {
+		result, err := acceptExpr()
+		for err == nil && tag[0] == ',' {
+			rest := tag
+			tag = tag[1:]
+			next, nerr := acceptExpr()
+			if nerr != nil || isTagOption(next) {
+				tag = rest
+				break
+			}
+			result += "," + next
+		}
+		return result, err
+	}
+
+	acceptBytes := func() ([]byte, error) {
+		source, err := acceptExpr()
+		if err != nil {
+			return nil, err
+		}
+		v, err := expr.Eval(expr.NewMapResolver(nil), source)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case string:
+			if len(v) == 0 {
+				return nil, errors.New("empty byte sequence")
+			}
+			return []byte(v), nil
+		case int64:
+			if v < 0 || v > 0xFF {
+				return nil, fmt.Errorf("byte value %d out of range", v)
+			}
+			return []byte{byte(v)}, nil
+		case uint64:
+			if v > 0xFF {
+				return nil, fmt.Errorf("byte value %d out of range", v)
+			}
+			return []byte{byte(v)}, nil
+		default:
+			return nil, fmt.Errorf("expected string or byte value, got %T", v)
+		}
+	}
+
+	acceptValue := func() string {
+		i := strings.IndexAny(tag, ",\x00")
+		result := tag[:i]
+		tag = tag[i:]
+		return result
+	}
+
+	acceptBigInt := func(elem reflect.Type) (reflect.Type, error) {
+		bits, err := acceptInt()
+		if err != nil {
+			return nil, err
+		}
+		if bits <= 0 || bits%8 != 0 {
+			return nil, fmt.Errorf("bit size %d is not a positive multiple of 8", bits)
+		}
+		return reflect.ArrayOf(bits/8, elem), nil
+	}
+
 	var err error
 	for {
 		switch {
+		case accept("bigint:"):
+			if opts.Type, err = acceptBigInt(sbyteType); err != nil {
+				return fmt.Errorf("bigint: %v", err)
+			}
+		case accept("biguint:"):
+			if opts.Type, err = acceptBigInt(ubyteType); err != nil {
+				return fmt.Errorf("biguint: %v", err)
+			}
 		case accept("lsb"), accept("little"):
 			opts.Order = binary.LittleEndian
 		case accept("msb"), accept("big"), accept("network"):
@@ -190,6 +321,64 @@ func (opts *tagOptions) parse(tag string) error {
 			opts.ParentFlag = true
 		case accept("default"):
 			opts.DefaultFlag = true
+		case accept("exclude"):
+			opts.ExcludeFlag = true
+		case accept("include"):
+			opts.IncludeFlag = true
+		case accept("noconsume"):
+			opts.NoConsumeFlag = true
+		case accept("rest"), accept("greedy"):
+			opts.RestFlag = true
+		case accept("strict"):
+			opts.StrictFlag = true
+		case accept("bcd=swapped"):
+			opts.Decimal = decimalSwapped
+		case accept("bcd=normal"), accept("bcd"):
+			opts.Decimal = decimalBCD
+		case accept("packed-decimal"), accept("comp-3"):
+			opts.Decimal = decimalPacked
+		case accept("saturate"):
+			opts.SaturateFlag = true
+		case accept("fixed="), accept("fixed:"):
+			if opts.Fixed, err = parseFixed(acceptValue()); err != nil {
+				return fmt.Errorf("fixed: %v", err)
+			}
+		case accept("round="):
+			opts.Round = acceptValue()
+			if _, ok := roundModes[opts.Round]; !ok {
+				return fmt.Errorf("round: unknown rounding mode %q", opts.Round)
+			}
+		case accept("time="):
+			name := acceptValue()
+			if opts.Time = timeFormats[name]; opts.Time == timeNone {
+				return fmt.Errorf("time: unknown time encoding %q", name)
+			}
+		case accept("encoding="):
+			name := acceptValue()
+			var ok bool
+			if opts.Encoding, ok = lookupEncoding(name); !ok {
+				return fmt.Errorf("encoding: unknown encoding %q", name)
+			}
+		case accept("uuid"):
+			opts.UUID = uuidRFC
+		case accept("guid"):
+			opts.UUID = uuidMicrosoft
+		case accept("ascii="):
+			name := acceptValue()
+			var ok bool
+			if opts.ASCII, ok = asciiFormats[name]; !ok {
+				return fmt.Errorf("ascii: unknown number format %q", name)
+			}
+		case accept("pad="):
+			if opts.Pad, err = acceptBytes(); err != nil {
+				return fmt.Errorf("pad: %v", err)
+			}
+		case accept("trim="):
+			name := acceptValue()
+			var ok bool
+			if opts.Trim, ok = trimModes[name]; !ok {
+				return fmt.Errorf("trim: unknown trim mode %q", name)
+			}
 		case accept("sizeof="):
 			if opts.SizeOf, err = acceptIdent(); err != nil {
 				return fmt.Errorf("sizeof: %v", err)
@@ -198,10 +387,23 @@ func (opts *tagOptions) parse(tag string) error {
 			if opts.SizeFrom, err = acceptIdent(); err != nil {
 				return fmt.Errorf("sizefrom: %v", err)
 			}
+		case accept("terminator="):
+			if opts.Terminator, err = acceptBytes(); err != nil {
+				return fmt.Errorf("terminator: %v", err)
+			}
 		case accept("skip="):
 			if opts.Skip, err = acceptInt(); err != nil {
 				return fmt.Errorf("skip: %v", err)
 			}
+		case accept("bit="):
+			bit, err := acceptInt()
+			if err != nil {
+				return fmt.Errorf("bit: %v", err)
+			}
+			if bit < 0 || bit > 63 {
+				return fmt.Errorf("bit: bit %d out of range", bit)
+			}
+			opts.Bit, opts.HasBit = bit, true
 		case accept("if="):
 			if opts.IfExpr, err = acceptExpr(); err != nil {
 				return fmt.Errorf("if: %v", err)
@@ -226,12 +428,16 @@ func (opts *tagOptions) parse(tag string) error {
 			if opts.WhileExpr, err = acceptExpr(); err != nil {
 				return fmt.Errorf("while: %v", err)
 			}
+		case accept("until="):
+			if opts.UntilExpr, err = acceptExpr(); err != nil {
+				return fmt.Errorf("until: %v", err)
+			}
 		case accept("switch="):
 			if opts.SwitchExpr, err = acceptExpr(); err != nil {
 				return fmt.Errorf("switch: %v", err)
 			}
 		case accept("case="):
-			if opts.CaseExpr, err = acceptExpr(); err != nil {
+			if opts.CaseExpr, err = acceptCaseExpr(); err != nil {
 				return fmt.Errorf("case: %v", err)
 			}
 		case accept("-"):
@@ -270,12 +476,29 @@ func (opts *tagOptions) parse(tag string) error {
 	}
 }
 
-// mustParseTag calls ParseTag but panics if there is an error, to help make
-// sure programming errors surface quickly.
-func mustParseTag(tag string) tagOptions {
-	opt, err := parseTag(tag)
+// TagError is returned when the struct tag of a field cannot be parsed. Tag
+// is the option or struct tag key containing the error, if known.
+type TagError struct {
+	Struct reflect.Type
+	Field  string
+	Tag    string
+	Err    error
+}
+
+func (e TagError) Error() string {
+	if e.Tag == "" {
+		return fmt.Sprintf("%s.%s: %v", e.Struct, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s.%s: %s: %v", e.Struct, e.Field, e.Tag, e.Err)
+}
+
+// mustParseTag calls ParseTag on the tag of a struct field but panics with a
+// TagError if there is an error, to help make sure programming errors surface
+// quickly.
+func mustParseTag(typ reflect.Type, val reflect.StructField) tagOptions {
+	opt, err := parseTag(val.Tag.Get("struct"))
 	if err != nil {
-		panic(err)
+		panic(TagError{Struct: typ, Field: val.Name, Err: err})
 	}
 	return opt
 }
@@ -114,7 +114,50 @@ func TestParseTag(t *testing.T) {
 		{"sizeof=0", tagOptions{}, "sizeof: invalid identifier character 0"},
 
 		// Skip
+		{"int24", tagOptions{Type: reflect.TypeOf([3]sbyte{})}, ""},
+		{"uint48", tagOptions{Type: reflect.TypeOf([6]ubyte{})}, ""},
+		{"[]uint24", tagOptions{Type: reflect.TypeOf([][3]ubyte{})}, ""},
+		{"uint128", tagOptions{Type: reflect.TypeOf([16]ubyte{})}, ""},
+		{"bigint:256", tagOptions{Type: reflect.TypeOf([32]sbyte{})}, ""},
+		{"biguint:72,little", tagOptions{Type: reflect.TypeOf([9]ubyte{}), Order: binary.LittleEndian}, ""},
+		{"bigint:12", tagOptions{}, "bigint: bit size 12 is not a positive multiple of 8"},
+		{"float16", tagOptions{Type: reflect.TypeOf(float16(0))}, ""},
+		{"[]bfloat16", tagOptions{Type: reflect.TypeOf([]bfloat16{})}, ""},
+		{"fixed=16.16", tagOptions{Fixed: &fixedFormat{Int: 16, Frac: 16}}, ""},
+		{"fixed:2.14", tagOptions{Fixed: &fixedFormat{Int: 2, Frac: 14}}, ""},
+		{"uint8,fixed=Q4.4,round=floor,saturate", tagOptions{
+			Type:         reflect.TypeOf(uint8(0)),
+			Fixed:        &fixedFormat{Int: 4, Frac: 4, Q: true},
+			Round:        "floor",
+			SaturateFlag: true,
+		}, ""},
+		{"fixed=Q15", tagOptions{Fixed: &fixedFormat{Int: 0, Frac: 15, Q: true}}, ""},
+		{"fixed=16", tagOptions{}, "fixed: invalid format \"16\", expected m.n"},
+		{"fixed=a.b", tagOptions{}, "fixed: invalid integer bits \"a\""},
+		{"round=up", tagOptions{}, "round: unknown rounding mode \"up\""},
+		{"bcd", tagOptions{Decimal: decimalBCD}, ""},
+		{"[4]byte,bcd=swapped", tagOptions{Type: reflect.TypeOf([4]byte{}), Decimal: decimalSwapped}, ""},
+		{"packed-decimal", tagOptions{Decimal: decimalPacked}, ""},
+		{"comp-3", tagOptions{Decimal: decimalPacked}, ""},
+		{"time=unix", tagOptions{Time: timeUnix}, ""},
+		{"uint64,time=filetime", tagOptions{Type: reflect.TypeOf(uint64(0)), Time: timeFiletime}, ""},
+		{"time=epoch", tagOptions{}, "time: unknown time encoding \"epoch\""},
+		{"encoding=utf16le", tagOptions{Encoding: utf16Encoding{Order: binary.LittleEndian}}, ""},
+		{"[32]byte,encoding=Latin1", tagOptions{Type: reflect.TypeOf([32]byte{}), Encoding: byteEncoding{Name: "Latin-1", Max: 0xff}}, ""},
+		{"[12]byte,ascii=octal", tagOptions{Type: reflect.TypeOf([12]byte{}), ASCII: asciiOctal}, ""},
+		{"ascii=hex,terminator=0", tagOptions{ASCII: asciiHex, Terminator: []byte{0}}, ""},
+		{"ascii=binary", tagOptions{}, "ascii: unknown number format \"binary\""},
+		{"uuid", tagOptions{UUID: uuidRFC}, ""},
+		{"[16]byte,guid", tagOptions{Type: reflect.TypeOf([16]byte{}), UUID: uuidMicrosoft}, ""},
+		{"pad=' '", tagOptions{Pad: []byte{' '}}, ""},
+		{"[12]byte,pad=0x30,trim=left", tagOptions{Type: reflect.TypeOf([12]byte{}), Pad: []byte{'0'}, Trim: trimLeft}, ""},
+		{"trim=none", tagOptions{Trim: trimNone}, ""},
+		{"trim=middle", tagOptions{}, "trim: unknown trim mode \"middle\""},
+		{"encoding=ebcdic", tagOptions{}, "encoding: unknown encoding \"ebcdic\""},
 		{"skip=4", tagOptions{Skip: 4}, ""},
+		{"bit=0", tagOptions{Bit: 0, HasBit: true}, ""},
+		{"bit=7", tagOptions{Bit: 7, HasBit: true}, ""},
+		{"bit=64", tagOptions{}, "bit: bit 64 out of range"},
 		{"skip=字", tagOptions{}, "skip: invalid integer character 字"},
 
 		// Expressions
@@ -129,11 +172,36 @@ func TestParseTag(t *testing.T) {
 		{"out=struct{}{}", tagOptions{OutExpr: "struct{}{}"}, ""},
 		{"out=struct{}{},variantbool", tagOptions{OutExpr: "struct{}{}", VariantBoolFlag: true}, ""},
 		{"while=true", tagOptions{WhileExpr: "true"}, ""},
+		{"until=_elem==0", tagOptions{UntilExpr: "_elem==0"}, ""},
+		{"until=_elem==0,exclude", tagOptions{UntilExpr: "_elem==0", ExcludeFlag: true}, ""},
+		{"until={,", tagOptions{}, "until: unexpected eof in expr"},
+		{"case=1", tagOptions{CaseExpr: "1"}, ""},
+		{"case=1,2,5", tagOptions{CaseExpr: "1,2,5"}, ""},
+		{"case=0x10..0x1F,_switch==2,little", tagOptions{CaseExpr: "0x10..0x1F,_switch==2", Order: binary.LittleEndian}, ""},
+		{"case=1,Two,size=4", tagOptions{CaseExpr: "1,Two", SizeExpr: "4"}, ""},
+		{"case=1,uint16", tagOptions{CaseExpr: "1", Type: reflect.TypeOf(uint16(0))}, ""},
+		{"case=2,big", tagOptions{CaseExpr: "2", Order: binary.BigEndian}, ""},
 		{`if="`, tagOptions{}, "if: unexpected eof in literal"},
 		{`while="\"`, tagOptions{}, "while: unexpected eof in literal"},
 		{`in="\"\"""`, tagOptions{}, "in: unexpected eof in literal"},
 		{`out="\"test`, tagOptions{}, "out: unexpected eof in literal"},
 
+		// Terminator
+		{"terminator=0", tagOptions{Terminator: []byte{0}}, ""},
+		{"terminator='\\n'", tagOptions{Terminator: []byte{'\n'}}, ""},
+		{"terminator=$'\\xff\\xd9',include", tagOptions{Terminator: []byte{0xFF, 0xD9}, IncludeFlag: true}, ""},
+		{`terminator="\r\n",noconsume`, tagOptions{Terminator: []byte{'\r', '\n'}, NoConsumeFlag: true}, ""},
+		{"terminator=256", tagOptions{}, "terminator: byte value 256 out of range"},
+		{"terminator=1.5", tagOptions{}, "terminator: expected string or byte value, got float64"},
+		{`terminator=""`, tagOptions{}, "terminator: empty byte sequence"},
+
+		// Rest
+		{"rest", tagOptions{RestFlag: true}, ""},
+		{"greedy", tagOptions{RestFlag: true}, ""},
+
+		// Strict
+		{"strict", tagOptions{StrictFlag: true}, ""},
+
 		// Root
 		{"root", tagOptions{RootFlag: true}, ""},
 
@@ -163,19 +231,35 @@ func TestParseTag(t *testing.T) {
 }
 
 func TestMustParseTagPanicsOnError(t *testing.T) {
+	type badTag struct {
+		Test int `struct:"???"`
+	}
+
 	defer func() {
-		if r := recover(); r == nil {
+		r := recover()
+		if r == nil {
 			t.Error("Invalid tag did not panic.")
 		}
+		err, ok := r.(TagError)
+		if assert.True(t, ok) {
+			assert.Equal(t, "Test", err.Field)
+			assert.Contains(t, err.Error(), "restruct.badTag.Test: ")
+		}
 	}()
-	mustParseTag("???")
+	typ := reflect.TypeOf(badTag{})
+	mustParseTag(typ, typ.Field(0))
 }
 
 func TestMustParseTagReturnsOnSuccess(t *testing.T) {
+	type goodTag struct {
+		Test int `struct:"[128]byte,little,sizeof=Test"`
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			t.Error("Valid tag panicked.")
 		}
 	}()
-	mustParseTag("[128]byte,little,sizeof=Test")
+	typ := reflect.TypeOf(goodTag{})
+	mustParseTag(typ, typ.Field(0))
 }
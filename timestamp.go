@@ -0,0 +1,264 @@
+package restruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ErrInvalidTime is returned when a time encoding is used on an invalid type.
+var ErrInvalidTime = errors.New("time specified on non-time type")
+
+// timeFormat specifies the binary encoding of a time.Time or time.Duration.
+type timeFormat int
+
+const (
+	timeNone timeFormat = iota
+	timeUnix
+	timeUnixMilli
+	timeUnixMicro
+	timeUnixNano
+	timeDOS
+	timeFiletime
+	timeNTP
+	timeHFS
+	timeGPS
+	timeGPSWeek
+)
+
+var timeFormats = map[string]timeFormat{
+	"unix":     timeUnix,
+	"unixms":   timeUnixMilli,
+	"unixus":   timeUnixMicro,
+	"unixns":   timeUnixNano,
+	"dos":      timeDOS,
+	"filetime": timeFiletime,
+	"ntp":      timeNTP,
+	"hfs":      timeHFS,
+	"gps":      timeGPS,
+	"gpsweek":  timeGPSWeek,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Offsets of the epochs of each encoding from the Unix epoch, in seconds.
+const (
+	filetimeEpoch = -11644473600
+	ntpEpoch      = -2208988800
+	hfsEpoch      = -2082844800
+	gpsEpoch      = 315964800
+	gpsWeek       = 7 * 24 * 60 * 60
+)
+
+// defaultType returns the binary type used for the encoding when none is
+// given.
+func (tf timeFormat) defaultType() reflect.Type {
+	switch tf {
+	case timeDOS, timeHFS, timeGPS:
+		return reflect.TypeOf(uint32(0))
+	case timeFiletime, timeNTP:
+		return reflect.TypeOf(uint64(0))
+	case timeGPSWeek:
+		return typeMap["uint48"]
+	default:
+		return reflect.TypeOf(int64(0))
+	}
+}
+
+// unit returns the duration of one unit of the encoding, for those that are
+// a simple count of units.
+func (tf timeFormat) unit() time.Duration {
+	switch tf {
+	case timeUnixMilli:
+		return time.Millisecond
+	case timeUnixMicro:
+		return time.Microsecond
+	case timeUnixNano:
+		return time.Nanosecond
+	case timeFiletime:
+		return 100 * time.Nanosecond
+	default:
+		return time.Second
+	}
+}
+
+// epoch returns the offset of the epoch of the encoding from the Unix epoch.
+func (tf timeFormat) epoch() int64 {
+	switch tf {
+	case timeFiletime:
+		return filetimeEpoch
+	case timeNTP:
+		return ntpEpoch
+	case timeHFS:
+		return hfsEpoch
+	case timeGPS, timeGPSWeek:
+		return gpsEpoch
+	default:
+		return 0
+	}
+}
+
+// validTimeType returns true if the encoding can be used with the given
+// native and binary types.
+func validTimeType(tf timeFormat, native, binary reflect.Type, bitSize uint8) bool {
+	for native.Kind() == reflect.Slice || native.Kind() == reflect.Array {
+		native = native.Elem()
+	}
+	if native != timeType && (native != durationType || tf == timeDOS) {
+		return false
+	}
+	bits, _, ok := fixedBits(fixedIntType(binary), bitSize)
+	return ok && (tf != timeGPSWeek || bits > 32)
+}
+
+// likeType returns elem wrapped in the same arrays and slices as typ.
+func likeType(typ, elem reflect.Type) reflect.Type {
+	switch typ.Kind() {
+	case reflect.Array:
+		return reflect.ArrayOf(typ.Len(), likeType(typ.Elem(), elem))
+	case reflect.Slice:
+		return reflect.SliceOf(likeType(typ.Elem(), elem))
+	default:
+		return elem
+	}
+}
+
+// split returns the raw value as a count of seconds and nanoseconds since the
+// epoch of the encoding.
+func (tf timeFormat) split(x uint64, bits int, signed bool) (sec int64, nsec int64) {
+	switch tf {
+	case timeNTP:
+		if bits < 64 {
+			return int64(x), 0
+		}
+		frac := x & 0xffffffff
+		return int64(x >> 32), int64((frac*1e9 + 1<<31) >> 32)
+	case timeGPSWeek:
+		return int64(x>>32)*gpsWeek + int64(x&0xffffffff), 0
+	}
+
+	n := int64(x)
+	if !signed && bits == 64 && n < 0 {
+		// Unsigned values beyond the range of int64.
+		per := uint64(time.Second / tf.unit())
+		return int64(x / per), int64(x%per) * int64(tf.unit())
+	}
+	per := int64(time.Second / tf.unit())
+	sec, rem := n/per, n%per
+	if rem < 0 {
+		sec, rem = sec-1, rem+per
+	}
+	return sec, rem * int64(tf.unit())
+}
+
+// join returns the raw value for a count of seconds and nanoseconds since
+// the epoch of the encoding, or false if it does not fit.
+func (tf timeFormat) join(sec int64, nsec int64, bits int, signed bool) (uint64, bool) {
+	fits := func(n int64) bool {
+		switch {
+		case signed:
+			return bits == 64 || n >= -1<<uint(bits-1) && n < 1<<uint(bits-1)
+		case n < 0:
+			return false
+		default:
+			return bits == 64 || n < 1<<uint(bits)
+		}
+	}
+
+	switch tf {
+	case timeNTP:
+		if bits < 64 {
+			return uint64(sec), fits(sec)
+		}
+		frac := (uint64(nsec)<<32 + 5e8) / 1e9
+		return uint64(sec)<<32 + frac, sec >= 0 && sec < 1<<32
+	case timeGPSWeek:
+		week, sow := sec/gpsWeek, sec%gpsWeek
+		if sow < 0 {
+			week, sow = week-1, sow+gpsWeek
+		}
+		return uint64(week)<<32 | uint64(sow), week >= 0 && fits(week<<32)
+	}
+
+	per := int64(time.Second / tf.unit())
+	n := sec*per + nsec/int64(tf.unit())
+	if (n-nsec/int64(tf.unit()))/per != sec {
+		return 0, false
+	}
+	return uint64(n), fits(n)
+}
+
+// decodeDOS converts an MS-DOS date and time, with the date in the high 16
+// bits, to a time. Zero is the zero time.
+func decodeDOS(x uint64) time.Time {
+	if x == 0 {
+		return time.Time{}
+	}
+	date, tm := int(x>>16)&0xffff, int(x)&0xffff
+	return time.Date(
+		1980+date>>9, time.Month(date>>5&0xf), date&0x1f,
+		tm>>11, tm>>5&0x3f, tm&0x1f*2, 0, time.UTC)
+}
+
+// encodeDOS converts a time to an MS-DOS date and time. Odd seconds and
+// fractions of seconds are truncated.
+func encodeDOS(t time.Time) (uint64, bool) {
+	if t.IsZero() {
+		return 0, true
+	}
+	if t.Year() < 1980 || t.Year() > 2107 {
+		return 0, false
+	}
+	date := (t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day()
+	tm := t.Hour()<<11 | t.Minute()<<5 | t.Second()/2
+	return uint64(date)<<16 | uint64(tm), true
+}
+
+// setTime sets a time.Time or time.Duration from a raw value.
+func setTime(f field, v reflect.Value, x uint64) {
+	bits, signed, _ := fixedBits(f.BinaryType, f.BitSize)
+	if !signed && bits < 64 {
+		x &= 1<<uint(bits) - 1
+	}
+
+	if f.Time == timeDOS {
+		v.Set(reflect.ValueOf(decodeDOS(x)))
+		return
+	}
+
+	sec, nsec := f.Time.split(x, bits, signed)
+	if v.Type() == durationType {
+		v.SetInt(sec*int64(time.Second) + nsec)
+		return
+	}
+	v.Set(reflect.ValueOf(time.Unix(sec+f.Time.epoch(), nsec).UTC()))
+}
+
+// timeBits returns the raw value of a time.Time or time.Duration.
+func timeBits(f field, v reflect.Value) uint64 {
+	bits, signed, _ := fixedBits(f.BinaryType, f.BitSize)
+
+	var (
+		x  uint64
+		ok bool
+	)
+	if v.Type() == durationType {
+		d := time.Duration(v.Int())
+		sec, nsec := int64(d/time.Second), int64(d%time.Second)
+		if nsec < 0 {
+			sec, nsec = sec-1, nsec+int64(time.Second)
+		}
+		x, ok = f.Time.join(sec, nsec, bits, signed)
+	} else if t := v.Interface().(time.Time); f.Time == timeDOS {
+		x, ok = encodeDOS(t)
+	} else {
+		x, ok = f.Time.join(t.Unix()-f.Time.epoch(), int64(t.Nanosecond()), bits, signed)
+	}
+
+	if !ok {
+		panic(fmt.Errorf("%s: %v out of range for time encoding", f.Name, v.Interface()))
+	}
+	return x
+}
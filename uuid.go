@@ -0,0 +1,129 @@
+package restruct
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-restruct/restruct/expr"
+)
+
+// ErrInvalidUUID is returned when a UUID layout is used on an invalid type.
+var ErrInvalidUUID = errors.New("uuid specified on invalid type")
+
+// uuidFormat specifies the binary layout of a UUID.
+type uuidFormat int
+
+const (
+	// uuidNone is the zero value, for fields that are not UUIDs.
+	uuidNone uuidFormat = iota
+
+	// uuidRFC is the RFC 4122 layout, with all groups big endian.
+	uuidRFC
+
+	// uuidMicrosoft is the GUID layout, with the first three groups little
+	// endian and the rest big endian.
+	uuidMicrosoft
+)
+
+func init() {
+	exprStdLib["uuid"] = expr.ValueOf(parseUUIDExpr)
+}
+
+// validUUIDType returns true if a UUID layout can be used with the given
+// native and binary types.
+func validUUIDType(native, binary reflect.Type) bool {
+	isBytes := func(t reflect.Type) bool {
+		if _, _, ok := wideInt(t); ok {
+			return false
+		}
+		return t.Kind() == reflect.Array && t.Len() == 16 && t.Elem().Kind() == reflect.Uint8
+	}
+	return isBytes(binary) && (native.Kind() == reflect.String || isBytes(native))
+}
+
+// swap converts between the RFC 4122 byte order and the binary layout. It is
+// its own inverse.
+func (uf uuidFormat) swap(b []byte) {
+	if uf == uuidMicrosoft {
+		reverseBytes(b[0:4])
+		reverseBytes(b[4:6])
+		reverseBytes(b[6:8])
+	}
+}
+
+// formatUUID returns the canonical lowercase form of a UUID.
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+// parseUUID parses a UUID in canonical form, optionally in braces or with
+// a urn:uuid: prefix. Case is ignored.
+func parseUUID(s string) ([]byte, error) {
+	t := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(t, "urn:uuid:"):
+		t = t[len("urn:uuid:"):]
+	case strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}"):
+		t = t[1 : len(t)-1]
+	}
+	if len(t) != 36 || t[8] != '-' || t[13] != '-' || t[18] != '-' || t[23] != '-' {
+		return nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	b, err := hex.DecodeString(t[0:8] + t[9:13] + t[14:18] + t[19:23] + t[24:36])
+	if err != nil {
+		return nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	return b, nil
+}
+
+// parseUUIDExpr implements the uuid expression function, which returns the
+// bytes of a UUID literal for comparison with [16]byte fields.
+func parseUUIDExpr(s string) [16]byte {
+	var u [16]byte
+	b, err := parseUUID(s)
+	if err != nil {
+		panic(err)
+	}
+	copy(u[:], b)
+	return u
+}
+
+// readUUID reads a UUID into v.
+func (d *decoder) readUUID(f field, v reflect.Value) {
+	b := make([]byte, 16)
+	d.readBits(f, b)
+	f.UUID.swap(b)
+
+	if v.Kind() == reflect.String {
+		v.SetString(formatUUID(b))
+		return
+	}
+	for i := range b {
+		v.Index(i).SetUint(uint64(b[i]))
+	}
+}
+
+// writeUUID writes v as a UUID. An empty string is the nil UUID.
+func (e *encoder) writeUUID(f field, v reflect.Value) {
+	b := make([]byte, 16)
+	switch {
+	case v.Kind() == reflect.String && v.Len() == 0:
+	case v.Kind() == reflect.String:
+		u, err := parseUUID(v.String())
+		if err != nil {
+			panic(fmt.Errorf("%s: %v", f.Name, err))
+		}
+		copy(b, u)
+	default:
+		for i := range b {
+			b[i] = byte(v.Index(i).Uint())
+		}
+	}
+
+	f.UUID.swap(b)
+	e.writeBits(f, b)
+}
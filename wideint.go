@@ -0,0 +1,318 @@
+package restruct
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/go-restruct/restruct/expr"
+)
+
+// ubyte and sbyte are the element types of the unsigned and signed wide
+// integer types, such as uint24 and int24. These are represented as arrays of
+// bytes so that their size is derived from the type like any other array.
+type ubyte uint8
+type sbyte uint8
+
+var ubyteType = reflect.TypeOf(ubyte(0))
+var sbyteType = reflect.TypeOf(sbyte(0))
+
+var bigIntType = reflect.TypeOf(big.Int{})
+var bigIntPtrType = reflect.TypeOf(&big.Int{})
+var uint128Type = reflect.TypeOf([2]uint64{})
+
+func init() {
+	for _, n := range []int{3, 5, 6, 7, 16} {
+		typeMap[fmt.Sprintf("uint%d", n*8)] = reflect.ArrayOf(n, ubyteType)
+		typeMap[fmt.Sprintf("int%d", n*8)] = reflect.ArrayOf(n, sbyteType)
+	}
+}
+
+// wideInt returns the size in bytes and signedness of a wide integer type.
+func wideInt(typ reflect.Type) (size int, signed bool, ok bool) {
+	if typ.Kind() != reflect.Array {
+		return 0, false, false
+	}
+	switch typ.Elem() {
+	case ubyteType:
+		return typ.Len(), false, true
+	case sbyteType:
+		return typ.Len(), true, true
+	}
+	return 0, false, false
+}
+
+// isBigNative returns true if typ can only hold a wide integer by way of
+// big.Int: big.Int itself, and byte or [2]uint64 arrays holding its bits.
+func isBigNative(typ reflect.Type) bool {
+	switch {
+	case typ == bigIntType, typ == bigIntPtrType, typ == uint128Type:
+		return true
+	case typ.Kind() == reflect.Array:
+		return typ.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+// bigFromBytes interprets a big endian byte slice as an integer.
+func bigFromBytes(b []byte, signed bool) *big.Int {
+	x := new(big.Int).SetBytes(b)
+	if signed && len(b) > 0 && b[0]&0x80 != 0 {
+		x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return x
+}
+
+// bigToBytes returns the big endian two's complement representation of x in
+// size bytes. It returns false if x does not fit.
+func bigToBytes(x *big.Int, size int, signed bool) ([]byte, bool) {
+	bits := uint(size * 8)
+	if signed {
+		limit := new(big.Int).Lsh(big.NewInt(1), bits-1)
+		if x.Cmp(limit) >= 0 || x.Cmp(limit.Neg(limit)) < 0 {
+			return nil, false
+		}
+	} else if x.Sign() < 0 || x.BitLen() > int(bits) {
+		return nil, false
+	}
+
+	u := x
+	if x.Sign() < 0 {
+		u = new(big.Int).Lsh(big.NewInt(1), bits)
+		u.Add(u, x)
+	}
+
+	b := make([]byte, size)
+	ub := u.Bytes()
+	copy(b[size-len(ub):], ub)
+	return b, true
+}
+
+// bigFromNative returns the integer value of a native field holding a wide
+// integer. Byte arrays and [2]uint64 are taken as big endian bits.
+func bigFromNative(v reflect.Value, signed bool) *big.Int {
+	switch {
+	case v.Type() == bigIntType:
+		x := v.Interface().(big.Int)
+		return new(big.Int).Set(&x)
+	case v.Type() == bigIntPtrType:
+		if v.IsNil() {
+			return new(big.Int)
+		}
+		return new(big.Int).Set(v.Interface().(*big.Int))
+	case v.Type() == uint128Type:
+		b := make([]byte, 16)
+		binary.BigEndian.PutUint64(b[:8], v.Index(0).Uint())
+		binary.BigEndian.PutUint64(b[8:], v.Index(1).Uint())
+		return bigFromBytes(b, signed)
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint())
+	case reflect.Array:
+		b := make([]byte, v.Len())
+		for i := range b {
+			b[i] = byte(v.Index(i).Uint())
+		}
+		return bigFromBytes(b, signed)
+	default:
+		panic(fmt.Errorf("invalid wide integer type %s", v.Type()))
+	}
+}
+
+// setBigNative stores an integer into a native field, panicking if it does
+// not fit.
+func setBigNative(f field, v reflect.Value, x *big.Int, signed bool) {
+	overflow := func() {
+		panic(fmt.Errorf("%s: value %s overflows %s", f.Name, x, v.Type()))
+	}
+
+	switch {
+	case v.Type() == bigIntType:
+		v.Set(reflect.ValueOf(*x))
+		return
+	case v.Type() == bigIntPtrType:
+		v.Set(reflect.ValueOf(x))
+		return
+	case v.Type() == uint128Type:
+		b, ok := bigToBytes(x, 16, signed)
+		if !ok {
+			overflow()
+		}
+		v.Index(0).SetUint(binary.BigEndian.Uint64(b[:8]))
+		v.Index(1).SetUint(binary.BigEndian.Uint64(b[8:]))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !x.IsInt64() || v.OverflowInt(x.Int64()) {
+			overflow()
+		}
+		v.SetInt(x.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !x.IsUint64() || v.OverflowUint(x.Uint64()) {
+			overflow()
+		}
+		v.SetUint(x.Uint64())
+	case reflect.Array:
+		b, ok := bigToBytes(x, v.Len(), signed)
+		if !ok {
+			overflow()
+		}
+		for i := range b {
+			v.Index(i).SetUint(uint64(b[i]))
+		}
+	default:
+		panic(fmt.Errorf("%s: invalid wide integer type %s", f.Name, v.Type()))
+	}
+}
+
+// exprValueOf returns the expression value of a field. Big integers are
+// converted to int64 or uint64 when they fit, so that they can take part in
+// arithmetic and comparisons.
+func exprValueOf(v reflect.Value) expr.Value {
+	var x *big.Int
+	switch v.Type() {
+	case bigIntType:
+		b := v.Interface().(big.Int)
+		x = &b
+	case bigIntPtrType:
+		x = v.Interface().(*big.Int)
+	}
+	if x != nil {
+		if x.IsInt64() {
+			return expr.ValueOf(x.Int64())
+		}
+		if x.IsUint64() {
+			return expr.ValueOf(x.Uint64())
+		}
+	}
+	return expr.ValueOf(v.Interface())
+}
+
+// exprFieldValue returns the expression value of a field. Wide integers held
+// in byte arrays, [2]uint64 or big.Int are converted to int64 or uint64, by
+// the signedness of the binary type, when they fit.
+func exprFieldValue(f field, v reflect.Value) expr.Value {
+	if !isBigNative(v.Type()) || f.BinaryType == nil {
+		return exprValueOf(v)
+	}
+	if _, signed, ok := wideInt(f.BinaryType); ok {
+		x := bigFromNative(v, signed)
+		if signed && x.IsInt64() {
+			return expr.ValueOf(x.Int64())
+		}
+		if !signed && x.IsUint64() {
+			return expr.ValueOf(x.Uint64())
+		}
+	}
+	return exprValueOf(v)
+}
+
+// exprFieldType returns the expression type of a field, as in
+// exprFieldValue.
+func exprFieldType(f field) expr.Type {
+	if !isBigNative(f.NativeType) || f.BinaryType == nil {
+		return exprTypeOf(f.NativeType)
+	}
+	if _, signed, ok := wideInt(f.BinaryType); ok {
+		if signed {
+			return expr.NewPrimitiveType(expr.Int64)
+		}
+		return expr.NewPrimitiveType(expr.Uint64)
+	}
+	return exprTypeOf(f.NativeType)
+}
+
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// readWide reads a wide integer of the given size into v.
+func (d *decoder) readWide(f field, v reflect.Value, size int, signed bool) {
+	if size >= 8 || isBigNative(v.Type()) {
+		b := make([]byte, size)
+		d.readBits(f, b)
+		if d.order == binary.LittleEndian {
+			reverseBytes(b)
+		}
+		setBigNative(f, v, bigFromBytes(b, signed), signed)
+		return
+	}
+
+	b := make([]byte, 8)
+
+	var x uint64
+	if d.order == binary.LittleEndian {
+		d.readBits(f, b[:size])
+		x = binary.LittleEndian.Uint64(b)
+	} else {
+		d.readBits(f, b[8-size:])
+		x = binary.BigEndian.Uint64(b)
+	}
+
+	if signed {
+		d.setInt(f, v, int64(extendWide(x, size)))
+	} else {
+		d.setUint(f, v, x)
+	}
+}
+
+func extendWide(x uint64, size int) uint64 {
+	bits := uint(size * 8)
+	if x&(1<<(bits-1)) != 0 {
+		x |= ^uint64(0) << bits
+	}
+	return x
+}
+
+// writeWide writes v as a wide integer of the given size.
+func (e *encoder) writeWide(f field, v reflect.Value, size int, signed bool) {
+	bits := uint(size * 8)
+
+	if size >= 8 || isBigNative(v.Type()) {
+		x := bigFromNative(v, signed)
+		b, ok := bigToBytes(x, size, signed)
+		if !ok {
+			if signed {
+				panic(fmt.Errorf("%s: value %s out of range for int%d", f.Name, x, bits))
+			}
+			panic(fmt.Errorf("%s: value %s out of range for uint%d", f.Name, x, bits))
+		}
+		if e.order == binary.LittleEndian {
+			reverseBytes(b)
+		}
+		e.writeBits(f, b)
+		return
+	}
+
+	var x uint64
+	if signed {
+		i := e.intFromField(f, v)
+		if i < -1<<(bits-1) || i >= 1<<(bits-1) {
+			panic(fmt.Errorf("%s: value %d out of range for int%d", f.Name, i, bits))
+		}
+		x = uint64(i)
+	} else {
+		x = e.uintFromField(f, v)
+		if x >= 1<<bits {
+			panic(fmt.Errorf("%s: value %d out of range for uint%d", f.Name, x, bits))
+		}
+	}
+
+	b := make([]byte, 8)
+	if e.order == binary.LittleEndian {
+		binary.LittleEndian.PutUint64(b, x)
+		e.writeBits(f, b[:size])
+	} else {
+		binary.BigEndian.PutUint64(b, x)
+		e.writeBits(f, b[8-size:])
+	}
+}